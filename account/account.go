@@ -0,0 +1,113 @@
+// Package account creates users and signs them in. Passwords are stored as
+// bcrypt hashes, users signing in through SSO have none. Session tokens are
+// generated and hashed like API keys, so only their SHA-256 is stored.
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// MinPasswordLength is the shortest password accepted. bcrypt ignores
+// anything past 72 bytes, so longer passwords are rejected.
+const MinPasswordLength = 8
+
+var (
+	ErrInvalidLogin = errors.New("invalid username or password")
+	ErrBadPassword  = fmt.Errorf("password must be %d to 72 bytes long", MinPasswordLength)
+)
+
+// dummyHash is compared against when a username does not exist, so a failed
+// login takes as long whether or not the user exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// HashPassword returns the bcrypt hash of password.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength || len(password) > 72 {
+		return "", ErrBadPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// ParseRole validates a role, defaulting to viewer.
+func ParseRole(role string) (string, error) {
+	role = strings.TrimSpace(role)
+	if role == "" {
+		return model.RoleViewer, nil
+	}
+	if !slices.Contains(model.Roles, role) {
+		return "", fmt.Errorf("unknown role %q, must be one of %s", role, strings.Join(model.Roles, ", "))
+	}
+	return role, nil
+}
+
+// Create stores a user with the username and role of u in the context's team.
+func Create(ctx context.Context, users store.Users, u model.User, password string) (*model.User, error) {
+	if strings.TrimSpace(u.Username) == "" {
+		return nil, errors.New("username is required")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return users.CreateUser(ctx, &u, hash)
+}
+
+// Login checks a user's password and starts a session lasting ttl. The
+// returned token is not stored anywhere.
+func Login(ctx context.Context, users store.Users, username, password string, ttl time.Duration, now time.Time) (token string, u *model.User, expiresAt time.Time, err error) {
+	u, hash, err := users.GetUserLogin(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", nil, time.Time{}, ErrInvalidLogin
+	}
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return "", nil, time.Time{}, ErrInvalidLogin
+	}
+
+	token, expiresAt, err = StartSession(ctx, users, u, ttl, now)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	return token, u, expiresAt, nil
+}
+
+// StartSession starts a session of u lasting ttl, for users who were already
+// authenticated. The returned token is not stored anywhere.
+func StartSession(ctx context.Context, users store.Users, u *model.User, ttl time.Duration, now time.Time) (token string, expiresAt time.Time, err error) {
+	token, tokenHash, _, err := apikey.Generate()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt = now.Add(ttl)
+	if err := users.CreateSession(ctx, u.ID, tokenHash, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// CheckPassword reports whether password is the user's current password.
+func CheckPassword(ctx context.Context, users store.Users, username, password string) (bool, error) {
+	_, hash, err := users.GetUserLogin(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, nil
+}
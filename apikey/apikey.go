@@ -0,0 +1,122 @@
+// Package apikey generates API keys and hashes them the way they are stored.
+// Only the SHA-256 hash and a short prefix of a key are kept, so the
+// plaintext is shown once when the key is created.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// Generate returns a random 32-byte key along with its hash and prefix.
+func Generate() (plain, hash, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	plain = hex.EncodeToString(b)
+	return plain, Hash(plain), plain[:8], nil
+}
+
+// Create generates a key and stores it with the name, scopes, patterns and
+// expiry of k. Empty patterns allow any monitor. The returned plaintext key is
+// not stored anywhere.
+func Create(ctx context.Context, keys store.ApiKeys, k model.ApiKey) (plain string, key *model.ApiKey, err error) {
+	plain, hash, prefix, err := Generate()
+	if err != nil {
+		return "", nil, err
+	}
+
+	k.KeyPrefix = prefix
+	if k.MonitorPattern == "" {
+		k.MonitorPattern = "*"
+	}
+	if k.CheckTypePattern == "" {
+		k.CheckTypePattern = "*"
+	}
+	key, err = keys.CreateApiKey(ctx, &k, hash)
+	if err != nil {
+		return "", nil, err
+	}
+	return plain, key, nil
+}
+
+// Hash returns the hex SHA-256 of key, as stored in api_keys.key_hash.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScopes validates a list of scopes, defaulting to ingest-only.
+func ParseScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return []string{model.ScopeIngest}, nil
+	}
+
+	var parsed []string
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if !slices.Contains(model.Scopes, s) {
+			return nil, fmt.Errorf("unknown scope %q, must be one of %s", s, strings.Join(model.Scopes, ", "))
+		}
+		if !slices.Contains(parsed, s) {
+			parsed = append(parsed, s)
+		}
+	}
+	return parsed, nil
+}
+
+var (
+	// ErrRevoked is returned when rotating a revoked key.
+	ErrRevoked = errors.New("API key is revoked")
+	// ErrExpired is returned when rotating an expired key, whose replacement
+	// would outlive it.
+	ErrExpired = errors.New("API key is expired")
+)
+
+// Rotate issues a replacement for the key with this ID, with the same team,
+// name, scopes and patterns. The old key keeps working for overlap so hosts can be
+// switched over, then expires. If the old key had a lifetime, the new one
+// gets the same lifetime.
+func Rotate(ctx context.Context, st store.Store, id string, overlap time.Duration, now time.Time) (plain string, key *model.ApiKey, err error) {
+	err = st.InTx(ctx, func(ctx context.Context) error {
+		old, err := st.GetApiKeyByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !old.IsActive {
+			return ErrRevoked
+		}
+		if old.ExpiresAt != nil && !old.ExpiresAt.After(now) {
+			return ErrExpired
+		}
+
+		next := model.ApiKey{
+			Name:             old.Name,
+			Scopes:           old.Scopes,
+			MonitorPattern:   old.MonitorPattern,
+			CheckTypePattern: old.CheckTypePattern,
+		}
+		if old.ExpiresAt != nil {
+			expiresAt := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+			next.ExpiresAt = &expiresAt
+		}
+		// The replacement belongs to the same team, whoever rotates it
+		if plain, key, err = Create(store.WithTeam(ctx, old.TeamID), st, next); err != nil {
+			return err
+		}
+
+		return st.ExpireApiKey(ctx, id, now.Add(overlap))
+	})
+	return plain, key, err
+}
@@ -0,0 +1,105 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/memory"
+)
+
+func newStore(t *testing.T) (*memory.Store, *clock.Fake, context.Context) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	return memory.New(clk), clk, store.Unscoped(context.Background())
+}
+
+// active reports whether a plaintext key is accepted.
+func active(t *testing.T, st store.Store, plain string) bool {
+	t.Helper()
+	_, err := st.GetActiveApiKey(store.Unscoped(context.Background()), Hash(plain))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestRotateOverlap(t *testing.T) {
+	st, clk, ctx := newStore(t)
+	expiresAt := clk.Now().Add(30 * 24 * time.Hour)
+	oldPlain, old, err := Create(ctx, st, model.ApiKey{Name: "web", Scopes: []string{model.ScopeIngest}, MonitorPattern: "web-*", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(10 * 24 * time.Hour)
+	plain, key, err := Rotate(ctx, st, old.ID, time.Hour, clk.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID == old.ID || key.Name != "web" || key.MonitorPattern != "web-*" || key.TeamID != old.TeamID {
+		t.Fatalf("replacement is %+v, want a new key like %+v", key, old)
+	}
+	// The replacement gets the old key's lifetime from now
+	if key.ExpiresAt == nil || !key.ExpiresAt.Equal(clk.Now().Add(30*24*time.Hour)) {
+		t.Fatalf("replacement expires at %v, want in 30 days", key.ExpiresAt)
+	}
+
+	clk.Advance(time.Hour - time.Second)
+	if !active(t, st, oldPlain) || !active(t, st, plain) {
+		t.Fatal("both keys should work during the overlap")
+	}
+	clk.Advance(time.Second)
+	if active(t, st, oldPlain) || !active(t, st, plain) {
+		t.Fatal("only the replacement should work after the overlap")
+	}
+}
+
+func TestRotateKeepsEarlierExpiry(t *testing.T) {
+	st, clk, ctx := newStore(t)
+	expiresAt := clk.Now().Add(time.Hour)
+	oldPlain, old, err := Create(ctx, st, model.ApiKey{Name: "web", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An overlap longer than the key has left doesn't extend it
+	if _, _, err := Rotate(ctx, st, old.ID, 24*time.Hour, clk.Now()); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Hour)
+	if active(t, st, oldPlain) {
+		t.Fatal("old key works past its own expiry")
+	}
+}
+
+func TestRotateRefusesRevokedAndExpiredKeys(t *testing.T) {
+	st, clk, ctx := newStore(t)
+	_, revoked, err := Create(ctx, st, model.ApiKey{Name: "revoked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RevokeApiKey(ctx, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := clk.Now().Add(time.Hour)
+	_, expired, err := Create(ctx, st, model.ApiKey{Name: "expired", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Hour)
+
+	if _, _, err := Rotate(ctx, st, revoked.ID, time.Hour, clk.Now()); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("rotating a revoked key: %v, want ErrRevoked", err)
+	}
+	if _, _, err := Rotate(ctx, st, expired.ID, time.Hour, clk.Now()); !errors.Is(err, ErrExpired) {
+		t.Fatalf("rotating an expired key: %v, want ErrExpired", err)
+	}
+	if keys, _ := st.GetAllApiKeys(ctx); len(keys) != 2 {
+		t.Fatalf("got %d keys, want no replacements", len(keys))
+	}
+}
@@ -0,0 +1,96 @@
+// Package clock abstracts the passage of time so that timeout, re-alert and
+// recovery logic can be driven by a fake clock instead of sleeping.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel, like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time                         { return time.Now() }
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Fake is a manually advanced clock. Channels returned by After fire once
+// Advance or Set moves the time past their deadline.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	at := f.now.Add(d)
+	if !at.After(f.now) {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{at: at, ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t and fires every waiter whose deadline has passed,
+// in deadline order.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	f.now = t
+
+	var due []waiter
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(t) {
+			pending = append(pending, w)
+		} else {
+			due = append(due, w)
+		}
+	}
+	f.waiters = pending
+	f.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, w := range due {
+		w.ch <- t
+	}
+}
+
+// Waiters returns how many After channels are still pending, letting callers
+// wait until a goroutine has gone to sleep before advancing the clock.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
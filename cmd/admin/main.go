@@ -1,55 +1,322 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/mohsen/alertinGo/account"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/clock"
 	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
 )
 
+var usage = `usage:
+  admin --name <name> [--team <team>] [--scopes ` + strings.Join(model.Scopes, ",") + `]
+        [--expires-in <duration>] [--monitor-pattern <glob>] [--check-type-pattern <glob>]
+                               create an API key (default scope: ingest,
+                               default team: default)
+  admin key revoke <id>        deactivate an API key
+  admin key rotate <id> [--overlap 24h]
+                               replace an API key, the old one keeps working
+                               for the overlap
+  admin team create <name>     create a team
+  admin team list              list teams
+  admin user create <username> [--role viewer|responder|admin] [--team <team>]
+                               create a user, the password is read from stdin
+  admin user list              list users
+  admin migrate up             apply pending migrations
+  admin migrate down [steps]   revert the latest migrations (default 1)
+  admin migrate status         list migrations and whether they are applied`
+
 func main() {
+	_ = godotenv.Load()
+
+	// The CLI administers every team
+	ctx := store.Unscoped(context.Background())
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(ctx, os.Args[2:])
+			return
+		case "key":
+			runKey(ctx, os.Args[2:])
+			return
+		case "team":
+			runTeam(ctx, os.Args[2:])
+			return
+		case "user":
+			runUser(ctx, os.Args[2:])
+			return
+		}
+	}
+
 	name := flag.String("name", "", "API key name (required)")
+	teamName := flag.String("team", "default", "name of the team owning the key")
+	scopeList := flag.String("scopes", model.ScopeIngest, "comma-separated scopes: "+strings.Join(model.Scopes, ", "))
+	monitorPattern := flag.String("monitor-pattern", "*", "monitor_name pattern allowed to send heartbeats, * matches anything")
+	checkTypePattern := flag.String("check-type-pattern", "*", "check_type pattern allowed to send heartbeats, * matches anything")
+	expiresIn := flag.Duration("expires-in", 0, "lifetime of the key, e.g. 2160h (default: never expires)")
+	flag.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
 	flag.Parse()
 
 	if *name == "" {
 		log.Fatal("--name is required")
 	}
+	if *expiresIn < 0 {
+		log.Fatal("--expires-in must not be negative")
+	}
+	scopes, err := apikey.ParseScopes(strings.Split(*scopeList, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	_ = godotenv.Load()
-
-	db.Connect()
-	db.RunMigrations()
+	st := open(ctx)
+	defer st.Close()
+	if err := st.Migrate(ctx); err != nil {
+		log.Fatal(err)
+	}
 
-	ctx := context.Background()
+	team := findTeam(ctx, st, *teamName)
 
-	// Generate a random 32-byte API key
-	keyBytes := make([]byte, 32)
-	if _, err := rand.Read(keyBytes); err != nil {
-		log.Fatalf("failed to generate API key: %v", err)
+	k := model.ApiKey{
+		Name:             *name,
+		Scopes:           scopes,
+		MonitorPattern:   *monitorPattern,
+		CheckTypePattern: *checkTypePattern,
+	}
+	if *expiresIn > 0 {
+		expiresAt := time.Now().Add(*expiresIn)
+		k.ExpiresAt = &expiresAt
 	}
-	plainKey := hex.EncodeToString(keyBytes)
-
-	// Hash it with SHA-256
-	hash := sha256.Sum256([]byte(plainKey))
-	keyHash := hex.EncodeToString(hash[:])
-	keyPrefix := plainKey[:8]
 
-	// Store the hashed key
-	apiKey, err := db.CreateApiKey(ctx, *name, keyHash, keyPrefix)
+	// Only the hash is stored
+	plainKey, apiKey, err := apikey.Create(store.WithTeam(ctx, team.ID), st, k)
 	if err != nil {
 		log.Fatalf("failed to create API key: %v", err)
 	}
 
 	fmt.Println("API key created successfully!")
-	fmt.Printf("  ID:      %s\n", apiKey.ID)
-	fmt.Printf("  Name:    %s\n", apiKey.Name)
+	printKey(apiKey, plainKey)
+}
+
+func printKey(k *model.ApiKey, plainKey string) {
+	fmt.Printf("  ID:      %s\n", k.ID)
+	fmt.Printf("  Name:    %s\n", k.Name)
+	fmt.Printf("  Team:    %s\n", k.TeamID)
+	fmt.Printf("  Scopes:  %s\n", strings.Join(k.Scopes, ", "))
+	fmt.Printf("  Allowed: %s (%s)\n", k.MonitorPattern, k.CheckTypePattern)
+	if k.ExpiresAt != nil {
+		fmt.Printf("  Expires: %s\n", k.ExpiresAt.Local().Format(time.RFC3339))
+	}
 	fmt.Printf("  API Key: %s\n", plainKey)
 	fmt.Println()
 	fmt.Println("Save this API key — it will not be shown again.")
 }
+
+// findTeam looks up a team by name, exiting if there is none.
+func findTeam(ctx context.Context, st store.Store, name string) *model.Team {
+	team, err := st.GetTeamByName(ctx, name)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Fatalf("team %q does not exist, create it with: admin team create %s", name, name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return team
+}
+
+func open(ctx context.Context) store.Store {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("DATABASE_URL is not set")
+	}
+	st, err := db.Open(ctx, dsn, clock.Real{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return st
+}
+
+func runMigrate(ctx context.Context, args []string) {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		log.Fatal(usage)
+	}
+
+	st := open(ctx)
+	defer st.Close()
+
+	switch args[0] {
+	case "up":
+		if err := st.Migrate(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				log.Fatalf("steps must be a positive number, got: %s", args[1])
+			}
+			steps = n
+		}
+		if err := st.MigrateDown(ctx, steps); err != nil {
+			log.Fatal(err)
+		}
+
+	case "status":
+		status, err := st.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Printf("%-32s %s\n", m, applied)
+		}
+	}
+}
+
+func runKey(ctx context.Context, args []string) {
+	if len(args) < 2 || (args[0] != "revoke" && args[0] != "rotate") {
+		log.Fatal(usage)
+	}
+	cmd, id := args[0], args[1]
+
+	fs := flag.NewFlagSet("key "+cmd, flag.ExitOnError)
+	overlap := fs.Duration("overlap", 24*time.Hour, "how long the old key keeps working")
+	fs.Parse(args[2:])
+	if *overlap < 0 {
+		log.Fatal("--overlap must not be negative")
+	}
+
+	st := open(ctx)
+	defer st.Close()
+	if err := st.Migrate(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	switch cmd {
+	case "revoke":
+		if err := st.RevokeApiKey(ctx, id); err != nil {
+			log.Fatalf("failed to revoke API key: %v", err)
+		}
+		fmt.Println("API key revoked.")
+
+	case "rotate":
+		plainKey, k, err := apikey.Rotate(ctx, st, id, *overlap, time.Now())
+		if err != nil {
+			log.Fatalf("failed to rotate API key: %v", err)
+		}
+		fmt.Printf("API key rotated, the old key stops working at %s.\n", time.Now().Add(*overlap).Format(time.RFC3339))
+		printKey(k, plainKey)
+	}
+}
+
+func runTeam(ctx context.Context, args []string) {
+	switch {
+	case len(args) == 2 && args[0] == "create":
+	case len(args) == 1 && args[0] == "list":
+	default:
+		log.Fatal(usage)
+	}
+
+	st := open(ctx)
+	defer st.Close()
+	if err := st.Migrate(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "create":
+		t, err := st.CreateTeam(ctx, args[1])
+		if err != nil {
+			log.Fatalf("failed to create team: %v", err)
+		}
+		fmt.Printf("Team created: %s (%s)\n", t.Name, t.ID)
+
+	case "list":
+		teams, err := st.GetAllTeams(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, t := range teams {
+			fmt.Printf("%-36s %s\n", t.ID, t.Name)
+		}
+	}
+}
+
+func runUser(ctx context.Context, args []string) {
+	switch {
+	case len(args) >= 2 && args[0] == "create":
+	case len(args) == 1 && args[0] == "list":
+	default:
+		log.Fatal(usage)
+	}
+
+	var role, teamName *string
+	if args[0] == "create" {
+		fs := flag.NewFlagSet("user create", flag.ExitOnError)
+		role = fs.String("role", model.RoleViewer, "viewer, responder or admin")
+		teamName = fs.String("team", "default", "name of the team the user belongs to")
+		fs.Parse(args[2:])
+	}
+
+	st := open(ctx)
+	defer st.Close()
+	if err := st.Migrate(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "create":
+		r, err := account.ParseRole(*role)
+		if err != nil {
+			log.Fatal(err)
+		}
+		team := findTeam(ctx, st, *teamName)
+
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			log.Fatalf("failed to read password: %v", err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+
+		u, err := account.Create(store.WithTeam(ctx, team.ID), st, model.User{Username: args[1], Role: r}, password)
+		if err != nil {
+			log.Fatalf("failed to create user: %v", err)
+		}
+		fmt.Printf("User created: %s (%s, %s in team %s)\n", u.Username, u.ID, u.Role, team.Name)
+
+	case "list":
+		users, err := st.GetAllUsers(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, u := range users {
+			status := "active"
+			if !u.IsActive {
+				status = "inactive"
+			}
+			if u.SSO {
+				status += ", sso"
+			}
+			fmt.Printf("%-36s %-24s %-10s %-13s team %s\n", u.ID, u.Username, u.Role, status, u.TeamID)
+		}
+	}
+}
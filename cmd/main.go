@@ -1,57 +1,105 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/mohsen/alertinGo/clock"
 	"github.com/mohsen/alertinGo/db"
 	"github.com/mohsen/alertinGo/handler"
 	"github.com/mohsen/alertinGo/middleware"
+	"github.com/mohsen/alertinGo/retention"
+	"github.com/mohsen/alertinGo/sso"
 	"github.com/mohsen/alertinGo/watcher"
 )
 
 func main() {
 	_ = godotenv.Load()
 
-	db.Connect()
-	db.RunMigrations()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	watcher.Start()
-
-	r := gin.Default()
-
-	api := r.Group("/api/v1")
-	{
-		api.GET("/health", func(c *gin.Context) {
-			c.JSON(200, gin.H{"status": "ok"})
-		})
-
-		api.POST("/heartbeat", middleware.RequireAPIKey(), handler.PostHeartbeat)
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("DATABASE_URL is not set")
+	}
+	st, err := db.Open(ctx, dsn, clock.Real{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := st.Migrate(ctx); err != nil {
+		log.Fatal(err)
+	}
 
-		api.GET("/api-keys", handler.ListApiKeys)
-		api.DELETE("/api-keys/:id", handler.DeleteApiKey)
+	w := watcher.New(st, clock.Real{})
+	w.Start(ctx)
+	retention.Start(ctx, st)
 
-		api.GET("/monitors", handler.GetMonitors)
-		api.GET("/monitors/:id", handler.GetMonitor)
-		api.PUT("/monitors/:id", handler.UpdateMonitor)
-		api.DELETE("/monitors/:id", handler.DeleteMonitor)
+	h := handler.New(st, w, clock.Real{}, sso.New(ctx, st))
 
-		api.GET("/channels", handler.GetChannels)
-		api.POST("/channels", handler.CreateChannel)
-		api.DELETE("/channels/:id", handler.DeleteChannel)
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Println("ADMIN_TOKEN is not set, the management API only accepts user sessions and API keys")
+	}
+	auth := middleware.NewAuth(st, adminToken, clock.Real{})
+	auth.Start(ctx)
 
-		api.GET("/notification-logs", handler.GetNotificationLogs)
+	r := gin.Default()
+	// Client IPs are only taken from X-Forwarded-For when the request comes
+	// through one of these proxies, otherwise any client could pick its own
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
 	}
 
+	h.Register(r, auth)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("server starting on :%s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("server starting on :%s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("shutting down, draining requests...")
+
+	// Finish in-flight requests first, they may still queue heartbeats for the watcher
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+
+	auth.Stop()
+	w.Stop()
+	st.Close()
+	log.Println("shutdown complete")
+}
+
+// trustedProxies reads the comma-separated TRUSTED_PROXIES addresses and
+// CIDRs, trusting none when it is unset.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
 	}
+	return proxies
 }
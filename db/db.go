@@ -2,91 +2,240 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/leader"
 	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
 )
 
-var Pool *pgxpool.Pool
+// Postgres is the store.Store implementation backed by a Postgres database.
+type Postgres struct {
+	pool *pgxpool.Pool
+	// clock is the time source for every timestamp written or compared by the
+	// queries below, instead of the database's now().
+	clock clock.Clock
+}
 
-func Connect() {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		log.Fatal("DATABASE_URL is not set")
-	}
+var _ store.Store = (*Postgres)(nil)
 
-	var err error
-	Pool, err = pgxpool.New(context.Background(), dsn)
+// Connect opens a connection pool to the database at dsn.
+func Connect(ctx context.Context, dsn string, clk clock.Clock) (*Postgres, error) {
+	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
-		log.Fatalf("unable to connect to database: %v", err)
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
 
-	if err := Pool.Ping(context.Background()); err != nil {
-		log.Fatalf("unable to ping database: %v", err)
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to ping database: %w", err)
 	}
 
 	log.Println("connected to database")
+	return &Postgres{pool: pool, clock: clk}, nil
+}
+
+func (p *Postgres) Close() {
+	p.pool.Close()
+}
+
+// NewElector campaigns for leadership with a Postgres advisory lock.
+func (p *Postgres) NewElector(name string, lockID int64) store.Elector {
+	return leader.New(p.pool, name, lockID)
 }
 
-func RunMigrations() {
-	migrations := []string{
-		"migrations/001_initial.sql",
-		"migrations/002_api_keys.sql",
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type txKey struct{}
+
+// conn returns the transaction started by InTx for this context, or the pool.
+func (p *Postgres) conn(ctx context.Context) querier {
+	store.CheckScope(ctx)
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
 	}
+	return p.pool
+}
 
-	for _, file := range migrations {
-		sql, err := os.ReadFile(file)
-		if err != nil {
-			log.Fatalf("failed to read migration file %s: %v", file, err)
-		}
+func (p *Postgres) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	store.CheckScope(ctx)
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// notFound translates pgx.ErrNoRows into store.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// teamArg returns the team ctx is scoped to as a query argument, NULL if it
+// isn't scoped.
+func teamArg(ctx context.Context) *string {
+	if id := store.TeamID(ctx); id != "" {
+		return &id
+	}
+	return nil
+}
 
-		if _, err := Pool.Exec(context.Background(), string(sql)); err != nil {
-			log.Printf("migration warning (%s, may already be applied): %v", file, err)
-		} else {
-			log.Printf("migration applied: %s", file)
+// inTeam is a condition matching rows whose column holds the team passed as
+// parameter n, or every row if that parameter is NULL. See teamArg.
+func inTeam(column string, n int) string {
+	return fmt.Sprintf("($%d::uuid IS NULL OR %s = $%d::uuid)", n, column, n)
+}
+
+// monitorInTeam is inTeam for rows owned by the monitor in column.
+func monitorInTeam(column string, n int) string {
+	return fmt.Sprintf("($%d::uuid IS NULL OR %s IN (SELECT id FROM monitors WHERE team_id = $%d::uuid))", n, column, n)
+}
+
+// --- Teams ---
+
+const teamColumns = `id, name, created_at`
+
+func (p *Postgres) CreateTeam(ctx context.Context, name string) (*model.Team, error) {
+	var t model.Team
+	err := p.conn(ctx).QueryRow(ctx, `INSERT INTO teams (name, created_at) VALUES ($1, $2) RETURNING `+teamColumns,
+		name, p.clock.Now()).Scan(&t.ID, &t.Name, &t.CreatedAt)
+	if isUniqueViolation(err) {
+		return nil, store.ErrTeamExists
+	}
+	return &t, err
+}
+
+func (p *Postgres) GetAllTeams(ctx context.Context) ([]model.Team, error) {
+	rows, err := p.conn(ctx).Query(ctx, `SELECT `+teamColumns+` FROM teams WHERE `+inTeam("id", 1)+` ORDER BY name`, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []model.Team
+	for rows.Next() {
+		var t model.Team
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt); err != nil {
+			return nil, err
 		}
+		teams = append(teams, t)
 	}
+	return teams, rows.Err()
+}
+
+func (p *Postgres) GetTeamByID(ctx context.Context, id string) (*model.Team, error) {
+	return p.getTeam(ctx, `id = $1`, id)
+}
+
+func (p *Postgres) GetTeamByName(ctx context.Context, name string) (*model.Team, error) {
+	return p.getTeam(ctx, `name = $1`, name)
+}
+
+func (p *Postgres) getTeam(ctx context.Context, condition string, arg any) (*model.Team, error) {
+	var t model.Team
+	err := p.conn(ctx).QueryRow(ctx, `SELECT `+teamColumns+` FROM teams WHERE `+condition+` AND `+inTeam("id", 2), arg, teamArg(ctx)).
+		Scan(&t.ID, &t.Name, &t.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &t, nil
 }
 
 // --- Monitors ---
 
-func UpsertMonitor(ctx context.Context, m *model.Monitor) (*model.Monitor, error) {
+const monitorColumns = `id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, alert_after_misses, recover_after_heartbeats, recovery_heartbeats, status, is_active, channel_id, server_ip, server_name, labels, last_seen_at, is_flapping, flapping_since, created_at, updated_at, created_by_key_id, team_id, silenced_until, silenced_by`
+
+// monitorColumnsAs returns monitorColumns qualified with a table alias, for joins.
+func monitorColumnsAs(alias string) string {
+	return prefixColumns(alias, monitorColumns)
+}
+
+// prefixColumns qualifies a comma-separated column list with a table alias.
+func prefixColumns(alias, columns string) string {
+	cols := strings.Split(columns, ", ")
+	for i, col := range cols {
+		cols[i] = alias + "." + col
+	}
+	return strings.Join(cols, ", ")
+}
+
+// monitorFields returns scan destinations matching monitorColumns.
+func monitorFields(m *model.Monitor) []any {
+	return []any{
+		&m.ID, &m.MonitorName, &m.CheckType, &m.Message, &m.Metadata,
+		&m.Timeout, &m.ReAlertInterval, &m.AlertAfterMisses, &m.RecoverAfterHeartbeats, &m.RecoveryHeartbeats, &m.Status, &m.IsActive, &m.ChannelID,
+		&m.ServerIP, &m.ServerName, &m.Labels, &m.LastSeenAt, &m.IsFlapping, &m.FlappingSince,
+		&m.CreatedAt, &m.UpdatedAt, &m.CreatedByKeyID, &m.TeamID, &m.SilencedUntil, &m.SilencedBy,
+	}
+}
+
+// UpsertMonitor records a heartbeat. When it brings a down monitor back up, the
+// monitors_recovered_notify trigger sends a NOTIFY on RecoveryChannel.
+func (p *Postgres) UpsertMonitor(ctx context.Context, m *model.Monitor) (*model.Monitor, error) {
 	query := `
-		INSERT INTO monitors (monitor_name, check_type, message, metadata, timeout, re_alert_interval, server_ip, server_name, last_seen_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now(), now())
-		ON CONFLICT (monitor_name, check_type)
+		INSERT INTO monitors (monitor_name, check_type, message, metadata, timeout, re_alert_interval, alert_after_misses, recover_after_heartbeats, server_ip, server_name, labels, last_seen_at, updated_at, created_by_key_id, team_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12, $13, $14)
+		ON CONFLICT (team_id, monitor_name, check_type)
 		DO UPDATE SET
 			message = EXCLUDED.message,
 			metadata = EXCLUDED.metadata,
 			timeout = EXCLUDED.timeout,
 			re_alert_interval = EXCLUDED.re_alert_interval,
+			alert_after_misses = EXCLUDED.alert_after_misses,
+			recover_after_heartbeats = EXCLUDED.recover_after_heartbeats,
 			server_ip = EXCLUDED.server_ip,
 			server_name = EXCLUDED.server_name,
-			last_seen_at = now(),
-			updated_at = now(),
-			status = 'up'
-		RETURNING id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, status, is_active, channel_id, server_ip, server_name, last_seen_at, created_at, updated_at`
+			labels = EXCLUDED.labels,
+			last_seen_at = $12,
+			updated_at = $12,
+			-- a down monitor only comes back up after recover_after_heartbeats heartbeats in a row
+			status = CASE
+				WHEN monitors.status = 'down' AND monitors.recovery_heartbeats + 1 < EXCLUDED.recover_after_heartbeats THEN 'down'
+				ELSE 'up'
+			END,
+			recovery_heartbeats = CASE
+				WHEN monitors.status = 'down' AND monitors.recovery_heartbeats + 1 < EXCLUDED.recover_after_heartbeats THEN monitors.recovery_heartbeats + 1
+				ELSE 0
+			END
+		RETURNING ` + monitorColumns
 
 	var mon model.Monitor
-	err := Pool.QueryRow(ctx, query,
+	err := p.conn(ctx).QueryRow(ctx, query,
 		m.MonitorName, m.CheckType, m.Message, m.Metadata,
-		m.Timeout, m.ReAlertInterval, m.ServerIP, m.ServerName,
-	).Scan(
-		&mon.ID, &mon.MonitorName, &mon.CheckType, &mon.Message, &mon.Metadata,
-		&mon.Timeout, &mon.ReAlertInterval, &mon.Status, &mon.IsActive, &mon.ChannelID,
-		&mon.ServerIP, &mon.ServerName, &mon.LastSeenAt, &mon.CreatedAt, &mon.UpdatedAt,
-	)
+		m.Timeout, m.ReAlertInterval, m.AlertAfterMisses, m.RecoverAfterHeartbeats,
+		m.ServerIP, m.ServerName, m.Labels, p.clock.Now(), m.CreatedByKeyID, store.OwnerTeam(ctx),
+	).Scan(monitorFields(&mon)...)
 	return &mon, err
 }
 
-func GetAllMonitors(ctx context.Context) ([]model.Monitor, error) {
-	query := `SELECT id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, status, is_active, channel_id, server_ip, server_name, last_seen_at, created_at, updated_at FROM monitors ORDER BY created_at DESC`
+func (p *Postgres) GetAllMonitors(ctx context.Context) ([]model.Monitor, error) {
+	return p.queryMonitors(ctx, `SELECT `+monitorColumns+` FROM monitors WHERE `+inTeam("team_id", 1)+` ORDER BY created_at DESC`, teamArg(ctx))
+}
 
-	rows, err := Pool.Query(ctx, query)
+func (p *Postgres) queryMonitors(ctx context.Context, query string, args ...any) ([]model.Monitor, error) {
+	rows, err := p.conn(ctx).Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -95,9 +244,7 @@ func GetAllMonitors(ctx context.Context) ([]model.Monitor, error) {
 	var monitors []model.Monitor
 	for rows.Next() {
 		var m model.Monitor
-		if err := rows.Scan(&m.ID, &m.MonitorName, &m.CheckType, &m.Message, &m.Metadata,
-			&m.Timeout, &m.ReAlertInterval, &m.Status, &m.IsActive, &m.ChannelID,
-			&m.ServerIP, &m.ServerName, &m.LastSeenAt, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		if err := rows.Scan(monitorFields(&m)...); err != nil {
 			return nil, err
 		}
 		monitors = append(monitors, m)
@@ -105,116 +252,336 @@ func GetAllMonitors(ctx context.Context) ([]model.Monitor, error) {
 	return monitors, nil
 }
 
-func GetMonitorByID(ctx context.Context, id string) (*model.Monitor, error) {
-	query := `SELECT id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, status, is_active, channel_id, server_ip, server_name, last_seen_at, created_at, updated_at FROM monitors WHERE id = $1`
+// GetMonitorByID locks the monitor for the rest of the transaction when
+// called within one, see store.Monitors.
+func (p *Postgres) GetMonitorByID(ctx context.Context, id string) (*model.Monitor, error) {
+	query := `SELECT ` + monitorColumns + ` FROM monitors WHERE id = $1 AND ` + inTeam("team_id", 2)
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		query += ` FOR UPDATE`
+	}
 
 	var m model.Monitor
-	err := Pool.QueryRow(ctx, query, id).Scan(
-		&m.ID, &m.MonitorName, &m.CheckType, &m.Message, &m.Metadata,
-		&m.Timeout, &m.ReAlertInterval, &m.Status, &m.IsActive, &m.ChannelID,
-		&m.ServerIP, &m.ServerName, &m.LastSeenAt, &m.CreatedAt, &m.UpdatedAt,
-	)
+	err := p.conn(ctx).QueryRow(ctx, query, id, teamArg(ctx)).Scan(monitorFields(&m)...)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &m, nil
 }
 
-func UpdateMonitor(ctx context.Context, id string, isActive bool, channelID *string) (*model.Monitor, error) {
-	query := `UPDATE monitors SET is_active = $1, channel_id = $2, updated_at = now() WHERE id = $3
-		RETURNING id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, status, is_active, channel_id, server_ip, server_name, last_seen_at, created_at, updated_at`
+func (p *Postgres) UpdateMonitor(ctx context.Context, id string, isActive bool, channelID *string) (*model.Monitor, error) {
+	query := `UPDATE monitors SET is_active = $1, channel_id = $2, updated_at = $4
+		WHERE id = $3 AND ` + inTeam("team_id", 5) + `
+		  AND ($2::uuid IS NULL OR EXISTS (SELECT 1 FROM notification_channels c WHERE c.id = $2 AND c.team_id = monitors.team_id))
+		RETURNING ` + monitorColumns
 
 	var m model.Monitor
-	err := Pool.QueryRow(ctx, query, isActive, channelID, id).Scan(
-		&m.ID, &m.MonitorName, &m.CheckType, &m.Message, &m.Metadata,
-		&m.Timeout, &m.ReAlertInterval, &m.Status, &m.IsActive, &m.ChannelID,
-		&m.ServerIP, &m.ServerName, &m.LastSeenAt, &m.CreatedAt, &m.UpdatedAt,
-	)
+	err := p.conn(ctx).QueryRow(ctx, query, isActive, channelID, id, p.clock.Now(), teamArg(ctx)).Scan(monitorFields(&m)...)
 	if err != nil {
+		return nil, notFound(err)
+	}
+	if _, err := p.conn(ctx).Exec(ctx, `SELECT pg_notify($1, $2)`, DeadlineChannel, m.ID); err != nil {
 		return nil, err
 	}
 	return &m, nil
 }
 
-func DeleteMonitor(ctx context.Context, id string) error {
-	_, err := Pool.Exec(ctx, `DELETE FROM monitors WHERE id = $1`, id)
+func (p *Postgres) DeleteMonitor(ctx context.Context, id string) error {
+	_, err := p.conn(ctx).Exec(ctx, `DELETE FROM monitors WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
 	return err
 }
 
-func SetMonitorStatus(ctx context.Context, id string, status string) error {
-	_, err := Pool.Exec(ctx, `UPDATE monitors SET status = $1, updated_at = now() WHERE id = $2`, status, id)
+func (p *Postgres) SilenceMonitor(ctx context.Context, id string, until *time.Time, by *string) (*model.Monitor, error) {
+	query := `UPDATE monitors SET silenced_until = $2, silenced_by = $3, updated_at = $4
+		WHERE id = $1 AND ` + inTeam("team_id", 5) + ` RETURNING ` + monitorColumns
+
+	var m model.Monitor
+	err := p.conn(ctx).QueryRow(ctx, query, id, until, by, p.clock.Now(), teamArg(ctx)).Scan(monitorFields(&m)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+// SetMonitorStatus also resets any heartbeats counted towards recovery.
+func (p *Postgres) SetMonitorStatus(ctx context.Context, id string, status string) error {
+	_, err := p.conn(ctx).Exec(ctx, `UPDATE monitors SET status = $1, recovery_heartbeats = 0, updated_at = $3 WHERE id = $2 AND `+inTeam("team_id", 4),
+		status, id, p.clock.Now(), teamArg(ctx))
 	return err
 }
 
-// --- Active monitors that are overdue ---
+// --- Heartbeat History ---
+
+func (p *Postgres) CreateHeartbeat(ctx context.Context, monitorID, message, metadata, sourceIP string) error {
+	_, err := p.conn(ctx).Exec(ctx,
+		`WITH heartbeat AS (
+			INSERT INTO heartbeats (monitor_id, message, metadata, source_ip, received_at)
+			SELECT id, $2, $3::jsonb, $4, $5 FROM monitors WHERE id = $1 AND `+inTeam("team_id", 6)+`
+			RETURNING monitor_id
+		)
+		SELECT pg_notify($7, monitor_id::text) FROM heartbeat`,
+		monitorID, message, metadata, sourceIP, p.clock.Now(), teamArg(ctx), DeadlineChannel)
+	return err
+}
 
-type OverdueMonitor struct {
-	model.Monitor
-	TelegramChatID string
+// GetHeartbeats returns the newest heartbeats of a monitor, optionally limited
+// to the [from, to] time range.
+func (p *Postgres) GetHeartbeats(ctx context.Context, monitorID string, from, to *time.Time, limit int) ([]model.Heartbeat, error) {
+	rows, err := p.conn(ctx).Query(ctx,
+		`SELECT id, monitor_id, message, metadata, source_ip, received_at FROM heartbeats
+		WHERE monitor_id = $1
+		  AND ($2::timestamptz IS NULL OR received_at >= $2)
+		  AND ($3::timestamptz IS NULL OR received_at <= $3)
+		  AND `+monitorInTeam("monitor_id", 5)+`
+		ORDER BY received_at DESC LIMIT $4`,
+		monitorID, from, to, limit, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var heartbeats []model.Heartbeat
+	for rows.Next() {
+		var h model.Heartbeat
+		if err := rows.Scan(&h.ID, &h.MonitorID, &h.Message, &h.Metadata, &h.SourceIP, &h.ReceivedAt); err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, nil
 }
 
-func GetOverdueMonitors(ctx context.Context) ([]OverdueMonitor, error) {
+// PruneHeartbeats deletes heartbeats older than maxAge and keeps at most
+// maxPerMonitor rows per monitor. A zero limit is ignored.
+func (p *Postgres) PruneHeartbeats(ctx context.Context, maxAge time.Duration, maxPerMonitor int) (int64, error) {
+	var deleted int64
+
+	if maxAge > 0 {
+		tag, err := p.conn(ctx).Exec(ctx,
+			`DELETE FROM heartbeats WHERE received_at < $1`, p.clock.Now().Add(-maxAge))
+		if err != nil {
+			return deleted, err
+		}
+		deleted += tag.RowsAffected()
+	}
+
+	if maxPerMonitor > 0 {
+		tag, err := p.conn(ctx).Exec(ctx, `
+			DELETE FROM heartbeats WHERE id IN (
+				SELECT id FROM (
+					SELECT id, row_number() OVER (PARTITION BY monitor_id ORDER BY received_at DESC) AS rn
+					FROM heartbeats
+				) ranked WHERE ranked.rn > $1
+			)`, maxPerMonitor)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += tag.RowsAffected()
+	}
+
+	return deleted, nil
+}
+
+// --- Active monitors that are overdue ---
+
+func (p *Postgres) GetOverdueMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
 	query := `
-		SELECT m.id, m.monitor_name, m.check_type, m.message, m.metadata,
-			m.timeout, m.re_alert_interval, m.status, m.is_active, m.channel_id,
-			m.server_ip, m.server_name, m.last_seen_at, m.created_at, m.updated_at,
-			c.telegram_chat_id
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
 		FROM monitors m
 		JOIN notification_channels c ON m.channel_id = c.id
-		WHERE m.is_active = true
-		  AND m.last_seen_at + (m.timeout || ' seconds')::interval < now()`
+		WHERE m.is_active = true AND ` + inTeam("m.team_id", 2) + `
+		  AND m.last_seen_at + ((m.timeout * m.alert_after_misses) || ' seconds')::interval < $1`
+
+	return p.queryOverdueMonitors(ctx, query, p.clock.Now(), teamArg(ctx))
+}
+
+// LockOverdueMonitor uses FOR UPDATE SKIP LOCKED, so a monitor held by a
+// heartbeat or another watcher is skipped rather than waited for.
+func (p *Postgres) LockOverdueMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return p.lockMonitor(ctx, id, `m.last_seen_at + ((m.timeout * m.alert_after_misses) || ' seconds')::interval < $2`, p.clock.Now())
+}
 
-	rows, err := Pool.Query(ctx, query)
+// nextCheckAt is when the watcher next needs to look at a monitor: when it
+// becomes overdue, or when a firing alert is due for a re-alert.
+const nextCheckAt = `CASE
+		WHEN a.id IS NULL THEN m.last_seen_at + ((m.timeout * m.alert_after_misses) || ' seconds')::interval
+		ELSE GREATEST(
+			m.last_seen_at + ((m.timeout * m.alert_after_misses) || ' seconds')::interval,
+			a.last_alerted_at + (m.re_alert_interval || ' seconds')::interval)
+	END`
+
+// GetMonitorDeadlines returns the next check time of every watched monitor.
+func (p *Postgres) GetMonitorDeadlines(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := p.conn(ctx).Query(ctx, `
+		SELECT m.id, `+nextCheckAt+`
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		LEFT JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing'
+		WHERE m.is_active = true AND `+inTeam("m.team_id", 1), teamArg(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var result []OverdueMonitor
+	deadlines := map[string]time.Time{}
 	for rows.Next() {
-		var om OverdueMonitor
-		if err := rows.Scan(
-			&om.ID, &om.MonitorName, &om.CheckType, &om.Message, &om.Metadata,
-			&om.Timeout, &om.ReAlertInterval, &om.Status, &om.IsActive, &om.ChannelID,
-			&om.ServerIP, &om.ServerName, &om.LastSeenAt, &om.CreatedAt, &om.UpdatedAt,
-			&om.TelegramChatID,
-		); err != nil {
+		var id string
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
 			return nil, err
 		}
-		result = append(result, om)
+		deadlines[id] = at
 	}
-	return result, nil
+	return deadlines, rows.Err()
+}
+
+// GetMonitorDeadline returns the next check time of one monitor, or
+// store.ErrNotFound if it is not watched (inactive or without a channel).
+func (p *Postgres) GetMonitorDeadline(ctx context.Context, id string) (time.Time, error) {
+	var at time.Time
+	err := p.conn(ctx).QueryRow(ctx, `
+		SELECT `+nextCheckAt+`
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		LEFT JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing'
+		WHERE m.id = $1 AND m.is_active = true AND `+inTeam("m.team_id", 2), id, teamArg(ctx)).Scan(&at)
+	return at, notFound(err)
 }
 
 // --- Recovered monitors (were down, now back up) ---
 
-func GetRecoveredMonitors(ctx context.Context) ([]OverdueMonitor, error) {
+func (p *Postgres) GetRecoveredMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
 	query := `
-		SELECT m.id, m.monitor_name, m.check_type, m.message, m.metadata,
-			m.timeout, m.re_alert_interval, m.status, m.is_active, m.channel_id,
-			m.server_ip, m.server_name, m.last_seen_at, m.created_at, m.updated_at,
-			c.telegram_chat_id
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
 		FROM monitors m
 		JOIN notification_channels c ON m.channel_id = c.id
-		JOIN alert_states a ON a.monitor_id = m.id AND a.status = 'firing'
-		WHERE m.is_active = true
+		JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing'
+		WHERE m.is_active = true AND ` + inTeam("m.team_id", 1) + `
 		  AND m.status = 'up'`
 
-	rows, err := Pool.Query(ctx, query)
+	return p.queryOverdueMonitors(ctx, query, teamArg(ctx))
+}
+
+// LockRecoveredMonitor locks a monitor that is up with a firing alert, see LockOverdueMonitor.
+func (p *Postgres) LockRecoveredMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return p.lockMonitor(ctx, id, `m.status = 'up' AND EXISTS (
+		SELECT 1 FROM alert_states a WHERE a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing')`)
+}
+
+// RecoveryChannel is notified with the monitor ID whenever a monitor goes from down to up.
+const RecoveryChannel = "monitor_recovered"
+
+// ListenRecoveries calls fn with the ID of every monitor that comes back up,
+// until ctx is cancelled or the connection fails.
+func (p *Postgres) ListenRecoveries(ctx context.Context, fn func(monitorID string)) error {
+	return p.listen(ctx, RecoveryChannel, nil, fn)
+}
+
+// DeadlineChannel is notified with the monitor ID by CreateHeartbeat and
+// UpdateMonitor, so the leader reschedules monitors touched on other instances.
+const DeadlineChannel = "monitor_deadline_changed"
+
+// ListenDeadlineChanges calls fn with the ID of every monitor that received a
+// heartbeat or was updated, on any instance.
+func (p *Postgres) ListenDeadlineChanges(ctx context.Context, fn func(monitorID string)) error {
+	return p.listen(ctx, DeadlineChannel, nil, fn)
+}
+
+// listen calls fn with the payload of every NOTIFY on channel, and ready, if
+// not nil, once LISTEN succeeded.
+func (p *Postgres) listen(ctx context.Context, channel string, ready func(), fn func(payload string)) error {
+	store.CheckScope(ctx)
+	c, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	if _, err := c.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+	if ready != nil {
+		ready()
+	}
+
+	for {
+		n, err := c.Conn().WaitForNotification(ctx)
+		if err != nil {
+			// Don't hand a connection that is still listening back to the pool
+			c.Conn().Close(context.Background())
+			return err
+		}
+		fn(n.Payload)
+	}
+}
+
+// --- Flapping monitors ---
+
+func (p *Postgres) GetFlappingMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		WHERE m.is_active = true AND ` + inTeam("m.team_id", 1) + `
+		  AND m.is_flapping = true`
+
+	return p.queryOverdueMonitors(ctx, query, teamArg(ctx))
+}
+
+// LockFlappingMonitor locks a monitor that is still flapping, see LockOverdueMonitor.
+func (p *Postgres) LockFlappingMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return p.lockMonitor(ctx, id, `m.is_flapping = true`)
+}
+
+func (p *Postgres) SetMonitorFlapping(ctx context.Context, id string, flapping bool) error {
+	_, err := p.conn(ctx).Exec(ctx,
+		`UPDATE monitors SET is_flapping = $1, flapping_since = CASE WHEN $1 THEN $3::timestamptz END, updated_at = $3
+		WHERE id = $2 AND `+inTeam("team_id", 4),
+		flapping, id, p.clock.Now(), teamArg(ctx))
+	return err
+}
+
+// CountStateTransitions returns how often a monitor went down or came back up
+// within the given window, based on its heartbeat alert history.
+func (p *Postgres) CountStateTransitions(ctx context.Context, monitorID string, window time.Duration) (int, error) {
+	var count int
+	err := p.conn(ctx).QueryRow(ctx, `
+		SELECT count(*) FILTER (WHERE fired_at > $2)
+			+ count(*) FILTER (WHERE resolved_at > $2)
+		FROM alert_states
+		WHERE monitor_id = $1 AND type = 'heartbeat' AND `+monitorInTeam("monitor_id", 3),
+		monitorID, p.clock.Now().Add(-window), teamArg(ctx)).Scan(&count)
+	return count, err
+}
+
+// lockMonitor locks a watched monitor matching condition, whose parameters
+// start at $2.
+func (p *Postgres) lockMonitor(ctx context.Context, id, condition string, args ...any) (*store.OverdueMonitor, error) {
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		WHERE m.id = $1 AND m.is_active = true AND ` + inTeam("m.team_id", len(args)+2) + ` AND ` + condition + `
+		FOR UPDATE OF m SKIP LOCKED`
+
+	args = append(append([]any{id}, args...), teamArg(ctx))
+	var om store.OverdueMonitor
+	if err := p.conn(ctx).QueryRow(ctx, query, args...).Scan(append(monitorFields(&om.Monitor), &om.TelegramChatID)...); err != nil {
+		return nil, notFound(err)
+	}
+	return &om, nil
+}
+
+func (p *Postgres) queryOverdueMonitors(ctx context.Context, query string, args ...any) ([]store.OverdueMonitor, error) {
+	rows, err := p.conn(ctx).Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var result []OverdueMonitor
+	var result []store.OverdueMonitor
 	for rows.Next() {
-		var om OverdueMonitor
-		if err := rows.Scan(
-			&om.ID, &om.MonitorName, &om.CheckType, &om.Message, &om.Metadata,
-			&om.Timeout, &om.ReAlertInterval, &om.Status, &om.IsActive, &om.ChannelID,
-			&om.ServerIP, &om.ServerName, &om.LastSeenAt, &om.CreatedAt, &om.UpdatedAt,
-			&om.TelegramChatID,
-		); err != nil {
+		var om store.OverdueMonitor
+		if err := rows.Scan(append(monitorFields(&om.Monitor), &om.TelegramChatID)...); err != nil {
 			return nil, err
 		}
 		result = append(result, om)
@@ -224,48 +591,298 @@ func GetRecoveredMonitors(ctx context.Context) ([]OverdueMonitor, error) {
 
 // --- Alert States ---
 
-func GetFiringAlert(ctx context.Context, monitorID string) (*model.AlertState, error) {
-	query := `SELECT id, monitor_id, status, last_alerted_at, fired_at, resolved_at FROM alert_states WHERE monitor_id = $1 AND status = 'firing'`
+const alertColumns = `id, monitor_id, type, rule_id, status, suppressed, last_alerted_at, fired_at, resolved_at, acknowledged_at, acknowledged_by`
+
+// alertFields returns scan destinations matching alertColumns.
+func alertFields(a *model.AlertState) []any {
+	return []any{
+		&a.ID, &a.MonitorID, &a.Type, &a.RuleID, &a.Status, &a.Suppressed,
+		&a.LastAlertedAt, &a.FiredAt, &a.ResolvedAt, &a.AcknowledgedAt, &a.AcknowledgedBy,
+	}
+}
+
+func (p *Postgres) GetFiringAlert(ctx context.Context, monitorID string) (*model.AlertState, error) {
+	query := `SELECT ` + alertColumns + ` FROM alert_states WHERE monitor_id = $1 AND type = 'heartbeat' AND status = 'firing' AND ` + monitorInTeam("monitor_id", 2)
 
 	var a model.AlertState
-	err := Pool.QueryRow(ctx, query, monitorID).Scan(&a.ID, &a.MonitorID, &a.Status, &a.LastAlertedAt, &a.FiredAt, &a.ResolvedAt)
+	err := p.conn(ctx).QueryRow(ctx, query, monitorID, teamArg(ctx)).Scan(alertFields(&a)...)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return &a, nil
 }
 
-func CreateAlertState(ctx context.Context, monitorID string) error {
-	_, err := Pool.Exec(ctx,
-		`INSERT INTO alert_states (monitor_id, status, last_alerted_at, fired_at) VALUES ($1, 'firing', now(), now())`,
-		monitorID)
+func (p *Postgres) GetFiringThresholdAlert(ctx context.Context, ruleID string) (*model.AlertState, error) {
+	query := `SELECT ` + alertColumns + ` FROM alert_states WHERE rule_id = $1 AND type = 'threshold' AND status = 'firing' AND ` + monitorInTeam("monitor_id", 2)
+
+	var a model.AlertState
+	err := p.conn(ctx).QueryRow(ctx, query, ruleID, teamArg(ctx)).Scan(alertFields(&a)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &a, nil
+}
+
+func (p *Postgres) GetFiringAlerts(ctx context.Context, monitorID string) ([]model.AlertState, error) {
+	query := `SELECT ` + alertColumns + ` FROM alert_states
+		WHERE monitor_id = $1 AND status = 'firing' AND ` + monitorInTeam("monitor_id", 2) + `
+		ORDER BY fired_at`
+
+	rows, err := p.conn(ctx).Query(ctx, query, monitorID, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []model.AlertState
+	for rows.Next() {
+		var a model.AlertState
+		if err := rows.Scan(alertFields(&a)...); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+func (p *Postgres) AcknowledgeAlerts(ctx context.Context, monitorID, by string) (int64, error) {
+	tag, err := p.conn(ctx).Exec(ctx, `
+		UPDATE alert_states SET acknowledged_at = $2, acknowledged_by = $3
+		WHERE monitor_id = $1 AND status = 'firing' AND acknowledged_at IS NULL AND `+monitorInTeam("monitor_id", 4),
+		monitorID, p.clock.Now(), by, teamArg(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CreateAlertState opens a heartbeat alert. Suppressed alerts are tracked
+// without notifying, because a parent monitor is already down. It reports
+// false if the monitor already has a firing alert.
+func (p *Postgres) CreateAlertState(ctx context.Context, monitorID string, suppressed bool) (bool, error) {
+	tag, err := p.conn(ctx).Exec(ctx,
+		`INSERT INTO alert_states (monitor_id, status, suppressed, last_alerted_at, fired_at)
+		SELECT id, 'firing', $2, $3, $3 FROM monitors WHERE id = $1 AND `+inTeam("team_id", 4)+`
+		ON CONFLICT (monitor_id) WHERE status = 'firing' AND type = 'heartbeat' DO NOTHING`,
+		monitorID, suppressed, p.clock.Now(), teamArg(ctx))
+	return tag.RowsAffected() == 1, err
+}
+
+func (p *Postgres) UnsuppressAlert(ctx context.Context, alertID string) error {
+	_, err := p.conn(ctx).Exec(ctx,
+		`UPDATE alert_states SET suppressed = false, last_alerted_at = $2 WHERE id = $1 AND `+monitorInTeam("monitor_id", 3),
+		alertID, p.clock.Now(), teamArg(ctx))
 	return err
 }
 
-func UpdateAlertLastAlerted(ctx context.Context, alertID string) error {
-	_, err := Pool.Exec(ctx,
-		`UPDATE alert_states SET last_alerted_at = now() WHERE id = $1`, alertID)
+// CreateThresholdAlertState reports false if the rule already has a firing alert.
+func (p *Postgres) CreateThresholdAlertState(ctx context.Context, monitorID, ruleID string) (bool, error) {
+	tag, err := p.conn(ctx).Exec(ctx,
+		`INSERT INTO alert_states (monitor_id, type, rule_id, status, last_alerted_at, fired_at)
+		SELECT id, 'threshold', $2, 'firing', $3, $3 FROM monitors WHERE id = $1 AND `+inTeam("team_id", 4)+`
+		ON CONFLICT (rule_id) WHERE status = 'firing' AND type = 'threshold' DO NOTHING`,
+		monitorID, ruleID, p.clock.Now(), teamArg(ctx))
+	return tag.RowsAffected() == 1, err
+}
+
+func (p *Postgres) UpdateAlertLastAlerted(ctx context.Context, alertID string) error {
+	_, err := p.conn(ctx).Exec(ctx,
+		`UPDATE alert_states SET last_alerted_at = $2 WHERE id = $1 AND `+monitorInTeam("monitor_id", 3),
+		alertID, p.clock.Now(), teamArg(ctx))
+	return err
+}
+
+func (p *Postgres) ResolveAlert(ctx context.Context, alertID string) error {
+	_, err := p.conn(ctx).Exec(ctx,
+		`UPDATE alert_states SET status = 'resolved', resolved_at = $2 WHERE id = $1 AND `+monitorInTeam("monitor_id", 3),
+		alertID, p.clock.Now(), teamArg(ctx))
+	return err
+}
+
+// --- Monitor Dependencies ---
+
+func (p *Postgres) GetMonitorParents(ctx context.Context, monitorID string) ([]model.Monitor, error) {
+	query := `SELECT ` + monitorColumnsAs("m") + `
+		FROM monitor_dependencies d
+		JOIN monitors m ON m.id = d.parent_id
+		WHERE d.monitor_id = $1 AND ` + inTeam("m.team_id", 2) + `
+		ORDER BY d.created_at`
+
+	return p.queryMonitors(ctx, query, monitorID, teamArg(ctx))
+}
+
+// AddMonitorParent declares that monitorID depends on parentID, refusing
+// dependencies that would make a monitor its own ancestor or cross teams.
+func (p *Postgres) AddMonitorParent(ctx context.Context, monitorID, parentID string) error {
+	var found, cycle bool
+	err := p.conn(ctx).QueryRow(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT $2::uuid AS id
+			UNION
+			SELECT d.parent_id FROM monitor_dependencies d JOIN ancestors a ON d.monitor_id = a.id
+		)
+		SELECT EXISTS (
+				SELECT 1 FROM monitors c JOIN monitors p ON p.team_id = c.team_id
+				WHERE c.id = $1::uuid AND p.id = $2::uuid AND `+inTeam("c.team_id", 3)+`
+			),
+			EXISTS (SELECT 1 FROM ancestors WHERE id = $1::uuid)`,
+		monitorID, parentID, teamArg(ctx)).Scan(&found, &cycle)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return store.ErrNotFound
+	}
+	if cycle {
+		return store.ErrDependencyCycle
+	}
+
+	_, err = p.conn(ctx).Exec(ctx,
+		`INSERT INTO monitor_dependencies (monitor_id, parent_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		monitorID, parentID)
+	return err
+}
+
+func (p *Postgres) RemoveMonitorParent(ctx context.Context, monitorID, parentID string) error {
+	_, err := p.conn(ctx).Exec(ctx, `DELETE FROM monitor_dependencies WHERE monitor_id = $1 AND parent_id = $2 AND `+monitorInTeam("monitor_id", 3),
+		monitorID, parentID, teamArg(ctx))
+	return err
+}
+
+// HasDownParent reports whether any active parent of the monitor is down or
+// overdue, even if the watcher has not processed it yet.
+func (p *Postgres) HasDownParent(ctx context.Context, monitorID string) (bool, error) {
+	var down bool
+	err := p.conn(ctx).QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM monitor_dependencies d
+			JOIN monitors p ON p.id = d.parent_id
+			WHERE d.monitor_id = $1 AND p.is_active = true AND `+inTeam("p.team_id", 3)+`
+			  AND (p.status = 'down' OR p.last_seen_at + ((p.timeout * p.alert_after_misses) || ' seconds')::interval < $2)
+		)`, monitorID, p.clock.Now(), teamArg(ctx)).Scan(&down)
+	return down, err
+}
+
+// GetDownChildren returns the active children of a monitor that are down or overdue.
+func (p *Postgres) GetDownChildren(ctx context.Context, parentID string) ([]model.Monitor, error) {
+	query := `SELECT ` + monitorColumnsAs("m") + `
+		FROM monitor_dependencies d
+		JOIN monitors m ON m.id = d.monitor_id
+		WHERE d.parent_id = $1 AND m.is_active = true AND ` + inTeam("m.team_id", 3) + `
+		  AND (m.status = 'down' OR m.last_seen_at + ((m.timeout * m.alert_after_misses) || ' seconds')::interval < $2)
+		ORDER BY m.monitor_name, m.check_type`
+
+	return p.queryMonitors(ctx, query, parentID, p.clock.Now(), teamArg(ctx))
+}
+
+// GetSuppressedChildren returns children of a monitor whose alert is currently
+// suppressed, so they can be re-evaluated once the parent recovers. Inside a
+// transaction the children are locked, skipping any held elsewhere.
+func (p *Postgres) GetSuppressedChildren(ctx context.Context, parentID string) ([]store.OverdueMonitor, error) {
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitor_dependencies d
+		JOIN monitors m ON m.id = d.monitor_id
+		JOIN notification_channels c ON m.channel_id = c.id
+		JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing' AND a.suppressed = true
+		WHERE d.parent_id = $1 AND m.is_active = true AND ` + inTeam("m.team_id", 2) + `
+		FOR UPDATE OF m SKIP LOCKED`
+
+	return p.queryOverdueMonitors(ctx, query, parentID, teamArg(ctx))
+}
+
+// --- Threshold Rules ---
+
+func (p *Postgres) CreateThresholdRule(ctx context.Context, r *model.ThresholdRule) (*model.ThresholdRule, error) {
+	query := `INSERT INTO threshold_rules (monitor_id, metric, operator, threshold, consecutive)
+		SELECT id, $2, $3, $4, $5 FROM monitors WHERE id = $1 AND ` + inTeam("team_id", 6) + `
+		RETURNING id, monitor_id, metric, operator, threshold, consecutive, breach_count, created_at`
+
+	var rule model.ThresholdRule
+	err := p.conn(ctx).QueryRow(ctx, query, r.MonitorID, r.Metric, r.Operator, r.Threshold, r.Consecutive, teamArg(ctx)).Scan(
+		&rule.ID, &rule.MonitorID, &rule.Metric, &rule.Operator, &rule.Threshold, &rule.Consecutive, &rule.BreachCount, &rule.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &rule, nil
+}
+
+func (p *Postgres) GetThresholdRules(ctx context.Context, monitorID string) ([]model.ThresholdRule, error) {
+	rows, err := p.conn(ctx).Query(ctx,
+		`SELECT id, monitor_id, metric, operator, threshold, consecutive, breach_count, created_at FROM threshold_rules
+		WHERE monitor_id = $1 AND `+monitorInTeam("monitor_id", 2)+` ORDER BY created_at`,
+		monitorID, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []model.ThresholdRule
+	for rows.Next() {
+		var r model.ThresholdRule
+		if err := rows.Scan(&r.ID, &r.MonitorID, &r.Metric, &r.Operator, &r.Threshold, &r.Consecutive, &r.BreachCount, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// LockThresholdRule locks a rule for the rest of the transaction, so concurrent
+// heartbeats of the same monitor update its breach count one after another.
+func (p *Postgres) LockThresholdRule(ctx context.Context, id string) (*model.ThresholdRule, error) {
+	var r model.ThresholdRule
+	err := p.conn(ctx).QueryRow(ctx,
+		`SELECT id, monitor_id, metric, operator, threshold, consecutive, breach_count, created_at FROM threshold_rules
+		WHERE id = $1 AND `+monitorInTeam("monitor_id", 2)+` FOR UPDATE`, id, teamArg(ctx)).
+		Scan(&r.ID, &r.MonitorID, &r.Metric, &r.Operator, &r.Threshold, &r.Consecutive, &r.BreachCount, &r.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+func (p *Postgres) SetRuleBreachCount(ctx context.Context, id string, count int) error {
+	_, err := p.conn(ctx).Exec(ctx, `UPDATE threshold_rules SET breach_count = $1 WHERE id = $2 AND `+monitorInTeam("monitor_id", 3),
+		count, id, teamArg(ctx))
 	return err
 }
 
-func ResolveAlert(ctx context.Context, alertID string) error {
-	_, err := Pool.Exec(ctx,
-		`UPDATE alert_states SET status = 'resolved', resolved_at = now() WHERE id = $1`, alertID)
+func (p *Postgres) DeleteThresholdRule(ctx context.Context, monitorID, id string) error {
+	_, err := p.conn(ctx).Exec(ctx, `DELETE FROM threshold_rules WHERE id = $1 AND monitor_id = $2 AND `+monitorInTeam("monitor_id", 3),
+		id, monitorID, teamArg(ctx))
 	return err
 }
 
 // --- Notification Channels ---
 
-func CreateChannel(ctx context.Context, name, telegramChatID string) (*model.NotificationChannel, error) {
-	query := `INSERT INTO notification_channels (name, telegram_chat_id) VALUES ($1, $2) RETURNING id, name, telegram_chat_id, created_at`
+const channelColumns = `id, team_id, name, telegram_chat_id, created_at`
+
+// channelFields returns scan destinations matching channelColumns.
+func channelFields(ch *model.NotificationChannel) []any {
+	return []any{&ch.ID, &ch.TeamID, &ch.Name, &ch.TelegramChatID, &ch.CreatedAt}
+}
+
+func (p *Postgres) CreateChannel(ctx context.Context, name, telegramChatID string) (*model.NotificationChannel, error) {
+	query := `INSERT INTO notification_channels (team_id, name, telegram_chat_id) VALUES ($1, $2, $3) RETURNING ` + channelColumns
 
 	var ch model.NotificationChannel
-	err := Pool.QueryRow(ctx, query, name, telegramChatID).Scan(&ch.ID, &ch.Name, &ch.TelegramChatID, &ch.CreatedAt)
+	err := p.conn(ctx).QueryRow(ctx, query, store.OwnerTeam(ctx), name, telegramChatID).Scan(channelFields(&ch)...)
 	return &ch, err
 }
 
-func GetAllChannels(ctx context.Context) ([]model.NotificationChannel, error) {
-	rows, err := Pool.Query(ctx, `SELECT id, name, telegram_chat_id, created_at FROM notification_channels ORDER BY created_at DESC`)
+func (p *Postgres) GetChannelByID(ctx context.Context, id string) (*model.NotificationChannel, error) {
+	var ch model.NotificationChannel
+	err := p.conn(ctx).QueryRow(ctx, `SELECT `+channelColumns+` FROM notification_channels WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx)).
+		Scan(channelFields(&ch)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &ch, nil
+}
+
+func (p *Postgres) GetAllChannels(ctx context.Context) ([]model.NotificationChannel, error) {
+	rows, err := p.conn(ctx).Query(ctx, `SELECT `+channelColumns+` FROM notification_channels WHERE `+inTeam("team_id", 1)+` ORDER BY created_at DESC`, teamArg(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -274,7 +891,7 @@ func GetAllChannels(ctx context.Context) ([]model.NotificationChannel, error) {
 	var channels []model.NotificationChannel
 	for rows.Next() {
 		var ch model.NotificationChannel
-		if err := rows.Scan(&ch.ID, &ch.Name, &ch.TelegramChatID, &ch.CreatedAt); err != nil {
+		if err := rows.Scan(channelFields(&ch)...); err != nil {
 			return nil, err
 		}
 		channels = append(channels, ch)
@@ -282,23 +899,24 @@ func GetAllChannels(ctx context.Context) ([]model.NotificationChannel, error) {
 	return channels, nil
 }
 
-func DeleteChannel(ctx context.Context, id string) error {
-	_, err := Pool.Exec(ctx, `DELETE FROM notification_channels WHERE id = $1`, id)
+func (p *Postgres) DeleteChannel(ctx context.Context, id string) error {
+	_, err := p.conn(ctx).Exec(ctx, `DELETE FROM notification_channels WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
 	return err
 }
 
 // --- Notification Logs ---
 
-func CreateNotificationLog(ctx context.Context, monitorID string, channelID *string, alertType, message string, success bool, errMsg string) error {
-	_, err := Pool.Exec(ctx,
+func (p *Postgres) CreateNotificationLog(ctx context.Context, monitorID string, channelID *string, alertType, message string, success bool, errMsg string) error {
+	_, err := p.conn(ctx).Exec(ctx,
 		`INSERT INTO notification_logs (monitor_id, channel_id, alert_type, message, success, error) VALUES ($1, $2, $3, $4, $5, $6)`,
 		monitorID, channelID, alertType, message, success, errMsg)
 	return err
 }
 
-func GetNotificationLogs(ctx context.Context) ([]model.NotificationLog, error) {
-	rows, err := Pool.Query(ctx,
-		`SELECT id, monitor_id, channel_id, alert_type, message, success, error, created_at FROM notification_logs ORDER BY created_at DESC LIMIT 100`)
+func (p *Postgres) GetNotificationLogs(ctx context.Context) ([]model.NotificationLog, error) {
+	rows, err := p.conn(ctx).Query(ctx,
+		`SELECT id, monitor_id, channel_id, alert_type, message, success, error, created_at FROM notification_logs
+		WHERE `+monitorInTeam("monitor_id", 1)+` ORDER BY created_at DESC LIMIT 100`, teamArg(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -317,16 +935,28 @@ func GetNotificationLogs(ctx context.Context) ([]model.NotificationLog, error) {
 
 // --- API Keys ---
 
-func CreateApiKey(ctx context.Context, name, keyHash, keyPrefix string) (*model.ApiKey, error) {
-	query := `INSERT INTO api_keys (name, key_hash, key_prefix) VALUES ($1, $2, $3) RETURNING id, name, key_prefix, is_active, created_at`
+const apiKeyColumns = `id, name, key_prefix, scopes, monitor_pattern, check_type_pattern, is_active, expires_at, last_used_at, last_used_ip, created_at, team_id`
 
-	var k model.ApiKey
-	err := Pool.QueryRow(ctx, query, name, keyHash, keyPrefix).Scan(&k.ID, &k.Name, &k.KeyPrefix, &k.IsActive, &k.CreatedAt)
-	return &k, err
+// apiKeyFields returns scan destinations matching apiKeyColumns.
+func apiKeyFields(k *model.ApiKey) []any {
+	return []any{
+		&k.ID, &k.Name, &k.KeyPrefix, &k.Scopes, &k.MonitorPattern, &k.CheckTypePattern,
+		&k.IsActive, &k.ExpiresAt, &k.LastUsedAt, &k.LastUsedIP, &k.CreatedAt, &k.TeamID,
+	}
 }
 
-func GetAllApiKeys(ctx context.Context) ([]model.ApiKey, error) {
-	rows, err := Pool.Query(ctx, `SELECT id, name, key_prefix, is_active, created_at FROM api_keys ORDER BY created_at DESC`)
+func (p *Postgres) CreateApiKey(ctx context.Context, k *model.ApiKey, keyHash string) (*model.ApiKey, error) {
+	query := `INSERT INTO api_keys (name, key_hash, key_prefix, scopes, monitor_pattern, check_type_pattern, expires_at, team_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ` + apiKeyColumns
+
+	var key model.ApiKey
+	err := p.conn(ctx).QueryRow(ctx, query, k.Name, keyHash, k.KeyPrefix, k.Scopes, k.MonitorPattern, k.CheckTypePattern, k.ExpiresAt, store.OwnerTeam(ctx)).
+		Scan(apiKeyFields(&key)...)
+	return &key, err
+}
+
+func (p *Postgres) GetAllApiKeys(ctx context.Context) ([]model.ApiKey, error) {
+	rows, err := p.conn(ctx).Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE `+inTeam("team_id", 1)+` ORDER BY created_at DESC`, teamArg(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -335,7 +965,7 @@ func GetAllApiKeys(ctx context.Context) ([]model.ApiKey, error) {
 	var keys []model.ApiKey
 	for rows.Next() {
 		var k model.ApiKey
-		if err := rows.Scan(&k.ID, &k.Name, &k.KeyPrefix, &k.IsActive, &k.CreatedAt); err != nil {
+		if err := rows.Scan(apiKeyFields(&k)...); err != nil {
 			return nil, err
 		}
 		keys = append(keys, k)
@@ -343,25 +973,289 @@ func GetAllApiKeys(ctx context.Context) ([]model.ApiKey, error) {
 	return keys, nil
 }
 
-func DeleteApiKey(ctx context.Context, id string) error {
-	_, err := Pool.Exec(ctx, `DELETE FROM api_keys WHERE id = $1`, id)
+func (p *Postgres) GetApiKeyByID(ctx context.Context, id string) (*model.ApiKey, error) {
+	var k model.ApiKey
+	err := p.conn(ctx).QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx)).Scan(apiKeyFields(&k)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &k, nil
+}
+
+func (p *Postgres) UpdateApiKey(ctx context.Context, k *model.ApiKey) (*model.ApiKey, error) {
+	query := `UPDATE api_keys SET name = $2, is_active = $3, scopes = $4, monitor_pattern = $5, check_type_pattern = $6, expires_at = $7
+		WHERE id = $1 AND ` + inTeam("team_id", 8) + ` RETURNING ` + apiKeyColumns
+
+	var key model.ApiKey
+	err := p.conn(ctx).QueryRow(ctx, query, k.ID, k.Name, k.IsActive, k.Scopes, k.MonitorPattern, k.CheckTypePattern, k.ExpiresAt, teamArg(ctx)).
+		Scan(apiKeyFields(&key)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &key, nil
+}
+
+func (p *Postgres) DeleteApiKey(ctx context.Context, id string) error {
+	_, err := p.conn(ctx).Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
+	return err
+}
+
+func (p *Postgres) RevokeApiKey(ctx context.Context, id string) error {
+	tag, err := p.conn(ctx).Exec(ctx, `UPDATE api_keys SET is_active = false WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
+	if err == nil && tag.RowsAffected() == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (p *Postgres) ExpireApiKey(ctx context.Context, id string, at time.Time) error {
+	tag, err := p.conn(ctx).Exec(ctx, `UPDATE api_keys SET expires_at = LEAST(expires_at, $2) WHERE id = $1 AND `+inTeam("team_id", 3), id, at, teamArg(ctx))
+	if err == nil && tag.RowsAffected() == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (p *Postgres) GetActiveApiKey(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE key_hash = $1 AND is_active = true AND (expires_at IS NULL OR expires_at > $2)`
+
+	var k model.ApiKey
+	err := p.conn(ctx).QueryRow(ctx, query, keyHash, p.clock.Now()).Scan(apiKeyFields(&k)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &k, nil
+}
+
+// ApiKeyChannel is notified with the key ID whenever a key is updated, revoked,
+// expired or deleted, by the api_keys_changed_notify trigger.
+const ApiKeyChannel = "api_key_changed"
+
+func (p *Postgres) ListenApiKeyChanges(ctx context.Context, ready func(), fn func(keyID string)) error {
+	return p.listen(ctx, ApiKeyChannel, ready, fn)
+}
+
+func (p *Postgres) RecordApiKeyUses(ctx context.Context, uses []store.ApiKeyUse) error {
+	ids := make([]string, len(uses))
+	ats := make([]time.Time, len(uses))
+	ips := make([]string, len(uses))
+	for i, u := range uses {
+		ids[i], ats[i], ips[i] = u.KeyID, u.At, u.IP
+	}
+
+	_, err := p.conn(ctx).Exec(ctx, `
+		UPDATE api_keys k SET last_used_at = u.at, last_used_ip = u.ip
+		FROM unnest($1::uuid[], $2::timestamptz[], $3::text[]) AS u(id, at, ip)
+		WHERE k.id = u.id AND (k.last_used_at IS NULL OR k.last_used_at < u.at)`,
+		ids, ats, ips)
+	return err
+}
+
+// --- Users ---
+
+const userColumns = `id, team_id, username, role, is_active, oidc_subject IS NOT NULL, created_at`
+
+// userFields returns scan destinations matching userColumns.
+func userFields(u *model.User) []any {
+	return []any{&u.ID, &u.TeamID, &u.Username, &u.Role, &u.IsActive, &u.SSO, &u.CreatedAt}
+}
+
+func (p *Postgres) CreateUser(ctx context.Context, u *model.User, passwordHash string) (*model.User, error) {
+	query := `INSERT INTO users (team_id, username, password_hash, role, created_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING ` + userColumns
+
+	var user model.User
+	err := p.conn(ctx).QueryRow(ctx, query, store.OwnerTeam(ctx), u.Username, passwordHash, u.Role, p.clock.Now()).Scan(userFields(&user)...)
+	if isUniqueViolation(err) {
+		return nil, store.ErrUserExists
+	}
+	return &user, err
+}
+
+func (p *Postgres) GetAllUsers(ctx context.Context) ([]model.User, error) {
+	rows, err := p.conn(ctx).Query(ctx, `SELECT `+userColumns+` FROM users WHERE `+inTeam("team_id", 1)+` ORDER BY username`, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []model.User
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(userFields(&u)...); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (p *Postgres) GetUserByID(ctx context.Context, id string) (*model.User, error) {
+	var u model.User
+	err := p.conn(ctx).QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx)).Scan(userFields(&u)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (p *Postgres) UpsertSSOUser(ctx context.Context, u *model.User, subject string) (*model.User, error) {
+	query := `INSERT INTO users (team_id, username, password_hash, role, oidc_subject, created_at)
+		VALUES ($1, $2, '', $3, $4, $5)
+		ON CONFLICT (oidc_subject) DO UPDATE SET username = EXCLUDED.username, role = EXCLUDED.role
+		RETURNING ` + userColumns
+
+	var user model.User
+	err := p.conn(ctx).QueryRow(ctx, query, store.OwnerTeam(ctx), u.Username, u.Role, subject, p.clock.Now()).Scan(userFields(&user)...)
+	if isUniqueViolation(err) {
+		return nil, store.ErrUserExists
+	}
+	return &user, err
+}
+
+func (p *Postgres) GetUserLogin(ctx context.Context, username string) (*model.User, string, error) {
+	var u model.User
+	var hash string
+	err := p.conn(ctx).QueryRow(ctx, `SELECT `+userColumns+`, password_hash FROM users WHERE username = $1 AND is_active = true`, username).
+		Scan(append(userFields(&u), &hash)...)
+	if err != nil {
+		return nil, "", notFound(err)
+	}
+	return &u, hash, nil
+}
+
+func (p *Postgres) UpdateUser(ctx context.Context, id, role string, isActive bool) (*model.User, error) {
+	var u model.User
+	err := p.InTx(ctx, func(ctx context.Context) error {
+		query := `UPDATE users SET role = $2, is_active = $3 WHERE id = $1 AND ` + inTeam("team_id", 4) + ` RETURNING ` + userColumns
+		if err := p.conn(ctx).QueryRow(ctx, query, id, role, isActive, teamArg(ctx)).Scan(userFields(&u)...); err != nil {
+			return notFound(err)
+		}
+		if isActive {
+			return nil
+		}
+		_, err := p.conn(ctx).Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (p *Postgres) SetUserPassword(ctx context.Context, id, passwordHash string) error {
+	return p.InTx(ctx, func(ctx context.Context) error {
+		tag, err := p.conn(ctx).Exec(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1 AND `+inTeam("team_id", 3), id, passwordHash, teamArg(ctx))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return store.ErrNotFound
+		}
+		_, err = p.conn(ctx).Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, id)
+		return err
+	})
+}
+
+func (p *Postgres) DeleteUser(ctx context.Context, id string) error {
+	_, err := p.conn(ctx).Exec(ctx, `DELETE FROM users WHERE id = $1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
 	return err
 }
 
-func ValidateApiKey(ctx context.Context, keyHash string) (bool, error) {
-	var exists bool
-	err := Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM api_keys WHERE key_hash = $1 AND is_active = true)`, keyHash).Scan(&exists)
-	return exists, err
+func (p *Postgres) CreateSession(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	_, err := p.conn(ctx).Exec(ctx, `INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)`,
+		tokenHash, userID, p.clock.Now(), expiresAt)
+	return err
 }
 
-// --- Helpers ---
+func (p *Postgres) GetSessionUser(ctx context.Context, tokenHash string) (*model.User, error) {
+	query := `SELECT ` + prefixColumns("u", userColumns) + ` FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.expires_at > $2 AND u.is_active = true`
 
-func FormatDuration(d time.Duration) string {
-	if d < time.Minute {
-		return fmt.Sprintf("%ds", int(d.Seconds()))
+	var u model.User
+	err := p.conn(ctx).QueryRow(ctx, query, tokenHash, p.clock.Now()).Scan(userFields(&u)...)
+	if err != nil {
+		return nil, notFound(err)
 	}
-	if d < time.Hour {
-		return fmt.Sprintf("%dm %ds", int(d.Minutes()), int(d.Seconds())%60)
+	return &u, nil
+}
+
+func (p *Postgres) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := p.conn(ctx).Exec(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+func (p *Postgres) PruneSessions(ctx context.Context) (int64, error) {
+	tag, err := p.conn(ctx).Exec(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, p.clock.Now())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// --- Audit Events ---
+
+const auditEventColumns = `id, team_id, actor, action, target_type, target_id, before_state, after_state, created_at`
+
+func (p *Postgres) CreateAuditEvent(ctx context.Context, e *model.AuditEvent) error {
+	_, err := p.conn(ctx).Exec(ctx, `INSERT INTO audit_events (team_id, actor, action, target_type, target_id, before_state, after_state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		e.TeamID, e.Actor, e.Action, e.TargetType, e.TargetID, nullJSON(e.Before), nullJSON(e.After), p.clock.Now())
+	return err
+}
+
+func (p *Postgres) GetAuditEvents(ctx context.Context, f store.AuditFilter) ([]model.AuditEvent, error) {
+	conds := []string{inTeam("team_id", 1)}
+	args := []any{teamArg(ctx)}
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Actor != "" {
+		where("actor = $%d", f.Actor)
+	}
+	if f.Action != "" {
+		where("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		where("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		where("target_id = $%d", f.TargetID)
+	}
+	if f.From != nil {
+		where("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		where("created_at <= $%d", *f.To)
+	}
+	args = append(args, f.Limit)
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events WHERE ` + strings.Join(conds, " AND ") +
+		fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d`, len(args))
+	rows, err := p.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.AuditEvent
+	for rows.Next() {
+		var e model.AuditEvent
+		if err := rows.Scan(&e.ID, &e.TeamID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID, (*[]byte)(&e.Before), (*[]byte)(&e.After), &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// nullJSON passes an empty JSON document as NULL.
+func nullJSON(b []byte) any {
+	if len(b) == 0 {
+		return nil
 	}
-	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	return string(b)
 }
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/memory"
+	"github.com/mohsen/alertinGo/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	storetest.Run(t, func(t *testing.T, clk clock.Clock) store.Store {
+		p, err := Connect(context.Background(), withSchema(t, dsn), clk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(p.Close)
+		if err := p.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	})
+}
+
+func TestOpenScheme(t *testing.T) {
+	ctx := context.Background()
+	for dsn, want := range map[string]string{
+		// Nothing listens on port 1, reaching the ping shows Postgres was picked
+		"host=127.0.0.1 port=1 dbname=alerting connect_timeout=1": "unable to ping database",
+		"postgres://127.0.0.1:1/alerting?connect_timeout=1":       "unable to ping database",
+		"mysql://localhost/alerting":                              "unsupported DATABASE_URL scheme",
+	} {
+		if _, err := Open(ctx, dsn, clock.Real{}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Open(%q) = %v, want %q", dsn, err, want)
+		}
+	}
+
+	st, err := Open(ctx, "memory://", clock.Real{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.(*memory.Store); !ok {
+		t.Fatalf("memory:// opened a %T", st)
+	}
+}
+
+// withSchema creates an empty schema dropped at the end of the test, and
+// returns dsn with it as the search path.
+func withSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	schema := "storetest_" + strings.ReplaceAll(store.NewID(), "-", "")
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn, err := pgx.Connect(ctx, dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close(ctx)
+		if _, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Unknown connection parameters are sent to the server as settings
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
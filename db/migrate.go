@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mohsen/alertinGo/migrate"
+	"github.com/mohsen/alertinGo/migrations"
+)
+
+// migrationLockID is the advisory lock key held while migrating, so instances
+// starting together don't apply the same migration twice.
+const migrationLockID int64 = 0x616c6572746d6967 // "alertmig"
+
+// legacyMigrations tell which of the migrations run by the old runner, which
+// re-executed every file on startup without recording anything, a database
+// already has. Each query checks for the last object its migration creates.
+// Databases that runner created are recognised by their monitors table, and
+// only the migrations found in them are recorded as applied.
+var legacyMigrations = []struct {
+	version int
+	exists  string
+}{
+	{1, `SELECT to_regclass('notification_logs') IS NOT NULL`},
+	{2, `SELECT to_regclass('api_keys') IS NOT NULL`},
+	{3, hasColumn("alert_states", "rule_id")},
+	{4, `SELECT to_regclass('heartbeats_monitor_received_at_idx') IS NOT NULL`},
+	{5, hasColumn("monitors", "flapping_since")},
+	{6, hasColumn("monitors", "recovery_heartbeats")},
+	{7, hasColumn("alert_states", "suppressed")},
+	{8, hasColumn("monitors", "labels")},
+	{9, `SELECT to_regclass('alert_states_one_firing_threshold_idx') IS NOT NULL`},
+	{10, `SELECT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'monitors_recovered_notify' AND tgrelid = 'monitors'::regclass)`},
+}
+
+func hasColumn(table, column string) string {
+	return fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = '%s' AND column_name = '%s')`, table, column)
+}
+
+func (p *Postgres) Migrate(ctx context.Context) error {
+	ms, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return err
+	}
+	return migrate.Up(ctx, &pgMigrator{p: p, migrations: ms}, ms)
+}
+
+func (p *Postgres) MigrateDown(ctx context.Context, steps int) error {
+	ms, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return err
+	}
+	return migrate.Down(ctx, &pgMigrator{p: p, migrations: ms}, ms, steps)
+}
+
+func (p *Postgres) MigrationStatus(ctx context.Context) ([]migrate.Status, error) {
+	ms, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.GetStatus(ctx, &pgMigrator{p: p, migrations: ms}, ms)
+}
+
+// pgMigrator is the migrate.Driver for Postgres. Everything runs on one
+// connection, the one holding the session-level advisory lock.
+type pgMigrator struct {
+	p          *Postgres
+	migrations []migrate.Migration
+	conn       *pgxpool.Conn
+}
+
+func (m *pgMigrator) Lock(ctx context.Context) (func(), error) {
+	conn, err := m.p.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	m.conn = conn
+	unlock := func() {
+		// Use a fresh context so the lock is released even if ctx was cancelled.
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			// Closing the session releases the lock instead.
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}
+
+	if err := m.setup(ctx); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// setup creates schema_migrations and baselines databases from the old runner.
+func (m *pgMigrator) setup(ctx context.Context) error {
+	_, err := m.conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	var recorded, legacy bool
+	err = m.conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM schema_migrations),
+		       to_regclass('monitors') IS NOT NULL`,
+	).Scan(&recorded, &legacy)
+	if err != nil {
+		return err
+	}
+	if recorded || !legacy {
+		return nil
+	}
+
+	names := map[int]string{}
+	for _, mig := range m.migrations {
+		names[mig.Version] = mig.Name
+	}
+	return pgx.BeginFunc(ctx, m.conn, func(tx pgx.Tx) error {
+		var found []int
+		for _, lm := range legacyMigrations {
+			var exists bool
+			if err := tx.QueryRow(ctx, lm.exists).Scan(&exists); err != nil {
+				return fmt.Errorf("detecting migration %03d: %w", lm.version, err)
+			}
+			if !exists {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				lm.version, names[lm.version], m.p.clock.Now()); err != nil {
+				return err
+			}
+			found = append(found, lm.version)
+		}
+		log.Printf("existing database found, marked migrations %v as applied", found)
+		return nil
+	})
+}
+
+func (m *pgMigrator) Applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (m *pgMigrator) Apply(ctx context.Context, mig migrate.Migration, up bool) error {
+	return pgx.BeginFunc(ctx, m.conn, func(tx pgx.Tx) error {
+		if !up {
+			if _, err := tx.Exec(ctx, mig.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, mig.Up); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			mig.Version, mig.Name, m.p.clock.Now())
+		return err
+	})
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/migrate"
+	"github.com/mohsen/alertinGo/migrations"
+)
+
+// A database the old runner left at 006 gets 001-006 recorded and the rest
+// applied.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	dsn = withSchema(t, dsn)
+
+	ms, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	for _, m := range ms[:6] {
+		if _, err := conn.Exec(ctx, m.Up); err != nil {
+			t.Fatalf("%s: %v", m, err)
+		}
+	}
+
+	p, err := Connect(ctx, dsn, clock.Real{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := p.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			t.Errorf("%s is not applied", s.Migration)
+		}
+	}
+	var labels bool
+	if err := conn.QueryRow(ctx, hasColumn("monitors", "labels")).Scan(&labels); err != nil || !labels {
+		t.Fatalf("008_labels did not run: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/memory"
+	"github.com/mohsen/alertinGo/store/sqlite"
+)
+
+// Open returns the store selected by the scheme of dsn: postgres:// or
+// postgresql:// for Postgres, sqlite://<path> for a SQLite file, memory://
+// for an in-process store that is lost on restart. A dsn without a scheme is
+// a Postgres keyword/value string such as "host=localhost dbname=alerting".
+func Open(ctx context.Context, dsn string, clk clock.Clock) (store.Store, error) {
+	scheme, _, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return Connect(ctx, dsn, clk)
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		return Connect(ctx, dsn, clk)
+	case "sqlite":
+		return sqlite.Open(strings.TrimPrefix(dsn, "sqlite://"), clk)
+	case "memory":
+		return memory.New(clk), nil
+	}
+	return nil, fmt.Errorf("unsupported DATABASE_URL scheme %q", scheme)
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/middleware"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+type SilenceMonitorRequest struct {
+	// Seconds to silence the monitor for
+	Duration int `json:"duration" binding:"required"`
+}
+
+// GetMonitorAlerts lists the firing alerts of a monitor and who acknowledged them.
+func (h *Handler) GetMonitorAlerts(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := h.store.GetMonitorByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+
+	alerts, err := h.store.GetFiringAlerts(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alerts)
+}
+
+// AcknowledgeMonitor acknowledges the monitor's firing alerts, which stops
+// their re-alerts until they recover.
+func (h *Handler) AcknowledgeMonitor(c *gin.Context) {
+	id := c.Param("id")
+
+	monitor, err := h.store.GetMonitorByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+
+	var n int64
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if n, err = h.store.AcknowledgeAlerts(ctx, id, middleware.Actor(c)); err != nil || n == 0 {
+			return err
+		}
+		return h.audit(ctx, c, monitor.TeamID, "acknowledge", "monitor", id, nil, gin.H{"acknowledged": n})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "monitor has no unacknowledged firing alert"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"acknowledged": n})
+}
+
+// SilenceMonitor stops every notification of a monitor for a while. Alerts
+// still fire and resolve, they are just not sent.
+func (h *Handler) SilenceMonitor(c *gin.Context) {
+	var req SilenceMonitorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be a positive number of seconds"})
+		return
+	}
+
+	id := c.Param("id")
+	existing, err := h.store.GetMonitorByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+
+	until := h.clock.Now().Add(time.Duration(req.Duration) * time.Second)
+	by := middleware.Actor(c)
+	monitor, err := h.silence(c, existing, &until, &by)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, monitor)
+}
+
+func (h *Handler) UnsilenceMonitor(c *gin.Context) {
+	id := c.Param("id")
+	existing, err := h.store.GetMonitorByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+
+	monitor, err := h.silence(c, existing, nil, nil)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, monitor)
+}
+
+// silence silences a monitor until the given time, or lifts its silence if
+// until is nil, and records it.
+func (h *Handler) silence(c *gin.Context, existing *model.Monitor, until *time.Time, by *string) (*model.Monitor, error) {
+	action := "silence"
+	if until == nil {
+		action = "unsilence"
+	}
+
+	var monitor *model.Monitor
+	err := h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		if monitor, err = h.store.SilenceMonitor(ctx, existing.ID, until, by); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, monitor.TeamID, action, "monitor", existing.ID, existing, monitor)
+	})
+	return monitor, err
+}
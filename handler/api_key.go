@@ -1,14 +1,48 @@
 package handler
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
 )
 
-func ListApiKeys(c *gin.Context) {
-	keys, err := db.GetAllApiKeys(c.Request.Context())
+type CreateApiKeyRequest struct {
+	Name             string     `json:"name" binding:"required"`
+	Scopes           []string   `json:"scopes"`
+	MonitorPattern   string     `json:"monitor_pattern"`
+	CheckTypePattern string     `json:"check_type_pattern"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+type UpdateApiKeyRequest struct {
+	Name             *string    `json:"name"`
+	IsActive         *bool      `json:"is_active"`
+	Scopes           []string   `json:"scopes"`
+	MonitorPattern   *string    `json:"monitor_pattern"`
+	CheckTypePattern *string    `json:"check_type_pattern"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+type RotateApiKeyRequest struct {
+	// Seconds the old key keeps working, default one day
+	Overlap *int `json:"overlap"`
+}
+
+// CreateApiKeyResponse carries the plaintext key, which is only shown once.
+type CreateApiKeyResponse struct {
+	model.ApiKey
+	Key string `json:"key"`
+}
+
+func (h *Handler) ListApiKeys(c *gin.Context) {
+	keys, err := h.store.GetAllApiKeys(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list API keys"})
 		return
@@ -16,11 +50,222 @@ func ListApiKeys(c *gin.Context) {
 	c.JSON(http.StatusOK, keys)
 }
 
-func DeleteApiKey(c *gin.Context) {
+func (h *Handler) CreateApiKey(c *gin.Context) {
+	var req CreateApiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes, err := apikey.ParseScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(h.clock.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	var plain string
+	var key *model.ApiKey
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		plain, key, err = apikey.Create(ctx, h.store, model.ApiKey{
+			Name:             req.Name,
+			Scopes:           scopes,
+			MonitorPattern:   req.MonitorPattern,
+			CheckTypePattern: req.CheckTypePattern,
+			ExpiresAt:        req.ExpiresAt,
+		})
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, c, key.TeamID, "create", "api_key", key.ID, nil, key)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateApiKeyResponse{ApiKey: *key, Key: plain})
+}
+
+// UpdateApiKey renames, (re)activates, rescopes or rebinds a key, or moves its
+// expiry. Fields left out of the request keep their value, an empty pattern
+// allows any monitor.
+func (h *Handler) UpdateApiKey(c *gin.Context) {
 	id := c.Param("id")
-	if err := db.DeleteApiKey(c.Request.Context(), id); err != nil {
+
+	var req UpdateApiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := h.store.GetApiKeyByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	update := *existing
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+			return
+		}
+		update.Name = *req.Name
+	}
+
+	if req.IsActive != nil {
+		update.IsActive = *req.IsActive
+	}
+
+	if req.Scopes != nil {
+		if len(req.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "scopes must not be empty"})
+			return
+		}
+		if update.Scopes, err = apikey.ParseScopes(req.Scopes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if req.MonitorPattern != nil {
+		update.MonitorPattern = cmp.Or(*req.MonitorPattern, "*")
+	}
+	if req.CheckTypePattern != nil {
+		update.CheckTypePattern = cmp.Or(*req.CheckTypePattern, "*")
+	}
+
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(h.clock.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		update.ExpiresAt = req.ExpiresAt
+	}
+
+	var key *model.ApiKey
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		if key, err = h.store.UpdateApiKey(ctx, &update); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, key.TeamID, "update", "api_key", id, existing, key)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update API key"})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+func (h *Handler) DeleteApiKey(c *gin.Context) {
+	id := c.Param("id")
+
+	existing, err := h.store.GetApiKeyByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if err := h.store.DeleteApiKey(ctx, id); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, existing.TeamID, "delete", "api_key", id, existing, nil)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete API key"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "API key deleted"})
 }
+
+// RevokeApiKey deactivates a key but keeps it listed, unlike DeleteApiKey.
+func (h *Handler) RevokeApiKey(c *gin.Context) {
+	id := c.Param("id")
+
+	existing, err := h.store.GetApiKeyByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if err := h.store.RevokeApiKey(ctx, id); err != nil {
+			return err
+		}
+		key, err := h.store.GetApiKeyByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, c, key.TeamID, "revoke", "api_key", id, existing, key)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// RotateApiKey issues a replacement key. The old key keeps working for the
+// overlap period and then expires.
+func (h *Handler) RotateApiKey(c *gin.Context) {
+	var req RotateApiKeyRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	overlap := 24 * time.Hour
+	if req.Overlap != nil {
+		if *req.Overlap < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "overlap must not be negative"})
+			return
+		}
+		overlap = time.Duration(*req.Overlap) * time.Second
+	}
+
+	id := c.Param("id")
+	existing, err := h.store.GetApiKeyByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	var plain string
+	var key *model.ApiKey
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		if plain, key, err = apikey.Rotate(ctx, h.store, id, overlap, h.clock.Now()); err != nil {
+			return err
+		}
+		// The diff shows the replacement key next to the one it replaces
+		return h.audit(ctx, c, key.TeamID, "rotate", "api_key", id, existing, key)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if errors.Is(err, apikey.ErrRevoked) || errors.Is(err, apikey.ErrExpired) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateApiKeyResponse{ApiKey: *key, Key: plain})
+}
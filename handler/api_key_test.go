@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+func TestUpdateApiKey(t *testing.T) {
+	s := newServer(t)
+	a, b := s.team("a"), s.team("b")
+	admin := apiKey(s.key(a, model.ScopeAdmin))
+
+	plain, key, err := apikey.Create(store.WithTeam(context.Background(), a), s.store, model.ApiKey{Name: "hosts", Scopes: []string{model.ScopeIngest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/api-keys/" + key.ID
+
+	expiresAt := s.clock.Now().Add(24 * time.Hour)
+	var updated model.ApiKey
+	s.do("PATCH", path, admin, gin.H{
+		"scopes":             []string{"ingest", "read"},
+		"monitor_pattern":    "web-*",
+		"check_type_pattern": "http",
+		"expires_at":         expiresAt,
+	}, http.StatusOK, &updated)
+	if !slices.Equal(updated.Scopes, []string{model.ScopeIngest, model.ScopeRead}) || updated.MonitorPattern != "web-*" ||
+		updated.CheckTypePattern != "http" || updated.ExpiresAt == nil || !updated.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("updated key is %+v", updated)
+	}
+	if updated.Name != "hosts" || !updated.IsActive {
+		t.Fatalf("fields left out changed: %+v", updated)
+	}
+
+	// The key is now bound to its new patterns and scopes
+	s.do("POST", "/api/v1/heartbeat", apiKey(plain), gin.H{"monitor_name": "db-1", "check_type": "http"}, http.StatusForbidden, nil)
+	s.heartbeat(plain, "web-1")
+	s.do("GET", "/api/v1/monitors", apiKey(plain), nil, http.StatusOK, nil)
+
+	s.do("PATCH", path, admin, gin.H{"scopes": []string{"superuser"}}, http.StatusBadRequest, nil)
+	s.do("PATCH", path, admin, gin.H{"scopes": []string{}}, http.StatusBadRequest, nil)
+	s.do("PATCH", path, admin, gin.H{"expires_at": s.clock.Now()}, http.StatusBadRequest, nil)
+	s.do("PATCH", path, admin, gin.H{"name": ""}, http.StatusBadRequest, nil)
+
+	// Another team's admin can't see the key
+	s.do("PATCH", path, apiKey(s.key(b, model.ScopeAdmin)), gin.H{"is_active": false}, http.StatusNotFound, nil)
+	if got, _ := s.store.GetApiKeyByID(store.Unscoped(context.Background()), key.ID); !got.IsActive {
+		t.Fatal("another team deactivated the key")
+	}
+}
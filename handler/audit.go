@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/middleware"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// audit records a change made by the request's actor to a target of teamID.
+// before and after are the target as it was and as it is now, nil when it
+// did not or no longer exists. An update that changed nothing is not
+// recorded. Call it with the context of the transaction making the change,
+// which fails if the change can't be recorded.
+func (h *Handler) audit(ctx context.Context, c *gin.Context, teamID, action, targetType, targetID string, before, after any) error {
+	e := &model.AuditEvent{
+		TeamID:     teamID,
+		Actor:      middleware.Actor(c),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+
+	var err error
+	if e.Before, e.After, err = diff(before, after); err == nil {
+		if e.Before == nil && e.After == nil {
+			return nil
+		}
+		err = h.store.CreateAuditEvent(ctx, e)
+	}
+	if err != nil {
+		log.Printf("[audit] failed to record %s %s %s by %s: %v", action, targetType, targetID, e.Actor, err)
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// diff returns the JSON of before and after, keeping only the fields whose
+// value differs when both are set, or nil for both if none does. updated_at
+// always differs, so it is left out.
+func diff(before, after any) (json.RawMessage, json.RawMessage, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b != nil && a != nil {
+		for k, v := range b {
+			if w, ok := a[k]; k == "updated_at" || ok && reflect.DeepEqual(v, w) {
+				delete(b, k)
+				delete(a, k)
+			}
+		}
+		if len(b) == 0 && len(a) == 0 {
+			return nil, nil, nil
+		}
+	}
+	return marshalFields(b), marshalFields(a), nil
+}
+
+func jsonFields(v any) (map[string]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	return fields, json.Unmarshal(raw, &fields)
+}
+
+func marshalFields(fields map[string]any) json.RawMessage {
+	if fields == nil {
+		return nil
+	}
+	raw, _ := json.Marshal(fields)
+	return raw
+}
+
+// GetAuditEvents lists the newest audit events. Supports "actor", "action",
+// "target_type" and "target_id" filters, RFC 3339 "from"/"to" query
+// parameters and a "limit" (default 100, max 1000).
+func (h *Handler) GetAuditEvents(c *gin.Context) {
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, expected RFC 3339 timestamp"})
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to, expected RFC 3339 timestamp"})
+		return
+	}
+
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.store.GetAuditEvents(c.Request.Context(), store.AuditFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		From:       from,
+		To:         to,
+		Limit:      limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
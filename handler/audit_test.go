@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/memory"
+)
+
+// unaudited is a memory store that can't record audit events.
+type unaudited struct{ *memory.Store }
+
+func (unaudited) CreateAuditEvent(context.Context, *model.AuditEvent) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestChangesAreAudited(t *testing.T) {
+	s := newServer(t)
+	team := s.team("ops")
+	admin := apiKey(s.key(team, model.ScopeAdmin))
+
+	var channel model.NotificationChannel
+	s.do("POST", "/api/v1/channels", admin, gin.H{"name": "oncall", "telegram_chat_id": "42"}, http.StatusCreated, &channel)
+	s.do("DELETE", "/api/v1/channels/"+channel.ID, admin, nil, http.StatusOK, nil)
+
+	var events []model.AuditEvent
+	s.do("GET", "/api/v1/audit-events", admin, nil, http.StatusOK, &events)
+	if len(events) != 2 || events[0].Action != "delete" || events[1].Action != "create" || events[1].TargetID != channel.ID {
+		t.Fatalf("got audit events %+v, want the channel's create and delete", events)
+	}
+}
+
+func TestChangeFailsWithoutAuditEvent(t *testing.T) {
+	s := newServerWith(t, func(st *memory.Store) store.Store { return unaudited{st} })
+	team := s.team("ops")
+	admin := apiKey(s.key(team, model.ScopeAdmin))
+	m := s.heartbeat(s.key(team, model.ScopeIngest), "db-1")
+
+	s.do("POST", "/api/v1/channels", admin, gin.H{"name": "oncall", "telegram_chat_id": "42"}, http.StatusInternalServerError, nil)
+	s.do("POST", "/api/v1/api-keys", admin, gin.H{"name": "ci"}, http.StatusInternalServerError, nil)
+	s.do("DELETE", "/api/v1/monitors/"+m.ID, admin, nil, http.StatusInternalServerError, nil)
+
+	ctx := store.WithTeam(context.Background(), team)
+	if channels, _ := s.store.GetAllChannels(ctx); len(channels) != 0 {
+		t.Fatalf("created channels %+v without an audit event", channels)
+	}
+	if keys, _ := s.store.GetAllApiKeys(ctx); len(keys) != 2 {
+		t.Fatalf("got %d API keys, want only the test's 2", len(keys))
+	}
+	if _, err := s.store.GetMonitorByID(ctx, m.ID); err != nil {
+		t.Fatalf("deleted the monitor without an audit event: %v", err)
+	}
+}
+
+func TestMonitorUpdateAuditsChanges(t *testing.T) {
+	s := newServer(t)
+	team := s.team("ops")
+	admin := apiKey(s.key(team, model.ScopeAdmin))
+	m := s.heartbeat(s.key(team, model.ScopeIngest), "db-1")
+	path := "/api/v1/monitors/" + m.ID
+
+	s.do("PUT", path, admin, gin.H{"is_active": true}, http.StatusOK, nil)
+	// Repeating the update changes nothing, so it isn't recorded
+	s.do("PUT", path, admin, gin.H{"is_active": true}, http.StatusOK, nil)
+	s.do("PUT", path, admin, gin.H{}, http.StatusOK, nil)
+
+	var events []model.AuditEvent
+	s.do("GET", "/api/v1/audit-events", admin, nil, http.StatusOK, &events)
+	if len(events) != 1 || string(events[0].Before) != `{"is_active":false}` || string(events[0].After) != `{"is_active":true}` {
+		t.Fatalf("got audit events %+v, want only the monitor's activation", events)
+	}
+
+	s.do("PUT", path, apiKey(s.key(s.team("dev"), model.ScopeAdmin)), gin.H{"is_active": false}, http.StatusNotFound, nil)
+}
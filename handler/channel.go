@@ -1,10 +1,11 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/model"
 )
 
 type CreateChannelRequest struct {
@@ -12,8 +13,8 @@ type CreateChannelRequest struct {
 	TelegramChatID string `json:"telegram_chat_id" binding:"required"`
 }
 
-func GetChannels(c *gin.Context) {
-	channels, err := db.GetAllChannels(c.Request.Context())
+func (h *Handler) GetChannels(c *gin.Context) {
+	channels, err := h.store.GetAllChannels(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -22,14 +23,21 @@ func GetChannels(c *gin.Context) {
 	c.JSON(http.StatusOK, channels)
 }
 
-func CreateChannel(c *gin.Context) {
+func (h *Handler) CreateChannel(c *gin.Context) {
 	var req CreateChannelRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	channel, err := db.CreateChannel(c.Request.Context(), req.Name, req.TelegramChatID)
+	var channel *model.NotificationChannel
+	err := h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		if channel, err = h.store.CreateChannel(ctx, req.Name, req.TelegramChatID); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, channel.TeamID, "create", "channel", channel.ID, nil, channel)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -38,10 +46,22 @@ func CreateChannel(c *gin.Context) {
 	c.JSON(http.StatusCreated, channel)
 }
 
-func DeleteChannel(c *gin.Context) {
+func (h *Handler) DeleteChannel(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := db.DeleteChannel(c.Request.Context(), id); err != nil {
+	existing, err := h.store.GetChannelByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if err := h.store.DeleteChannel(ctx, id); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, existing.TeamID, "delete", "channel", id, existing, nil)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/store"
+)
+
+type AddParentRequest struct {
+	ParentID string `json:"parent_id" binding:"required"`
+}
+
+func (h *Handler) GetMonitorParents(c *gin.Context) {
+	parents, err := h.store.GetMonitorParents(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, parents)
+}
+
+func (h *Handler) AddMonitorParent(c *gin.Context) {
+	id := c.Param("id")
+
+	var req AddParentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ParentID == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a monitor cannot depend on itself"})
+		return
+	}
+
+	var teamIDs []string
+	for _, monitorID := range []string{id, req.ParentID} {
+		m, err := h.store.GetMonitorByID(c.Request.Context(), monitorID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+			return
+		}
+		teamIDs = append(teamIDs, m.TeamID)
+	}
+	if teamIDs[0] != teamIDs[1] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a monitor cannot depend on another team's monitor"})
+		return
+	}
+
+	err := h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if err := h.store.AddMonitorParent(ctx, id, req.ParentID); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, teamIDs[0], "add_parent", "monitor", id, nil, gin.H{"parent_id": req.ParentID})
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrDependencyCycle) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"monitor_id": id, "parent_id": req.ParentID})
+}
+
+func (h *Handler) RemoveMonitorParent(c *gin.Context) {
+	id, parentID := c.Param("id"), c.Param("parent_id")
+
+	monitor, err := h.store.GetMonitorByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if err := h.store.RemoveMonitorParent(ctx, id, parentID); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, monitor.TeamID, "remove_parent", "monitor", id, gin.H{"parent_id": parentID}, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/sso"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/watcher"
+)
+
+// Handler serves the HTTP API on top of a store. Heartbeats are also handed
+// to the watcher, which moves their deadlines and evaluates threshold rules.
+type Handler struct {
+	store   store.Store
+	watcher *watcher.Watcher
+	clock   clock.Clock
+
+	// sessionTTL is how long a user stays signed in, from SESSION_TTL seconds
+	sessionTTL time.Duration
+	// sso is nil unless single sign-on is configured
+	sso *sso.Provider
+}
+
+func New(st store.Store, w *watcher.Watcher, clk clock.Clock, provider *sso.Provider) *Handler {
+	h := &Handler{store: st, watcher: w, clock: clk, sessionTTL: 24 * time.Hour, sso: provider}
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("SESSION_TTL must be a positive number of seconds, got: %s", v)
+		}
+		h.sessionTTL = time.Duration(n) * time.Second
+	}
+	return h
+}
@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/account"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/middleware"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/sso"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/memory"
+	"github.com/mohsen/alertinGo/watcher"
+)
+
+const adminToken = "test-admin-token"
+
+// server is the API on a memory store, as wired up by cmd/main.go.
+type server struct {
+	t      *testing.T
+	router *gin.Engine
+	auth   *middleware.Auth
+	store  *memory.Store
+	clock  *clock.Fake
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	return newServerWith(t, func(st *memory.Store) store.Store { return st })
+}
+
+// newServerWith serves the API on the store wrap returns for the memory
+// store, which the test helpers keep using directly.
+func newServerWith(t *testing.T, wrap func(*memory.Store) store.Store) *server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	st := memory.New(clk)
+	h := New(wrap(st), watcher.New(st, clk), clk, sso.New(context.Background(), st))
+	r := gin.New()
+	// cmd/main.go with TRUSTED_PROXIES unset
+	r.SetTrustedProxies(nil)
+	auth := middleware.NewAuth(st, adminToken, clk)
+	h.Register(r, auth)
+	return &server{t: t, router: r, auth: auth, store: st, clock: clk}
+}
+
+// team creates a team and returns its ID.
+func (s *server) team(name string) string {
+	s.t.Helper()
+	team, err := s.store.CreateTeam(store.Unscoped(context.Background()), name)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return team.ID
+}
+
+// key creates an API key in a team and returns it in plain text.
+func (s *server) key(teamID string, scopes ...string) string {
+	s.t.Helper()
+	plain, _, err := apikey.Create(store.WithTeam(context.Background(), teamID), s.store, model.ApiKey{Name: "test", Scopes: scopes})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return plain
+}
+
+// session creates a user in a team and signs it in, returning the session token.
+func (s *server) session(teamID, username, role string) string {
+	s.t.Helper()
+	if _, err := account.Create(store.WithTeam(context.Background(), teamID), s.store, model.User{Username: username, Role: role}, "password123"); err != nil {
+		s.t.Fatal(err)
+	}
+	var resp struct{ Token string }
+	s.do("POST", "/api/v1/auth/login", nil, gin.H{"username": username, "password": "password123"}, http.StatusOK, &resp)
+	return resp.Token
+}
+
+// do sends a request and checks its status, decoding the response into out
+// if it is not nil.
+func (s *server) do(method, path string, headers map[string]string, body any, want int, out any) {
+	s.t.Helper()
+	rec := s.request(method, path, headers, body)
+	if rec.Code != want {
+		s.t.Fatalf("%s %s: status %d, want %d: %s", method, path, rec.Code, want, rec.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+func (s *server) request(method, path string, headers map[string]string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func apiKey(key string) map[string]string {
+	return map[string]string{"X-API-Key": key}
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// heartbeat reports a monitor with a key and returns it.
+func (s *server) heartbeat(key, name string) model.Monitor {
+	s.t.Helper()
+	var m model.Monitor
+	s.do("POST", "/api/v1/heartbeat", apiKey(key), gin.H{"monitor_name": name, "check_type": "http", "timeout": 60}, http.StatusOK, &m)
+	return m
+}
+
+func TestHeartbeatCreatesMonitorInKeysTeam(t *testing.T) {
+	s := newServer(t)
+	team := s.team("payments")
+	m := s.heartbeat(s.key(team, model.ScopeIngest), "api-1")
+
+	if m.TeamID != team || m.Status != "unknown" {
+		t.Fatalf("got monitor in team %s with status %s, want team %s, unknown", m.TeamID, m.Status, team)
+	}
+
+	var heartbeats []model.Heartbeat
+	s.do("GET", "/api/v1/monitors/"+m.ID+"/heartbeats", apiKey(s.key(team, model.ScopeRead)), nil, http.StatusOK, &heartbeats)
+	if len(heartbeats) != 1 {
+		t.Fatalf("got %d heartbeats, want 1", len(heartbeats))
+	}
+}
+
+func TestKeyPatternsLimitHeartbeats(t *testing.T) {
+	s := newServer(t)
+	team := s.team("web")
+	ctx := store.WithTeam(context.Background(), team)
+	plain, key, err := apikey.Create(ctx, s.store, model.ApiKey{Name: "web-hosts", Scopes: []string{model.ScopeIngest}, MonitorPattern: "web-*", CheckTypePattern: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []gin.H{
+		{"monitor_name": "db-1", "check_type": "http"},
+		{"monitor_name": "web-1", "check_type": "tcp"},
+	} {
+		rec := s.request("POST", "/api/v1/heartbeat", apiKey(plain), req)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "may not report") {
+			t.Fatalf("heartbeat of %v: status %d: %s, want 403 for the pattern", req, rec.Code, rec.Body)
+		}
+	}
+	if monitors, _ := s.store.GetAllMonitors(ctx); len(monitors) != 0 {
+		t.Fatalf("created monitors %+v outside the key's patterns", monitors)
+	}
+
+	m := s.heartbeat(plain, "web-1")
+	if m.CreatedByKeyID == nil || *m.CreatedByKeyID != key.ID {
+		t.Fatalf("monitor created by key %v, want %s", m.CreatedByKeyID, key.ID)
+	}
+}
+
+func TestTeamsOnlySeeTheirOwnMonitors(t *testing.T) {
+	s := newServer(t)
+	a, b := s.team("a"), s.team("b")
+	mine := s.heartbeat(s.key(a, model.ScopeIngest), "mine")
+	theirs := s.heartbeat(s.key(b, model.ScopeIngest), "theirs")
+
+	key := apiKey(s.key(a, model.ScopeAdmin))
+	var monitors []model.Monitor
+	s.do("GET", "/api/v1/monitors", key, nil, http.StatusOK, &monitors)
+	if len(monitors) != 1 || monitors[0].ID != mine.ID {
+		t.Fatalf("team a lists %+v, want only its own monitor", monitors)
+	}
+
+	s.do("GET", "/api/v1/monitors/"+theirs.ID, key, nil, http.StatusNotFound, nil)
+	s.do("PUT", "/api/v1/monitors/"+theirs.ID, key, gin.H{"is_active": true}, http.StatusNotFound, nil)
+	s.do("DELETE", "/api/v1/monitors/"+theirs.ID, key, nil, http.StatusNotFound, nil)
+	s.do("POST", "/api/v1/monitors/"+theirs.ID+"/ack", key, nil, http.StatusNotFound, nil)
+
+	// A user session is scoped the same way
+	session := bearer(s.session(a, "alice", model.RoleAdmin))
+	s.do("GET", "/api/v1/monitors/"+theirs.ID, session, nil, http.StatusNotFound, nil)
+}
+
+func TestAdminTokenSeesEveryTeam(t *testing.T) {
+	s := newServer(t)
+	a, b := s.team("a"), s.team("b")
+	s.heartbeat(s.key(a, model.ScopeIngest), "a-1")
+	s.heartbeat(s.key(b, model.ScopeIngest), "b-1")
+
+	var monitors []model.Monitor
+	s.do("GET", "/api/v1/monitors", bearer(adminToken), nil, http.StatusOK, &monitors)
+	if len(monitors) != 2 {
+		t.Fatalf("admin token lists %d monitors, want 2", len(monitors))
+	}
+
+	headers := bearer(adminToken)
+	headers["X-Team-ID"] = b
+	s.do("GET", "/api/v1/monitors", headers, nil, http.StatusOK, &monitors)
+	if len(monitors) != 1 || monitors[0].TeamID != b {
+		t.Fatalf("admin token with X-Team-ID lists %+v, want team b's monitor", monitors)
+	}
+	s.do("POST", "/api/v1/teams", headers, gin.H{"name": "c"}, http.StatusForbidden, nil)
+	s.do("POST", "/api/v1/teams", bearer(adminToken), gin.H{"name": "c"}, http.StatusCreated, nil)
+}
+
+func TestAcknowledgeIsAttributedToUser(t *testing.T) {
+	s := newServer(t)
+	team := s.team("ops")
+	m := s.heartbeat(s.key(team, model.ScopeIngest), "db-1")
+
+	ctx := store.Unscoped(context.Background())
+	if _, err := s.store.CreateAlertState(ctx, m.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	viewer := bearer(s.session(team, "victor", model.RoleViewer))
+	s.do("POST", "/api/v1/monitors/"+m.ID+"/ack", viewer, nil, http.StatusForbidden, nil)
+
+	responder := bearer(s.session(team, "rita", model.RoleResponder))
+	s.do("POST", "/api/v1/monitors/"+m.ID+"/ack", responder, nil, http.StatusOK, nil)
+	s.do("POST", "/api/v1/monitors/"+m.ID+"/ack", responder, nil, http.StatusConflict, nil)
+
+	var alerts []model.AlertState
+	s.do("GET", "/api/v1/monitors/"+m.ID+"/alerts", viewer, nil, http.StatusOK, &alerts)
+	if len(alerts) != 1 || alerts[0].AcknowledgedBy == nil || *alerts[0].AcknowledgedBy != "user:rita" {
+		t.Fatalf("got alerts %+v, want one acknowledged by user:rita", alerts)
+	}
+}
+
+func TestLoginAndLogout(t *testing.T) {
+	s := newServer(t)
+	token := s.session(s.team("ops"), "alice", model.RoleViewer)
+
+	var me model.User
+	s.do("GET", "/api/v1/auth/me", bearer(token), nil, http.StatusOK, &me)
+	if me.Username != "alice" {
+		t.Fatalf("signed in as %q, want alice", me.Username)
+	}
+
+	s.do("POST", "/api/v1/auth/login", nil, gin.H{"username": "alice", "password": "wrong-password"}, http.StatusUnauthorized, nil)
+	s.do("POST", "/api/v1/auth/logout", bearer(token), nil, http.StatusOK, nil)
+	s.do("GET", "/api/v1/auth/me", bearer(token), nil, http.StatusUnauthorized, nil)
+}
+
+func TestClientIPOnlyFromTrustedProxies(t *testing.T) {
+	s := newServer(t)
+	team := s.team("ops")
+	key := s.key(team, model.ScopeIngest, model.ScopeRead)
+
+	// Returns the IP the key was last used from and the heartbeat came from
+	send := func() (string, string) {
+		s.auth.Start(context.Background())
+		headers := apiKey(key)
+		headers["X-Forwarded-For"] = "203.0.113.9"
+		var m model.Monitor
+		s.do("POST", "/api/v1/heartbeat", headers, gin.H{"monitor_name": "web", "check_type": "http"}, http.StatusOK, &m)
+		s.auth.Stop()
+
+		var keys []model.ApiKey
+		s.do("GET", "/api/v1/api-keys", bearer(adminToken), nil, http.StatusOK, &keys)
+		var heartbeats []model.Heartbeat
+		s.do("GET", "/api/v1/monitors/"+m.ID+"/heartbeats?limit=1", bearer(adminToken), nil, http.StatusOK, &heartbeats)
+		return keys[0].LastUsedIP, heartbeats[0].SourceIP
+	}
+
+	// httptest requests come from 192.0.2.1
+	if used, source := send(); used != "192.0.2.1" || source != "192.0.2.1" {
+		t.Fatalf("without trusted proxies got key use from %s and heartbeat from %s, want the peer address", used, source)
+	}
+	s.router.SetTrustedProxies([]string{"192.0.2.1"})
+	s.clock.Advance(time.Second)
+	if used, source := send(); used != "203.0.113.9" || source != "203.0.113.9" {
+		t.Fatalf("through a trusted proxy got key use from %s and heartbeat from %s, want the forwarded address", used, source)
+	}
+}
@@ -2,37 +2,59 @@ package handler
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/middleware"
 	"github.com/mohsen/alertinGo/model"
 )
 
 type HeartbeatRequest struct {
-	MonitorName     string      `json:"monitor_name" binding:"required"`
-	CheckType       string      `json:"check_type" binding:"required"`
-	Message         string      `json:"message"`
-	Metadata        interface{} `json:"metadata"`
-	Timeout         int         `json:"timeout"`
-	ReAlertInterval int         `json:"re_alert_interval"`
-	ServerIP        string      `json:"server_ip"`
-	ServerName      string      `json:"server_name"`
+	MonitorName            string            `json:"monitor_name" binding:"required"`
+	CheckType              string            `json:"check_type" binding:"required"`
+	Message                string            `json:"message"`
+	Metadata               interface{}       `json:"metadata"`
+	Timeout                int               `json:"timeout"`
+	ReAlertInterval        int               `json:"re_alert_interval"`
+	AlertAfterMisses       int               `json:"alert_after_misses"`
+	RecoverAfterHeartbeats int               `json:"recover_after_heartbeats"`
+	ServerIP               string            `json:"server_ip"`
+	ServerName             string            `json:"server_name"`
+	Labels                 map[string]string `json:"labels"`
 }
 
-func PostHeartbeat(c *gin.Context) {
+func (h *Handler) PostHeartbeat(c *gin.Context) {
 	var req HeartbeatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	key := middleware.APIKey(c)
+	if key != nil && !key.AllowsMonitor(req.MonitorName, req.CheckType) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key may not report this monitor_name and check_type"})
+		return
+	}
+
 	if req.Timeout <= 0 {
 		req.Timeout = 60
 	}
 	if req.ReAlertInterval <= 0 {
 		req.ReAlertInterval = 300
 	}
+	if req.AlertAfterMisses <= 0 {
+		req.AlertAfterMisses = 1
+	}
+	if req.RecoverAfterHeartbeats <= 0 {
+		req.RecoverAfterHeartbeats = 1
+	}
+
+	if req.Labels == nil {
+		req.Labels = map[string]string{}
+	}
 
 	metadataStr := "{}"
 	if req.Metadata != nil {
@@ -43,21 +65,79 @@ func PostHeartbeat(c *gin.Context) {
 	}
 
 	m := &model.Monitor{
-		MonitorName:     req.MonitorName,
-		CheckType:       req.CheckType,
-		Message:         req.Message,
-		Metadata:        metadataStr,
-		Timeout:         req.Timeout,
-		ReAlertInterval: req.ReAlertInterval,
-		ServerIP:        req.ServerIP,
-		ServerName:      req.ServerName,
+		MonitorName:            req.MonitorName,
+		CheckType:              req.CheckType,
+		Message:                req.Message,
+		Metadata:               metadataStr,
+		Timeout:                req.Timeout,
+		ReAlertInterval:        req.ReAlertInterval,
+		AlertAfterMisses:       req.AlertAfterMisses,
+		RecoverAfterHeartbeats: req.RecoverAfterHeartbeats,
+		ServerIP:               req.ServerIP,
+		ServerName:             req.ServerName,
+		Labels:                 req.Labels,
+	}
+	if key != nil {
+		m.CreatedByKeyID = &key.ID
 	}
 
-	result, err := db.UpsertMonitor(c.Request.Context(), m)
+	result, err := h.store.UpsertMonitor(c.Request.Context(), m)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := h.store.CreateHeartbeat(c.Request.Context(), result.ID, req.Message, metadataStr, c.ClientIP()); err != nil {
+		log.Printf("[heartbeat] error storing history for monitor %s: %v", result.ID, err)
+	}
+
+	h.watcher.Touch(result)
+	h.watcher.QueueThresholds(result)
+
 	c.JSON(http.StatusOK, result)
 }
+
+// GetHeartbeats lists a monitor's heartbeat history. Supports optional
+// RFC 3339 "from"/"to" query parameters and a "limit" (default 100, max 1000).
+func (h *Handler) GetHeartbeats(c *gin.Context) {
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, expected RFC 3339 timestamp"})
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to, expected RFC 3339 timestamp"})
+		return
+	}
+
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+
+	heartbeats, err := h.store.GetHeartbeats(c.Request.Context(), c.Param("id"), from, to, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, heartbeats)
+}
+
+func parseTimeQuery(c *gin.Context, param string) (*time.Time, error) {
+	v := c.Query(param)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -1,14 +1,17 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
 )
 
-func GetMonitors(c *gin.Context) {
-	monitors, err := db.GetAllMonitors(c.Request.Context())
+func (h *Handler) GetMonitors(c *gin.Context) {
+	monitors, err := h.store.GetAllMonitors(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -17,10 +20,10 @@ func GetMonitors(c *gin.Context) {
 	c.JSON(http.StatusOK, monitors)
 }
 
-func GetMonitor(c *gin.Context) {
+func (h *Handler) GetMonitor(c *gin.Context) {
 	id := c.Param("id")
 
-	monitor, err := db.GetMonitorByID(c.Request.Context(), id)
+	monitor, err := h.store.GetMonitorByID(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
 		return
@@ -34,7 +37,10 @@ type UpdateMonitorRequest struct {
 	ChannelID *string `json:"channel_id"`
 }
 
-func UpdateMonitor(c *gin.Context) {
+// errChannelNotInTeam rejects assigning a channel of another team.
+var errChannelNotInTeam = errors.New("channel not found in the monitor's team")
+
+func (h *Handler) UpdateMonitor(c *gin.Context) {
 	id := c.Param("id")
 
 	var req UpdateMonitorRequest
@@ -43,36 +49,70 @@ func UpdateMonitor(c *gin.Context) {
 		return
 	}
 
-	// Get current monitor first
-	existing, err := db.GetMonitorByID(c.Request.Context(), id)
-	if err != nil {
+	// The monitor is read within the transaction, so the audit event shows
+	// the row this update replaces
+	var monitor *model.Monitor
+	err := h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		existing, err := h.store.GetMonitorByID(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		isActive := existing.IsActive
+		if req.IsActive != nil {
+			isActive = *req.IsActive
+		}
+
+		channelID := existing.ChannelID
+		if req.ChannelID != nil {
+			ch, err := h.store.GetChannelByID(ctx, *req.ChannelID)
+			if err != nil || ch.TeamID != existing.TeamID {
+				return errChannelNotInTeam
+			}
+			channelID = req.ChannelID
+		}
+
+		if monitor, err = h.store.UpdateMonitor(ctx, id, isActive, channelID); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, monitor.TeamID, "update", "monitor", id, existing, monitor)
+	})
+	if errors.Is(err, store.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
 		return
 	}
-
-	isActive := existing.IsActive
-	if req.IsActive != nil {
-		isActive = *req.IsActive
-	}
-
-	channelID := existing.ChannelID
-	if req.ChannelID != nil {
-		channelID = req.ChannelID
+	if errors.Is(err, errChannelNotInTeam) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-
-	monitor, err := db.UpdateMonitor(c.Request.Context(), id, isActive, channelID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.watcher.Touch(monitor)
+
 	c.JSON(http.StatusOK, monitor)
 }
 
-func DeleteMonitor(c *gin.Context) {
+func (h *Handler) DeleteMonitor(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := db.DeleteMonitor(c.Request.Context(), id); err != nil {
+	err := h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		existing, err := h.store.GetMonitorByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := h.store.DeleteMonitor(ctx, id); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, existing.TeamID, "delete", "monitor", id, existing, nil)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -4,11 +4,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/mohsen/alertinGo/db"
 )
 
-func GetNotificationLogs(c *gin.Context) {
-	logs, err := db.GetNotificationLogs(c.Request.Context())
+func (h *Handler) GetNotificationLogs(c *gin.Context) {
+	logs, err := h.store.GetNotificationLogs(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
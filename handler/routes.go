@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/middleware"
+	"github.com/mohsen/alertinGo/model"
+)
+
+// Register adds the API routes to r, guarded by auth.
+func (h *Handler) Register(r gin.IRouter, auth *middleware.Auth) {
+	api := r.Group("/api/v1")
+	api.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	api.POST("/heartbeat", auth.RequireAPIKey(model.ScopeIngest), h.PostHeartbeat)
+	api.POST("/auth/login", h.Login)
+	api.GET("/auth/oidc/login", h.SSOLogin)
+	api.GET("/auth/oidc/callback", h.SSOCallback)
+
+	// The management API takes the admin token, a user session or an API
+	// key with the read scope for reads, the respond scope to acknowledge
+	// and silence, the admin scope for other changes
+	read := api.Group("", auth.RequireAdmin(model.ScopeRead))
+	respond := api.Group("", auth.RequireAdmin(model.ScopeRespond))
+	admin := api.Group("", auth.RequireAdmin(model.ScopeAdmin))
+
+	read.POST("/auth/logout", h.Logout)
+	read.GET("/auth/me", h.GetCurrentUser)
+	read.POST("/auth/password", h.ChangePassword)
+
+	read.GET("/users", h.GetUsers)
+	admin.POST("/users", h.CreateUser)
+	admin.PATCH("/users/:id", h.UpdateUser)
+	admin.DELETE("/users/:id", h.DeleteUser)
+
+	read.GET("/teams", h.GetTeams)
+	admin.POST("/teams", h.CreateTeam)
+
+	read.GET("/api-keys", h.ListApiKeys)
+	admin.POST("/api-keys", h.CreateApiKey)
+	admin.PATCH("/api-keys/:id", h.UpdateApiKey)
+	admin.DELETE("/api-keys/:id", h.DeleteApiKey)
+	admin.POST("/api-keys/:id/revoke", h.RevokeApiKey)
+	admin.POST("/api-keys/:id/rotate", h.RotateApiKey)
+
+	read.GET("/monitors", h.GetMonitors)
+	read.GET("/monitors/:id", h.GetMonitor)
+	admin.PUT("/monitors/:id", h.UpdateMonitor)
+	admin.DELETE("/monitors/:id", h.DeleteMonitor)
+
+	read.GET("/monitors/:id/heartbeats", h.GetHeartbeats)
+
+	read.GET("/monitors/:id/alerts", h.GetMonitorAlerts)
+	respond.POST("/monitors/:id/ack", h.AcknowledgeMonitor)
+	respond.POST("/monitors/:id/silence", h.SilenceMonitor)
+	respond.DELETE("/monitors/:id/silence", h.UnsilenceMonitor)
+
+	read.GET("/monitors/:id/parents", h.GetMonitorParents)
+	admin.POST("/monitors/:id/parents", h.AddMonitorParent)
+	admin.DELETE("/monitors/:id/parents/:parent_id", h.RemoveMonitorParent)
+
+	read.GET("/monitors/:id/rules", h.GetThresholdRules)
+	admin.POST("/monitors/:id/rules", h.CreateThresholdRule)
+	admin.DELETE("/monitors/:id/rules/:rule_id", h.DeleteThresholdRule)
+
+	read.GET("/channels", h.GetChannels)
+	admin.POST("/channels", h.CreateChannel)
+	admin.DELETE("/channels/:id", h.DeleteChannel)
+
+	read.GET("/notification-logs", h.GetNotificationLogs)
+	admin.GET("/audit-events", h.GetAuditEvents)
+}
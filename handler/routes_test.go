@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// routeScopes is the scope every route requires, "" for public routes. New
+// routes fail TestRouteScopes until they are listed here.
+var routeScopes = map[string]string{
+	"GET /api/v1/health":             "",
+	"POST /api/v1/auth/login":        "",
+	"GET /api/v1/auth/oidc/login":    "",
+	"GET /api/v1/auth/oidc/callback": "",
+
+	"POST /api/v1/heartbeat": model.ScopeIngest,
+
+	"POST /api/v1/auth/logout":   model.ScopeRead,
+	"GET /api/v1/auth/me":        model.ScopeRead,
+	"POST /api/v1/auth/password": model.ScopeRead,
+
+	"GET /api/v1/users":           model.ScopeRead,
+	"POST /api/v1/users":          model.ScopeAdmin,
+	"PATCH /api/v1/users/:id":     model.ScopeAdmin,
+	"DELETE /api/v1/users/:id":    model.ScopeAdmin,
+	"GET /api/v1/teams":           model.ScopeRead,
+	"POST /api/v1/teams":          model.ScopeAdmin,
+	"GET /api/v1/api-keys":        model.ScopeRead,
+	"POST /api/v1/api-keys":       model.ScopeAdmin,
+	"PATCH /api/v1/api-keys/:id":  model.ScopeAdmin,
+	"DELETE /api/v1/api-keys/:id": model.ScopeAdmin,
+
+	"POST /api/v1/api-keys/:id/revoke": model.ScopeAdmin,
+	"POST /api/v1/api-keys/:id/rotate": model.ScopeAdmin,
+
+	"GET /api/v1/monitors":                           model.ScopeRead,
+	"GET /api/v1/monitors/:id":                       model.ScopeRead,
+	"PUT /api/v1/monitors/:id":                       model.ScopeAdmin,
+	"DELETE /api/v1/monitors/:id":                    model.ScopeAdmin,
+	"GET /api/v1/monitors/:id/heartbeats":            model.ScopeRead,
+	"GET /api/v1/monitors/:id/alerts":                model.ScopeRead,
+	"POST /api/v1/monitors/:id/ack":                  model.ScopeRespond,
+	"POST /api/v1/monitors/:id/silence":              model.ScopeRespond,
+	"DELETE /api/v1/monitors/:id/silence":            model.ScopeRespond,
+	"GET /api/v1/monitors/:id/parents":               model.ScopeRead,
+	"POST /api/v1/monitors/:id/parents":              model.ScopeAdmin,
+	"DELETE /api/v1/monitors/:id/parents/:parent_id": model.ScopeAdmin,
+	"GET /api/v1/monitors/:id/rules":                 model.ScopeRead,
+	"POST /api/v1/monitors/:id/rules":                model.ScopeAdmin,
+	"DELETE /api/v1/monitors/:id/rules/:rule_id":     model.ScopeAdmin,
+
+	"GET /api/v1/channels":        model.ScopeRead,
+	"POST /api/v1/channels":       model.ScopeAdmin,
+	"DELETE /api/v1/channels/:id": model.ScopeAdmin,
+
+	"GET /api/v1/notification-logs": model.ScopeRead,
+	"GET /api/v1/audit-events":      model.ScopeAdmin,
+}
+
+// narrowRoles are the roles of users one step short of a scope.
+var narrowRoles = map[string]string{
+	model.ScopeRespond: model.RoleViewer,
+	model.ScopeAdmin:   model.RoleResponder,
+}
+
+var pathParam = regexp.MustCompile(`:\w+`)
+
+// Every route is listed in routeScopes, and rejects requests without
+// credentials or with a key or user lacking its scope.
+func TestRouteScopes(t *testing.T) {
+	s := newServer(t)
+	team := s.team("ops")
+	sessions := map[string]map[string]string{}
+	for _, role := range narrowRoles {
+		sessions[role] = bearer(s.session(team, role, role))
+	}
+
+	seen := map[string]bool{}
+	for _, route := range s.router.Routes() {
+		name := route.Method + " " + route.Path
+		seen[name] = true
+		scope, ok := routeScopes[name]
+		if !ok {
+			t.Errorf("%s is not listed in routeScopes", name)
+			continue
+		}
+		if scope == "" {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			s := *s
+			s.t = t
+			path := pathParam.ReplaceAllString(route.Path, store.NewID())
+			s.do(route.Method, path, nil, nil, http.StatusUnauthorized, nil)
+
+			// The admin scope grants every other one
+			var narrow []string
+			for _, sc := range model.Scopes {
+				if sc != scope && sc != model.ScopeAdmin {
+					narrow = append(narrow, sc)
+				}
+			}
+			s.do(route.Method, path, apiKey(s.key(team, narrow...)), nil, http.StatusForbidden, nil)
+
+			if role, ok := narrowRoles[scope]; ok {
+				s.do(route.Method, path, sessions[role], nil, http.StatusForbidden, nil)
+			}
+		})
+	}
+
+	for name := range routeScopes {
+		if !seen[name] {
+			t.Errorf("%s is listed in routeScopes but not registered", name)
+		}
+	}
+}
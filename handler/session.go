@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/account"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/middleware"
+	"github.com/mohsen/alertinGo/store"
+)
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// Login starts a session. The returned token is sent as a bearer token and
+// is only shown once.
+func (h *Handler) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Usernames are unique across teams, the user decides the session's team
+	token, user, expiresAt, err := account.Login(store.Unscoped(c.Request.Context()), h.store, req.Username, req.Password, h.sessionTTL, h.clock.Now())
+	if errors.Is(err, account.ErrInvalidLogin) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt, "user": user})
+}
+
+// Logout ends the session the request was made with.
+func (h *Handler) Logout(c *gin.Context) {
+	if middleware.User(c) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not signed in with a session"})
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if err := h.store.DeleteSession(c.Request.Context(), apikey.Hash(token)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "signed out"})
+}
+
+// GetCurrentUser returns the signed-in user.
+func (h *Handler) GetCurrentUser(c *gin.Context) {
+	user := middleware.User(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not signed in with a session"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword sets the signed-in user's password, which ends all of their
+// sessions, including this one.
+func (h *Handler) ChangePassword(c *gin.Context) {
+	user := middleware.User(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not signed in with a session"})
+		return
+	}
+
+	if user.SSO {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "users signing in with SSO have no password here"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ok, err := account.CheckPassword(c.Request.Context(), h.store, user.Username, req.CurrentPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check password"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "current password is wrong"})
+		return
+	}
+
+	hash, err := account.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.store.SetUserPassword(c.Request.Context(), user.ID, hash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password changed, sign in again"})
+}
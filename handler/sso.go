@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"github.com/mohsen/alertinGo/account"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/sso"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// ssoCookie holds the state, nonce and PKCE verifier of a sign-in between
+// the redirect to the identity provider and the callback.
+const (
+	ssoCookie     = "alertingo_oidc"
+	ssoCookiePath = "/api/v1/auth/oidc"
+	ssoCookieAge  = 10 * 60
+)
+
+// SSOLogin redirects to the identity provider.
+func (h *Handler) SSOLogin(c *gin.Context) {
+	if h.sso == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "single sign-on is not configured"})
+		return
+	}
+
+	state, err := sso.RandomString()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	nonce, err := sso.RandomString()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	url, err := h.sso.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("[sso] %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider is unavailable"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoCookie, state+"."+nonce+"."+verifier, ssoCookieAge, ssoCookiePath, "", h.sso.SecureCookies(), true)
+	c.Redirect(http.StatusFound, url)
+}
+
+// SSOCallback finishes a sign-in started by SSOLogin. The user is created or
+// updated from the ID token, its role following its groups on every sign-in,
+// and a session is started like Login does.
+func (h *Handler) SSOCallback(c *gin.Context) {
+	if h.sso == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "single sign-on is not configured"})
+		return
+	}
+
+	cookie, _ := c.Cookie(ssoCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoCookie, "", -1, ssoCookiePath, "", h.sso.SecureCookies(), true)
+
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in failed: " + strings.TrimSpace(e+" "+c.Query("error_description"))})
+		return
+	}
+
+	parts := strings.Split(cookie, ".")
+	state := c.Query("state")
+	if len(parts) != 3 || state == "" || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sign-in expired or was started elsewhere, try again"})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	id, err := h.sso.Exchange(ctx, code, parts[1], parts[2])
+	if err != nil {
+		log.Printf("[sso] sign-in failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in failed"})
+		return
+	}
+
+	role, err := h.sso.Role(id)
+	if errors.Is(err, sso.ErrNoRole) {
+		log.Printf("[sso] %s is in none of the mapped groups: %v", id.Username, id.Groups)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.store.UpsertSSOUser(store.WithTeam(ctx, h.sso.TeamID), &model.User{Username: id.Username, Role: role}, id.Subject)
+	if errors.Is(err, store.ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "username " + id.Username + " is taken by another user"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+		return
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is deactivated"})
+		return
+	}
+
+	token, expiresAt, err := account.StartSession(store.WithTeam(ctx, user.TeamID), h.store, user, h.sessionTTL, h.clock.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+		return
+	}
+
+	log.Printf("[sso] %s signed in as %s", user.Username, user.Role)
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt, "user": user})
+}
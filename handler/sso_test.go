@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/mohsen/alertinGo/model"
+)
+
+const (
+	ssoClientID    = "alertingo"
+	ssoRedirectURL = "http://alertingo.test/api/v1/auth/oidc/callback"
+)
+
+// issuer is an OIDC identity provider serving discovery, its signing keys, an
+// authorization endpoint that signs the next user in right away, and a token
+// endpoint checking the PKCE verifier.
+type issuer struct {
+	t      *testing.T
+	server *httptest.Server
+	signer jose.Signer
+	keys   jose.JSONWebKeySet
+
+	mu sync.Mutex
+	// user is who the authorization endpoint signs in
+	user   map[string]any
+	codes  map[string]authorization
+	nonce  string // overrides the nonce of the ID token if set
+	issued int
+}
+
+type authorization struct {
+	challenge string
+	nonce     string
+	user      map[string]any
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss := &issuer{
+		t:      t,
+		signer: signer,
+		keys:   jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}},
+		codes:  map[string]authorization{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, iss.keys) })
+	mux.HandleFunc("GET /authorize", iss.authorize)
+	mux.HandleFunc("POST /token", iss.token)
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+
+	t.Setenv("OIDC_ISSUER", iss.server.URL)
+	t.Setenv("OIDC_CLIENT_ID", ssoClientID)
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_REDIRECT_URL", ssoRedirectURL)
+	t.Setenv("OIDC_GROUP_ROLES", "sre=admin,oncall=responder")
+	for _, key := range []string{"OIDC_SCOPES", "OIDC_USERNAME_CLAIM", "OIDC_GROUPS_CLAIM", "OIDC_DEFAULT_ROLE", "OIDC_TEAM"} {
+		t.Setenv(key, "")
+	}
+	return iss
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (iss *issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	url := iss.server.URL
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                url,
+		"authorization_endpoint":                url + "/authorize",
+		"token_endpoint":                        url + "/token",
+		"jwks_uri":                              url + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (iss *issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != ssoClientID || q.Get("redirect_uri") != ssoRedirectURL || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+
+	iss.mu.Lock()
+	iss.issued++
+	code := "code-" + strconv.Itoa(iss.issued)
+	iss.codes[code] = authorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), user: iss.user}
+	iss.mu.Unlock()
+
+	http.Redirect(w, r, ssoRedirectURL+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (iss *issuer) token(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	auth, ok := iss.codes[r.PostFormValue("code")]
+	delete(iss.codes, r.PostFormValue("code"))
+	nonce := iss.nonce
+	iss.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if nonce == "" {
+		nonce = auth.nonce
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   iss.server.URL,
+		"aud":   ssoClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range auth.user {
+		claims[k] = v
+	}
+	payload, _ := json.Marshal(claims)
+	signed, err := iss.signer.Sign(payload)
+	if err != nil {
+		iss.t.Error(err)
+		return
+	}
+	idToken, _ := signed.CompactSerialize()
+	writeJSON(w, http.StatusOK, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600, "id_token": idToken})
+}
+
+// signIn starts a sign-in as user and follows it through the identity
+// provider, returning the callback path and the sign-in cookie.
+func (s *server) signIn(iss *issuer, user map[string]any) (string, string) {
+	s.t.Helper()
+	iss.mu.Lock()
+	iss.user = user
+	iss.mu.Unlock()
+
+	rec := s.request("GET", "/api/v1/auth/oidc/login", nil, nil)
+	if rec.Code != http.StatusFound {
+		s.t.Fatalf("sign-in started with status %d: %s", rec.Code, rec.Body)
+	}
+	var cookie string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == ssoCookie {
+			cookie = c.Value
+		}
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		s.t.Fatalf("identity provider answered %d: %v", resp.StatusCode, err)
+	}
+	return callback.RequestURI(), cookie
+}
+
+func withCookie(value string) map[string]string {
+	return map[string]string{"Cookie": ssoCookie + "=" + value}
+}
+
+var (
+	alice = map[string]any{"sub": "subject-alice", "preferred_username": "alice", "groups": []string{"eng", "sre"}}
+	bob   = map[string]any{"sub": "subject-bob", "preferred_username": "bob", "groups": []string{"marketing"}}
+)
+
+func TestSSOSignIn(t *testing.T) {
+	iss := newIssuer(t)
+	s := newServer(t)
+
+	callback, cookie := s.signIn(iss, alice)
+	var resp struct {
+		Token string
+		User  model.User
+	}
+	s.do("GET", callback, withCookie(cookie), nil, http.StatusOK, &resp)
+	if resp.User.Username != "alice" || resp.User.Role != model.RoleAdmin || !resp.User.SSO || resp.User.TeamID != model.DefaultTeamID {
+		t.Fatalf("signed in as %+v, want SSO admin alice in the default team", resp.User)
+	}
+
+	var me model.User
+	s.do("GET", "/api/v1/auth/me", bearer(resp.Token), nil, http.StatusOK, &me)
+	if me.ID != resp.User.ID {
+		t.Fatalf("session belongs to %s, want %s", me.ID, resp.User.ID)
+	}
+
+	// The code can't be redeemed twice
+	s.do("GET", callback, withCookie(cookie), nil, http.StatusUnauthorized, nil)
+}
+
+func TestSSOBadState(t *testing.T) {
+	iss := newIssuer(t)
+	s := newServer(t)
+
+	callback, cookie := s.signIn(iss, alice)
+	state := strings.SplitN(cookie, ".", 2)[0]
+	other, _ := s.signIn(iss, alice)
+
+	// The callback of another sign-in, and one without the cookie
+	s.do("GET", other, withCookie(cookie), nil, http.StatusBadRequest, nil)
+	s.do("GET", callback, nil, nil, http.StatusBadRequest, nil)
+	s.do("GET", strings.Replace(callback, state, "forged", 1), withCookie(cookie), nil, http.StatusBadRequest, nil)
+}
+
+func TestSSONonceMismatch(t *testing.T) {
+	iss := newIssuer(t)
+	s := newServer(t)
+	iss.mu.Lock()
+	iss.nonce = "replayed"
+	iss.mu.Unlock()
+
+	callback, cookie := s.signIn(iss, alice)
+	s.do("GET", callback, withCookie(cookie), nil, http.StatusUnauthorized, nil)
+}
+
+func TestSSOVerifierMismatch(t *testing.T) {
+	iss := newIssuer(t)
+	s := newServer(t)
+
+	callback, cookie := s.signIn(iss, alice)
+	parts := strings.Split(cookie, ".")
+	parts[2] = strings.Repeat("x", len(parts[2]))
+	s.do("GET", callback, withCookie(strings.Join(parts, ".")), nil, http.StatusUnauthorized, nil)
+}
+
+func TestSSOUserWithoutMappedGroup(t *testing.T) {
+	iss := newIssuer(t)
+	s := newServer(t)
+
+	callback, cookie := s.signIn(iss, bob)
+	s.do("GET", callback, withCookie(cookie), nil, http.StatusForbidden, nil)
+
+	var users []model.User
+	s.do("GET", "/api/v1/users", bearer(adminToken), nil, http.StatusOK, &users)
+	if len(users) != 0 {
+		t.Fatalf("created users %+v for a user without a role", users)
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+type CreateTeamRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// GetTeams lists every team for the admin token, and only their own team
+// for API keys.
+func (h *Handler) GetTeams(c *gin.Context) {
+	teams, err := h.store.GetAllTeams(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, teams)
+}
+
+// CreateTeam is reserved to the admin token, since a team's own keys can't
+// see other teams.
+func (h *Handler) CreateTeam(c *gin.Context) {
+	if store.TeamID(c.Request.Context()) != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "teams can only be created with the admin token and no X-Team-ID"})
+		return
+	}
+
+	var req CreateTeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var team *model.Team
+	err := h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		if team, err = h.store.CreateTeam(ctx, req.Name); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, team.ID, "create", "team", team.ID, nil, team)
+	})
+	if errors.Is(err, store.ErrTeamExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, team)
+}
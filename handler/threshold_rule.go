@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+type CreateThresholdRuleRequest struct {
+	Metric      string   `json:"metric" binding:"required"`
+	Operator    string   `json:"operator" binding:"required,oneof=> >= < <= == !="`
+	Threshold   *float64 `json:"threshold" binding:"required"`
+	Consecutive int      `json:"consecutive"`
+}
+
+func (h *Handler) GetThresholdRules(c *gin.Context) {
+	rules, err := h.store.GetThresholdRules(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *Handler) CreateThresholdRule(c *gin.Context) {
+	id := c.Param("id")
+
+	var req CreateThresholdRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	monitor, err := h.store.GetMonitorByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+
+	if req.Consecutive <= 0 {
+		req.Consecutive = 1
+	}
+
+	var rule *model.ThresholdRule
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		rule, err = h.store.CreateThresholdRule(ctx, &model.ThresholdRule{
+			MonitorID:   id,
+			Metric:      req.Metric,
+			Operator:    req.Operator,
+			Threshold:   *req.Threshold,
+			Consecutive: req.Consecutive,
+		})
+		if err != nil {
+			return err
+		}
+		return h.audit(ctx, c, monitor.TeamID, "create", "threshold_rule", rule.ID, nil, rule)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *Handler) DeleteThresholdRule(c *gin.Context) {
+	id, ruleID := c.Param("id"), c.Param("rule_id")
+
+	monitor, err := h.store.GetMonitorByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "monitor not found"})
+		return
+	}
+	rules, err := h.store.GetThresholdRules(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	i := slices.IndexFunc(rules, func(r model.ThresholdRule) bool { return r.ID == ruleID })
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "threshold rule not found"})
+		return
+	}
+
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if err := h.store.DeleteThresholdRule(ctx, id, ruleID); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, monitor.TeamID, "delete", "threshold_rule", ruleID, rules[i], nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
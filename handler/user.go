@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/account"
+	"github.com/mohsen/alertinGo/middleware"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role"`
+}
+
+type UpdateUserRequest struct {
+	Role     *string `json:"role"`
+	IsActive *bool   `json:"is_active"`
+	Password *string `json:"password"`
+}
+
+func (h *Handler) GetUsers(c *gin.Context) {
+	users, err := h.store.GetAllUsers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func (h *Handler) CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := account.ParseRole(req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user *model.User
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		var err error
+		if user, err = account.Create(ctx, h.store, model.User{Username: req.Username, Role: role}, req.Password); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, user.TeamID, "create", "user", user.ID, nil, user)
+	})
+	if errors.Is(err, store.ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, account.ErrBadPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser changes a user's role, (de)activates it or resets its password.
+// Deactivating a user or resetting its password ends its sessions. Fields
+// left out of the request keep their value. The role of an SSO user is
+// replaced on its next sign-in.
+func (h *Handler) UpdateUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := h.store.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	role := existing.Role
+	if req.Role != nil {
+		if role, err = account.ParseRole(*req.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	isActive := existing.IsActive
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	// Admins could otherwise lock themselves out
+	if me := middleware.User(c); me != nil && me.ID == id && (role != existing.Role || !isActive) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you can't change your own role or deactivate yourself"})
+		return
+	}
+
+	var hash string
+	if req.Password != nil {
+		if existing.SSO {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "users signing in with SSO have no password here"})
+			return
+		}
+		if hash, err = account.HashPassword(*req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var user *model.User
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if hash != "" {
+			if err := h.store.SetUserPassword(ctx, id, hash); err != nil {
+				return err
+			}
+			if err := h.audit(ctx, c, existing.TeamID, "reset_password", "user", id, nil, nil); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if user, err = h.store.UpdateUser(ctx, id, role, isActive); err != nil {
+			return err
+		}
+		if role == existing.Role && isActive == existing.IsActive {
+			return nil
+		}
+		return h.audit(ctx, c, user.TeamID, "update", "user", id, existing, user)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *Handler) DeleteUser(c *gin.Context) {
+	id := c.Param("id")
+
+	if me := middleware.User(c); me != nil && me.ID == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you can't delete yourself"})
+		return
+	}
+
+	existing, err := h.store.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	err = h.store.InTx(c.Request.Context(), func(ctx context.Context) error {
+		if err := h.store.DeleteUser(ctx, id); err != nil {
+			return err
+		}
+		return h.audit(ctx, c, existing.TeamID, "delete", "user", id, existing, nil)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "user deleted"})
+}
@@ -0,0 +1,133 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WatcherLockID is the advisory lock key guarding the watcher loop.
+const WatcherLockID int64 = 0x616c657274696e67 // "alerting"
+
+// Elector campaigns for leadership by holding a session-level Postgres advisory
+// lock on a dedicated connection. Only one session can hold the lock, and
+// Postgres releases it when that session ends, so a crashed leader is replaced
+// by the next instance that polls for it.
+//
+// The leader keeps reading from its connection while it leads, so it steps
+// down as soon as the connection breaks instead of at the next poll, and pings
+// it every interval to notice a connection that silently stopped answering.
+type Elector struct {
+	config   *pgx.ConnConfig
+	name     string
+	lockID   int64
+	interval time.Duration
+	conn     *pgx.Conn
+	leading  atomic.Bool
+}
+
+// New returns an elector connecting with the pool's configuration. The lock
+// connection is its own, so it never goes back to the pool with the lock held.
+func New(pool *pgxpool.Pool, name string, lockID int64) *Elector {
+	return &Elector{config: pool.Config().ConnConfig.Copy(), name: name, lockID: lockID, interval: 5 * time.Second}
+}
+
+// IsLeader reports whether this instance currently holds the lock.
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run campaigns until ctx is cancelled, then gives up leadership.
+func (e *Elector) Run(ctx context.Context) {
+	defer e.closeConn()
+
+	for {
+		if e.campaign(ctx) {
+			e.lead(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// campaign tries to take the lock once and reports whether it did.
+func (e *Elector) campaign(ctx context.Context) bool {
+	if e.conn == nil {
+		conn, err := pgx.ConnectConfig(ctx, e.config)
+		if err != nil {
+			log.Printf("[leader] %s: error connecting: %v", e.name, err)
+			return false
+		}
+		e.conn = conn
+	}
+
+	var acquired bool
+	if err := e.conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, e.lockID).Scan(&acquired); err != nil {
+		log.Printf("[leader] %s: error acquiring lock: %v", e.name, err)
+		e.closeConn()
+		return false
+	}
+	return acquired
+}
+
+// lead holds leadership until ctx is cancelled or the lock connection fails,
+// then closes the connection, which releases the lock.
+func (e *Elector) lead(ctx context.Context) {
+	e.leading.Store(true)
+	log.Printf("[leader] %s: became leader", e.name)
+
+	err := e.hold(ctx)
+	e.leading.Store(false)
+	e.closeConn()
+	if ctx.Err() != nil {
+		log.Printf("[leader] %s: resigned", e.name)
+		return
+	}
+	log.Printf("[leader] %s: lost leadership: %v", e.name, err)
+}
+
+// hold waits on the lock connection, which only returns early when the
+// connection breaks, and pings it every interval.
+func (e *Elector) hold(ctx context.Context) error {
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, e.interval)
+		err := e.conn.PgConn().WaitForNotification(waitCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, e.interval)
+		err = e.conn.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// closeConn ends the lock session, releasing the lock if it is held.
+func (e *Elector) closeConn() {
+	if e.conn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e.conn.Close(ctx)
+	e.conn = nil
+}
@@ -0,0 +1,93 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testLockID keeps the tests clear of a server running against the same database.
+const testLockID int64 = 0x6c65616465727465 // "leaderte"
+
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func startElector(t *testing.T, pool *pgxpool.Pool, name string, interval time.Duration) (*Elector, context.CancelFunc) {
+	t.Helper()
+	e := New(pool, name, testLockID)
+	e.interval = interval
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(ctx)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return e, stop
+}
+
+func waitFor(t *testing.T, within time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOneLeaderAtATime(t *testing.T) {
+	pool := testPool(t)
+	a, stopA := startElector(t, pool, "a", 100*time.Millisecond)
+	b, stopB := startElector(t, pool, "b", 100*time.Millisecond)
+
+	waitFor(t, 5*time.Second, "a leader", func() bool { return a.IsLeader() || b.IsLeader() })
+	for range 10 {
+		if a.IsLeader() && b.IsLeader() {
+			t.Fatal("both electors lead")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	leader, follower, stopLeader := a, b, stopA
+	if b.IsLeader() {
+		leader, follower, stopLeader = b, a, stopB
+	}
+	stopLeader()
+	if leader.IsLeader() {
+		t.Fatal("resigned elector still leads")
+	}
+	waitFor(t, 5*time.Second, "the follower to take over", follower.IsLeader)
+}
+
+func TestStepsDownWhenLockConnectionBreaks(t *testing.T) {
+	pool := testPool(t)
+	// A long interval shows the leader doesn't wait for its next poll
+	e, _ := startElector(t, pool, "a", time.Minute)
+	waitFor(t, 5*time.Second, "leadership", e.IsLeader)
+
+	pid := e.conn.PgConn().PID()
+	if _, err := pool.Exec(context.Background(), `SELECT pg_terminate_backend($1)`, pid); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the leader to step down", func() bool { return !e.IsLeader() })
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// RequireAdmin protects the management API. It accepts the ADMIN_TOKEN or a
+// session token of a user whose role allows scope as a bearer token, or an
+// X-API-Key carrying scope. With no ADMIN_TOKEN configured only sessions and
+// API keys are accepted. Users and keys only manage their own team; the admin
+// token manages every team, or the one in the X-Team-ID header.
+func (a *Auth) RequireAdmin(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			if a.checkAPIKey(c, key, scope) {
+				c.Next()
+			}
+			return
+		}
+
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || got == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing Authorization: Bearer or X-API-Key header"})
+			return
+		}
+
+		// Compare hashes so the comparison takes the same time for any length
+		gotHash := sha256.Sum256([]byte(got))
+		if a.adminToken == "" || subtle.ConstantTimeCompare(gotHash[:], a.adminHash[:]) != 1 {
+			if a.checkSession(c, got, scope) {
+				c.Next()
+			}
+			return
+		}
+
+		ctx := store.Unscoped(c.Request.Context())
+		if teamID := c.GetHeader("X-Team-ID"); teamID != "" {
+			if _, err := a.teams.GetTeamByID(ctx, teamID); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown X-Team-ID"})
+				return
+			}
+			ctx = store.WithTeam(c.Request.Context(), teamID)
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// checkSession validates a session token for scope, aborting the request if
+// it fails. The request is scoped to the user's team.
+func (a *Auth) checkSession(c *gin.Context, token, scope string) bool {
+	u, err := a.users.GetSessionUser(store.Unscoped(c.Request.Context()), apikey.Hash(token))
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token or session"})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to validate session"})
+		return false
+	}
+	if !u.HasScope(scope) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "the " + u.Role + " role lacks the " + scope + " scope"})
+		return false
+	}
+
+	c.Set(userContextKey, u)
+	c.Request = c.Request.WithContext(store.WithTeam(c.Request.Context(), u.TeamID))
+	return true
+}
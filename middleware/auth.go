@@ -1,31 +1,151 @@
 package middleware
 
 import (
+	"context"
 	"crypto/sha256"
-	"encoding/hex"
+	"errors"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
 )
 
-func RequireAPIKey() gin.HandlerFunc {
+// Auth authenticates requests with API keys, user sessions or the admin
+// token, and keeps track of when each key was last used. Validated keys are cached for
+// API_KEY_CACHE_TTL seconds (default 60, 0 disables the cache).
+type Auth struct {
+	keys       store.ApiKeys
+	teams      store.Teams
+	users      store.Users
+	clock      clock.Clock
+	adminToken string
+	adminHash  [sha256.Size]byte
+	cache      *keyCache
+
+	mu         sync.Mutex
+	uses       map[string]store.ApiKeyUse
+	cancelLoop func()
+	loopDone   chan struct{}
+}
+
+func NewAuth(st store.Store, adminToken string, clk clock.Clock) *Auth {
+	a := &Auth{
+		keys:       st,
+		teams:      st,
+		users:      st,
+		clock:      clk,
+		adminToken: adminToken,
+		adminHash:  sha256.Sum256([]byte(adminToken)),
+		uses:       map[string]store.ApiKeyUse{},
+	}
+
+	ttl := 60 * time.Second
+	if v := os.Getenv("API_KEY_CACHE_TTL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("API_KEY_CACHE_TTL must be a non-negative number of seconds, got: %s", v)
+		}
+		ttl = time.Duration(n) * time.Second
+	}
+	if ttl > 0 {
+		a.cache = newKeyCache(ttl)
+	}
+	return a
+}
+
+// RequireAPIKey lets through requests with an active X-API-Key carrying scope.
+func (a *Auth) RequireAPIKey(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		key := c.GetHeader("X-API-Key")
 		if key == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing X-API-Key header"})
 			return
 		}
+		if a.checkAPIKey(c, key, scope) {
+			c.Next()
+		}
+	}
+}
 
-		hash := sha256.Sum256([]byte(key))
-		keyHash := hex.EncodeToString(hash[:])
+const (
+	apiKeyContextKey = "api_key"
+	userContextKey   = "user"
+)
 
-		valid, err := db.ValidateApiKey(c.Request.Context(), keyHash)
-		if err != nil || !valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or revoked API key"})
-			return
-		}
+// APIKey returns the API key that authenticated the request, or nil.
+func APIKey(c *gin.Context) *model.ApiKey {
+	k, _ := c.Get(apiKeyContextKey)
+	key, _ := k.(*model.ApiKey)
+	return key
+}
+
+// User returns the user whose session authenticated the request, or nil.
+func User(c *gin.Context) *model.User {
+	u, _ := c.Get(userContextKey)
+	user, _ := u.(*model.User)
+	return user
+}
+
+// Actor names who made the request, for attributing actions such as
+// acknowledging an alert: "user:<username>", "api_key:<name>" or "admin".
+func Actor(c *gin.Context) string {
+	if u := User(c); u != nil {
+		return "user:" + u.Username
+	}
+	if k := APIKey(c); k != nil {
+		return "api_key:" + k.Name
+	}
+	return "admin"
+}
+
+// checkAPIKey validates key for scope, aborting the request if it fails. The
+// request is scoped to the key's team.
+func (a *Auth) checkAPIKey(c *gin.Context, key, scope string) bool {
+	// The key is found in whichever team owns it, and then decides the request's team
+	k, err := a.lookupKey(store.Unscoped(c.Request.Context()), apikey.Hash(key))
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid, expired or revoked API key"})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to validate API key"})
+		return false
+	}
+	if !k.HasScope(scope) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+		return false
+	}
+
+	// ClientIP only believes X-Forwarded-For from the TRUSTED_PROXIES
+	a.recordUse(k.ID, c.ClientIP())
+	c.Set(apiKeyContextKey, k)
+	c.Request = c.Request.WithContext(store.WithTeam(c.Request.Context(), k.TeamID))
+	return true
+}
+
+// lookupKey returns the active key with hash, from the cache if possible.
+func (a *Auth) lookupKey(ctx context.Context, hash string) (*model.ApiKey, error) {
+	if a.cache == nil {
+		return a.keys.GetActiveApiKey(ctx, hash)
+	}
 
-		c.Next()
+	now := a.clock.Now()
+	k, gen, ok := a.cache.get(hash, now)
+	if ok {
+		return k, nil
+	}
+	k, err := a.keys.GetActiveApiKey(ctx, hash)
+	if err != nil {
+		return nil, err
 	}
+	a.cache.put(hash, k, now, gen)
+	return k, nil
 }
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mohsen/alertinGo/apikey"
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/memory"
+)
+
+// countingStore counts key lookups, and holds back the key change listener
+// until listening is closed.
+type countingStore struct {
+	*memory.Store
+	lookups   atomic.Int32
+	listening chan struct{}
+}
+
+func (s *countingStore) GetActiveApiKey(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	s.lookups.Add(1)
+	return s.Store.GetActiveApiKey(ctx, keyHash)
+}
+
+func (s *countingStore) ListenApiKeyChanges(ctx context.Context, ready func(), fn func(keyID string)) error {
+	select {
+	case <-s.listening:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.Store.ListenApiKeyChanges(ctx, ready, fn)
+}
+
+func newKey(t testing.TB, st store.Store) (string, *model.ApiKey) {
+	t.Helper()
+	plain, k, err := apikey.Create(store.Unscoped(context.Background()), st, model.ApiKey{Name: "test", Scopes: []string{model.ScopeIngest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain, k
+}
+
+func (kc *keyCache) isLive() bool {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	return kc.live
+}
+
+func TestKeyCacheFollowsChanges(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	st := &countingStore{Store: memory.New(clk), listening: make(chan struct{})}
+	ctx := store.Unscoped(context.Background())
+	plain, k := newKey(t, st)
+	hash := apikey.Hash(plain)
+
+	a := NewAuth(st, "", clk)
+	a.Start(ctx)
+	defer a.Stop()
+
+	lookup := func() error {
+		_, err := a.lookupKey(ctx, hash)
+		return err
+	}
+
+	// Not cached until changes are listened to
+	lookup()
+	lookup()
+	if n := st.lookups.Load(); n != 2 {
+		t.Fatalf("looked up %d times before listening, want 2", n)
+	}
+
+	close(st.listening)
+	for !a.cache.isLive() {
+		time.Sleep(time.Millisecond)
+	}
+	lookup()
+	lookup()
+	if n := st.lookups.Load(); n != 3 {
+		t.Fatalf("looked up %d times while listening, want 3", n)
+	}
+
+	if err := st.RevokeApiKey(ctx, k.ID); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for lookup() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("revoked key is still cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A key read before an invalidation, which may be for that very key, is not
+// cached.
+func TestKeyCacheSkipsStaleReads(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	kc := newKeyCache(time.Minute)
+	kc.setLive(true)
+	k := &model.ApiKey{ID: "k1"}
+
+	_, gen, _ := kc.get("hash", now)
+	kc.invalidate("k1")
+	kc.put("hash", k, now, gen)
+	if _, _, ok := kc.get("hash", now); ok {
+		t.Fatal("cached a key read before it was invalidated")
+	}
+
+	_, gen, _ = kc.get("hash", now)
+	kc.put("hash", k, now, gen)
+	if _, _, ok := kc.get("hash", now); !ok {
+		t.Fatal("did not cache a key")
+	}
+}
+
+// BenchmarkAuth compares key lookups with and without the cache on the
+// stores that pay a query for each, SQLite and, if DATABASE_URL is set,
+// Postgres.
+func BenchmarkAuth(b *testing.B) {
+	stores := []struct{ name, dsn string }{
+		{"SQLite", "sqlite://" + filepath.Join(b.TempDir(), "alertingo.db")},
+		{"Postgres", os.Getenv("DATABASE_URL")},
+	}
+	for _, sc := range stores {
+		b.Run(sc.name, func(b *testing.B) {
+			if sc.dsn == "" {
+				b.Skip("DATABASE_URL is not set")
+			}
+			st := openStore(b, sc.dsn)
+			plain, k := newKey(b, st)
+			b.Cleanup(func() { st.DeleteApiKey(store.Unscoped(context.Background()), k.ID) })
+
+			for _, bc := range []struct{ name, ttl string }{{"Cache", "60"}, {"NoCache", "0"}} {
+				b.Run(bc.name, func(b *testing.B) {
+					b.Setenv("API_KEY_CACHE_TTL", bc.ttl)
+					benchmarkAuth(b, st, plain)
+				})
+			}
+		})
+	}
+}
+
+func openStore(b *testing.B, dsn string) store.Store {
+	b.Helper()
+	ctx := context.Background()
+	st, err := db.Open(ctx, dsn, clock.Real{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(st.Close)
+	if err := st.Migrate(ctx); err != nil {
+		b.Fatal(err)
+	}
+	return st
+}
+
+func benchmarkAuth(b *testing.B, st store.Store, plain string) {
+	gin.SetMode(gin.TestMode)
+	a := NewAuth(st, "", clock.Real{})
+	a.Start(context.Background())
+	defer a.Stop()
+	for a.cache != nil && !a.cache.isLive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	r := gin.New()
+	r.GET("/", a.RequireAPIKey(model.ScopeIngest), func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", plain)
+
+	for b.Loop() {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status %d", rec.Code)
+		}
+	}
+}
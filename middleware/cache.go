@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mohsen/alertinGo/model"
+)
+
+// keyCacheSize bounds the number of cached keys. Only keys that validated are
+// cached, so unknown keys can't fill it up.
+const keyCacheSize = 10000
+
+// keyCache remembers validated API keys by hash for up to ttl, so a busy
+// client doesn't cost a lookup per request. Entries are dropped as soon as the
+// store announces the key changed. While that feed is down the cache is not
+// used, since it would miss revocations made by other instances.
+type keyCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	live    bool
+	entries map[string]cacheEntry // by key hash
+	hashes  map[string]string     // key ID to hash
+	// gen counts invalidations. A key read from the store is only cached if
+	// no invalidation happened since, which could be for the key just read.
+	gen uint64
+}
+
+type cacheEntry struct {
+	key     *model.ApiKey
+	expires time.Time
+}
+
+func newKeyCache(ttl time.Duration) *keyCache {
+	return &keyCache{
+		ttl:     ttl,
+		entries: map[string]cacheEntry{},
+		hashes:  map[string]string{},
+	}
+}
+
+// get returns a cached key. On a miss it returns the generation to pass to
+// put along with the key read from the store.
+func (kc *keyCache) get(hash string, now time.Time) (*model.ApiKey, uint64, bool) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	e, ok := kc.entries[hash]
+	if !kc.live || !ok || !now.Before(e.expires) {
+		return nil, kc.gen, false
+	}
+	// Handlers get their own copy
+	k := *e.key
+	return &k, kc.gen, true
+}
+
+// put caches a key read from the store, unless the cache was invalidated
+// since get returned gen.
+func (kc *keyCache) put(hash string, k *model.ApiKey, now time.Time, gen uint64) {
+	expires := now.Add(kc.ttl)
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(expires) {
+		expires = *k.ExpiresAt
+	}
+	cached := *k
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if !kc.live || kc.gen != gen {
+		return
+	}
+	if _, ok := kc.entries[hash]; !ok && len(kc.entries) >= keyCacheSize {
+		kc.evict(now)
+	}
+	kc.entries[hash] = cacheEntry{key: &cached, expires: expires}
+	kc.hashes[k.ID] = hash
+}
+
+// evict drops expired entries, or an arbitrary one if none have expired.
+func (kc *keyCache) evict(now time.Time) {
+	for hash, e := range kc.entries {
+		if !now.Before(e.expires) {
+			kc.remove(hash, e.key.ID)
+		}
+	}
+	if len(kc.entries) < keyCacheSize {
+		return
+	}
+	for hash, e := range kc.entries {
+		kc.remove(hash, e.key.ID)
+		return
+	}
+}
+
+func (kc *keyCache) remove(hash, keyID string) {
+	delete(kc.entries, hash)
+	delete(kc.hashes, keyID)
+}
+
+func (kc *keyCache) invalidate(keyID string) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.gen++
+	if hash, ok := kc.hashes[keyID]; ok {
+		kc.remove(hash, keyID)
+	}
+}
+
+// setLive turns the cache on or off. Turning it off empties it.
+func (kc *keyCache) setLive(live bool) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.live = live
+	kc.gen++
+	if !live {
+		clear(kc.entries)
+		clear(kc.hashes)
+	}
+}
+
+// listenKeyChanges invalidates cached keys as the store announces changes,
+// reconnecting until ctx is cancelled.
+func (a *Auth) listenKeyChanges(ctx context.Context) {
+	for {
+		// Only use the cache once changes are followed
+		err := a.keys.ListenApiKeyChanges(ctx, func() { a.cache.setLive(true) }, a.cache.invalidate)
+		a.cache.setLive(false)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("[auth] API key change listener stopped, reconnecting in 5s: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
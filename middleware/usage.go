@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mohsen/alertinGo/store"
+)
+
+// usageFlushInterval is how often last_used_at/last_used_ip are written. Keys
+// used many times in between cost a single update.
+const usageFlushInterval = 30 * time.Second
+
+func (a *Auth) recordUse(keyID, ip string) {
+	a.mu.Lock()
+	a.uses[keyID] = store.ApiKeyUse{KeyID: keyID, At: a.clock.Now(), IP: ip}
+	a.mu.Unlock()
+}
+
+// Start writes recorded key usage every usageFlushInterval until Stop, and
+// keeps the key cache in sync with the store.
+func (a *Auth) Start(ctx context.Context) {
+	ctx, a.cancelLoop = context.WithCancel(store.Unscoped(ctx))
+	a.loopDone = make(chan struct{})
+
+	var loops sync.WaitGroup
+	if a.cache != nil {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			a.listenKeyChanges(ctx)
+		}()
+	}
+
+	loops.Add(1)
+	go func() {
+		defer loops.Done()
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.flushUses(ctx)
+			}
+		}
+	}()
+
+	go func() {
+		loops.Wait()
+		close(a.loopDone)
+	}()
+}
+
+// Stop ends the loops and writes usage recorded since the last flush.
+// Call it once no more requests are being served.
+func (a *Auth) Stop() {
+	a.cancelLoop()
+	<-a.loopDone
+	a.flushUses(store.Unscoped(context.Background()))
+}
+
+func (a *Auth) flushUses(ctx context.Context) {
+	a.mu.Lock()
+	uses := a.uses
+	a.uses = map[string]store.ApiKeyUse{}
+	a.mu.Unlock()
+
+	if len(uses) == 0 {
+		return
+	}
+	if err := a.keys.RecordApiKeyUses(ctx, slices.Collect(maps.Values(uses))); err != nil {
+		log.Printf("[auth] error recording usage of %d API keys: %v", len(uses), err)
+	}
+}
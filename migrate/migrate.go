@@ -0,0 +1,172 @@
+// Package migrate applies versioned schema migrations, recording each applied
+// version in a schema_migrations table. The SQL files and the way they are run
+// are provided per database by a Driver.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// String returns the file name stem, e.g. "003_threshold_rules".
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Driver runs migrations against one database.
+type Driver interface {
+	// Lock makes sure only one process migrates at a time and creates the
+	// schema_migrations table if needed. unlock must be called when done.
+	Lock(ctx context.Context) (unlock func(), err error)
+	// Applied returns when each recorded version was applied.
+	Applied(ctx context.Context) (map[int]time.Time, error)
+	// Apply runs the up or down SQL of m and records or removes its version,
+	// all in one transaction.
+	Apply(ctx context.Context, m Migration, up bool) error
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads NNN_name.up.sql and NNN_name.down.sql pairs from fsys, sorted by
+// version. Every version needs both files.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		match := fileName.FindStringSubmatch(file)
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: expected NNN_name.up.sql or NNN_name.down.sql", file)
+		}
+		version, _ := strconv.Atoi(match[1])
+		sql, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %s: version %d is also named %s", file, version, m.Name)
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s: needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration in order, stopping at the first error.
+func Up(ctx context.Context, d Driver, migrations []Migration) error {
+	unlock, err := d.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := d.Applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := d.Apply(ctx, m, true); err != nil {
+			return fmt.Errorf("migration %s: %w", m, err)
+		}
+		log.Printf("migration applied: %s", m)
+		pending++
+	}
+	if pending == 0 {
+		log.Println("database schema is up to date")
+	}
+	return nil
+}
+
+// Down reverts the latest steps applied migrations.
+func Down(ctx context.Context, d Driver, migrations []Migration, steps int) error {
+	unlock, err := d.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := d.Applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := d.Apply(ctx, m, false); err != nil {
+			return fmt.Errorf("reverting migration %s: %w", m, err)
+		}
+		log.Printf("migration reverted: %s", m)
+		steps--
+	}
+	return nil
+}
+
+// GetStatus lists every known migration and when it was applied, if at all.
+func GetStatus(ctx context.Context, d Driver, migrations []Migration) ([]Status, error) {
+	unlock, err := d.Lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := d.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]Status, len(migrations))
+	for i, m := range migrations {
+		status[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,4 @@
+DROP TABLE notification_logs;
+DROP TABLE alert_states;
+DROP TABLE monitors;
+DROP TABLE notification_channels;
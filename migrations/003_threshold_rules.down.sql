@@ -0,0 +1,4 @@
+DELETE FROM alert_states WHERE type = 'threshold';
+ALTER TABLE alert_states DROP COLUMN rule_id;
+ALTER TABLE alert_states DROP COLUMN type;
+DROP TABLE threshold_rules;
@@ -0,0 +1,13 @@
+CREATE TABLE threshold_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    monitor_id UUID NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    metric TEXT NOT NULL,
+    operator TEXT NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    consecutive INTEGER NOT NULL DEFAULT 1,
+    breach_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE alert_states ADD COLUMN type TEXT NOT NULL DEFAULT 'heartbeat';
+ALTER TABLE alert_states ADD COLUMN rule_id UUID REFERENCES threshold_rules(id) ON DELETE CASCADE;
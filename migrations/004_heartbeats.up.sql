@@ -0,0 +1,10 @@
+CREATE TABLE heartbeats (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    monitor_id UUID NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    message TEXT NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    source_ip TEXT NOT NULL DEFAULT '',
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX heartbeats_monitor_received_at_idx ON heartbeats (monitor_id, received_at DESC);
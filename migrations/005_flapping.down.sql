@@ -0,0 +1,2 @@
+ALTER TABLE monitors DROP COLUMN flapping_since;
+ALTER TABLE monitors DROP COLUMN is_flapping;
@@ -0,0 +1,2 @@
+ALTER TABLE monitors ADD COLUMN is_flapping BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE monitors ADD COLUMN flapping_since TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+ALTER TABLE monitors DROP COLUMN recovery_heartbeats;
+ALTER TABLE monitors DROP COLUMN recover_after_heartbeats;
+ALTER TABLE monitors DROP COLUMN alert_after_misses;
@@ -0,0 +1,3 @@
+ALTER TABLE monitors ADD COLUMN alert_after_misses INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE monitors ADD COLUMN recover_after_heartbeats INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE monitors ADD COLUMN recovery_heartbeats INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE alert_states DROP COLUMN suppressed;
+DROP TABLE monitor_dependencies;
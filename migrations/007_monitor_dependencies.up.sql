@@ -0,0 +1,11 @@
+CREATE TABLE monitor_dependencies (
+    monitor_id UUID NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    parent_id UUID NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (monitor_id, parent_id),
+    CHECK (monitor_id <> parent_id)
+);
+
+CREATE INDEX monitor_dependencies_parent_id_idx ON monitor_dependencies (parent_id);
+
+ALTER TABLE alert_states ADD COLUMN suppressed BOOLEAN NOT NULL DEFAULT false;
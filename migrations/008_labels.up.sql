@@ -0,0 +1 @@
+ALTER TABLE monitors ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
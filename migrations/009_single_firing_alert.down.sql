@@ -0,0 +1,2 @@
+DROP INDEX alert_states_one_firing_threshold_idx;
+DROP INDEX alert_states_one_firing_heartbeat_idx;
@@ -0,0 +1,17 @@
+-- Resolve duplicate firing alerts left behind by earlier races, keeping the oldest
+UPDATE alert_states a SET status = 'resolved', resolved_at = now()
+WHERE a.status = 'firing' AND a.type = 'heartbeat' AND EXISTS (
+    SELECT 1 FROM alert_states b
+    WHERE b.monitor_id = a.monitor_id AND b.type = 'heartbeat' AND b.status = 'firing'
+      AND (b.fired_at, b.id) < (a.fired_at, a.id)
+);
+
+UPDATE alert_states a SET status = 'resolved', resolved_at = now()
+WHERE a.status = 'firing' AND a.type = 'threshold' AND EXISTS (
+    SELECT 1 FROM alert_states b
+    WHERE b.rule_id = a.rule_id AND b.type = 'threshold' AND b.status = 'firing'
+      AND (b.fired_at, b.id) < (a.fired_at, a.id)
+);
+
+CREATE UNIQUE INDEX alert_states_one_firing_heartbeat_idx ON alert_states (monitor_id) WHERE status = 'firing' AND type = 'heartbeat';
+CREATE UNIQUE INDEX alert_states_one_firing_threshold_idx ON alert_states (rule_id) WHERE status = 'firing' AND type = 'threshold';
@@ -0,0 +1,2 @@
+DROP TRIGGER monitors_recovered_notify ON monitors;
+DROP FUNCTION notify_monitor_recovered();
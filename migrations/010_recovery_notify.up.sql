@@ -0,0 +1,12 @@
+CREATE OR REPLACE FUNCTION notify_monitor_recovered() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('monitor_recovered', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER monitors_recovered_notify
+    AFTER UPDATE OF status ON monitors
+    FOR EACH ROW
+    WHEN (OLD.status = 'down' AND NEW.status = 'up')
+    EXECUTE FUNCTION notify_monitor_recovered();
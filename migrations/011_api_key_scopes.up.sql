@@ -0,0 +1,2 @@
+-- Keys created before scopes only ever authenticated heartbeats
+ALTER TABLE api_keys ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{ingest}';
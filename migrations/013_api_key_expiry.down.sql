@@ -0,0 +1 @@
+ALTER TABLE api_keys DROP COLUMN last_used_ip, DROP COLUMN last_used_at, DROP COLUMN expires_at;
@@ -0,0 +1,4 @@
+ALTER TABLE api_keys
+    ADD COLUMN expires_at TIMESTAMPTZ,
+    ADD COLUMN last_used_at TIMESTAMPTZ,
+    ADD COLUMN last_used_ip TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TRIGGER api_keys_changed_notify ON api_keys;
+DROP FUNCTION notify_api_key_changed();
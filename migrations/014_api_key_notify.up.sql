@@ -0,0 +1,11 @@
+CREATE OR REPLACE FUNCTION notify_api_key_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('api_key_changed', OLD.id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER api_keys_changed_notify
+    AFTER UPDATE OF name, scopes, monitor_pattern, check_type_pattern, is_active, expires_at OR DELETE ON api_keys
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_api_key_changed();
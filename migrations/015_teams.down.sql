@@ -0,0 +1,8 @@
+-- Fails if two teams have a monitor with the same name and check type
+
+ALTER TABLE api_keys DROP COLUMN team_id;
+ALTER TABLE notification_channels DROP COLUMN team_id;
+ALTER TABLE monitors DROP COLUMN team_id;
+ALTER TABLE monitors ADD CONSTRAINT monitors_monitor_name_check_type_key UNIQUE (monitor_name, check_type);
+
+DROP TABLE teams;
@@ -0,0 +1,19 @@
+CREATE TABLE teams (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Everything created before teams existed belongs to the default team
+INSERT INTO teams (id, name) VALUES ('00000000-0000-0000-0000-000000000000', 'default');
+
+ALTER TABLE monitors ADD COLUMN team_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES teams(id);
+ALTER TABLE notification_channels ADD COLUMN team_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES teams(id);
+ALTER TABLE api_keys ADD COLUMN team_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES teams(id);
+
+-- Monitor names only need to be unique within a team
+ALTER TABLE monitors DROP CONSTRAINT monitors_monitor_name_check_type_key;
+ALTER TABLE monitors ADD CONSTRAINT monitors_team_id_monitor_name_check_type_key UNIQUE (team_id, monitor_name, check_type);
+
+CREATE INDEX notification_channels_team_id_idx ON notification_channels (team_id);
+CREATE INDEX api_keys_team_id_idx ON api_keys (team_id);
@@ -0,0 +1,5 @@
+ALTER TABLE monitors DROP COLUMN silenced_by, DROP COLUMN silenced_until;
+ALTER TABLE alert_states DROP COLUMN acknowledged_by, DROP COLUMN acknowledged_at;
+
+DROP TABLE sessions;
+DROP TABLE users;
@@ -0,0 +1,30 @@
+CREATE TABLE users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    team_id UUID NOT NULL REFERENCES teams(id),
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'responder', 'admin')),
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX users_team_id_idx ON users (team_id);
+
+-- Like API keys, only the SHA-256 of a session token is stored
+CREATE TABLE sessions (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
+
+ALTER TABLE alert_states
+    ADD COLUMN acknowledged_at TIMESTAMPTZ,
+    ADD COLUMN acknowledged_by TEXT;
+
+ALTER TABLE monitors
+    ADD COLUMN silenced_until TIMESTAMPTZ,
+    ADD COLUMN silenced_by TEXT;
@@ -0,0 +1,15 @@
+-- target_id has no foreign key, deleted targets keep their history
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    team_id UUID NOT NULL REFERENCES teams(id),
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    before_state JSONB,
+    after_state JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_events_team_created_at_idx ON audit_events (team_id, created_at DESC);
+CREATE INDEX audit_events_target_idx ON audit_events (target_type, target_id);
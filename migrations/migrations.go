@@ -0,0 +1,9 @@
+// Package migrations embeds the Postgres schema migrations, so the binary
+// does not depend on its working directory. Each version has a
+// NNN_name.up.sql file and a NNN_name.down.sql file reverting it.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -1,56 +1,221 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Team is a tenant. Monitors, channels and API keys belong to exactly one
+// team, and API keys only ever see their own team's data.
+type Team struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DefaultTeamID is the team owning everything created before teams existed,
+// and everything created without choosing a team.
+const DefaultTeamID = "00000000-0000-0000-0000-000000000000"
 
 type NotificationChannel struct {
 	ID             string    `json:"id"`
+	TeamID         string    `json:"team_id"`
 	Name           string    `json:"name"`
 	TelegramChatID string    `json:"telegram_chat_id"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
 type Monitor struct {
-	ID              string    `json:"id"`
-	MonitorName     string    `json:"monitor_name"`
-	CheckType       string    `json:"check_type"`
-	Message         string    `json:"message"`
-	Metadata        string    `json:"metadata"`
-	Timeout         int       `json:"timeout"`
-	ReAlertInterval int       `json:"re_alert_interval"`
-	Status          string    `json:"status"`
-	IsActive        bool      `json:"is_active"`
-	ChannelID       *string   `json:"channel_id"`
-	ServerIP        string    `json:"server_ip"`
-	ServerName      string    `json:"server_name"`
-	LastSeenAt      time.Time `json:"last_seen_at"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID                     string            `json:"id"`
+	TeamID                 string            `json:"team_id"`
+	MonitorName            string            `json:"monitor_name"`
+	CheckType              string            `json:"check_type"`
+	Message                string            `json:"message"`
+	Metadata               string            `json:"metadata"`
+	Timeout                int               `json:"timeout"`
+	ReAlertInterval        int               `json:"re_alert_interval"`
+	AlertAfterMisses       int               `json:"alert_after_misses"`
+	RecoverAfterHeartbeats int               `json:"recover_after_heartbeats"`
+	RecoveryHeartbeats     int               `json:"-"`
+	Status                 string            `json:"status"`
+	IsActive               bool              `json:"is_active"`
+	ChannelID              *string           `json:"channel_id"`
+	ServerIP               string            `json:"server_ip"`
+	ServerName             string            `json:"server_name"`
+	Labels                 map[string]string `json:"labels"`
+	LastSeenAt             time.Time         `json:"last_seen_at"`
+	IsFlapping             bool              `json:"is_flapping"`
+	FlappingSince          *time.Time        `json:"flapping_since,omitempty"`
+	CreatedAt              time.Time         `json:"created_at"`
+	UpdatedAt              time.Time         `json:"updated_at"`
+	CreatedByKeyID         *string           `json:"created_by_key_id"` // API key that sent the first heartbeat
+	// No notifications are sent for the monitor until SilencedUntil
+	SilencedUntil *time.Time `json:"silenced_until"`
+	SilencedBy    *string    `json:"silenced_by"`
+}
+
+// Silenced reports whether the monitor's notifications are silenced at now.
+func (m Monitor) Silenced(now time.Time) bool {
+	return m.SilencedUntil != nil && m.SilencedUntil.After(now)
 }
 
 type AlertState struct {
 	ID            string     `json:"id"`
 	MonitorID     string     `json:"monitor_id"`
+	Type          string     `json:"type"` // "heartbeat", "threshold"
+	RuleID        *string    `json:"rule_id,omitempty"`
 	Status        string     `json:"status"`
+	Suppressed    bool       `json:"suppressed"`
 	LastAlertedAt time.Time  `json:"last_alerted_at"`
 	FiredAt       time.Time  `json:"fired_at"`
 	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+	// Acknowledged alerts are not re-alerted
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy *string    `json:"acknowledged_by,omitempty"`
+}
+
+type Heartbeat struct {
+	ID         string    `json:"id"`
+	MonitorID  string    `json:"monitor_id"`
+	Message    string    `json:"message"`
+	Metadata   string    `json:"metadata"`
+	SourceIP   string    `json:"source_ip"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type ThresholdRule struct {
+	ID          string    `json:"id"`
+	MonitorID   string    `json:"monitor_id"`
+	Metric      string    `json:"metric"`
+	Operator    string    `json:"operator"` // ">", ">=", "<", "<=", "==", "!="
+	Threshold   float64   `json:"threshold"`
+	Consecutive int       `json:"consecutive"`
+	BreachCount int       `json:"breach_count"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 type ApiKey struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	KeyPrefix string    `json:"key_prefix"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        string   `json:"id"`
+	TeamID    string   `json:"team_id"`
+	Name      string   `json:"name"`
+	KeyPrefix string   `json:"key_prefix"`
+	Scopes    []string `json:"scopes"`
+	// Heartbeats sent with this key must match both patterns, where * matches
+	// any run of characters
+	MonitorPattern   string     `json:"monitor_pattern"`
+	CheckTypePattern string     `json:"check_type_pattern"`
+	IsActive         bool       `json:"is_active"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	LastUsedAt       *time.Time `json:"last_used_at"`
+	LastUsedIP       string     `json:"last_used_ip"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// API key scopes. admin implies every other scope.
+const (
+	ScopeIngest  = "ingest"  // send heartbeats
+	ScopeRead    = "read"    // read monitors, channels, keys and logs
+	ScopeRespond = "respond" // acknowledge alerts and silence monitors
+	ScopeAdmin   = "admin"   // change everything else
+)
+
+var Scopes = []string{ScopeIngest, ScopeRead, ScopeRespond, ScopeAdmin}
+
+// AllowsMonitor reports whether the key may send heartbeats for a monitor.
+func (k ApiKey) AllowsMonitor(monitorName, checkType string) bool {
+	return matchPattern(k.MonitorPattern, monitorName) && matchPattern(k.CheckTypePattern, checkType)
+}
+
+// matchPattern matches s against a pattern in which * stands for any run of
+// characters, including none.
+func matchPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// HasScope reports whether the key may use routes requiring scope.
+func (k ApiKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
 }
 
 type NotificationLog struct {
 	ID        string    `json:"id"`
 	MonitorID string    `json:"monitor_id"`
 	ChannelID *string   `json:"channel_id"`
-	AlertType string    `json:"alert_type"` // "alert", "re_alert", "recovered"
+	AlertType string    `json:"alert_type"` // "alert", "re_alert", "recovered", "flapping", "flapping_stopped"
 	Message   string    `json:"message"`
 	Success   bool      `json:"success"`
 	Error     string    `json:"error,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// User is a person signing in with a password. Users belong to a team and
+// only see its data, like API keys.
+type User struct {
+	ID       string `json:"id"`
+	TeamID   string `json:"team_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	IsActive bool   `json:"is_active"`
+	// SSO users sign in through the OIDC provider and have no password
+	SSO       bool      `json:"sso"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// User roles, each allowed everything the previous one is.
+const (
+	RoleViewer    = "viewer"    // read everything
+	RoleResponder = "responder" // acknowledge alerts and silence monitors
+	RoleAdmin     = "admin"     // manage monitors, channels, keys and users
+)
+
+var Roles = []string{RoleViewer, RoleResponder, RoleAdmin}
+
+// HasScope reports whether the user's role allows routes requiring scope.
+// Users never send heartbeats.
+func (u User) HasScope(scope string) bool {
+	switch u.Role {
+	case RoleAdmin:
+		return scope != ScopeIngest
+	case RoleResponder:
+		return scope == ScopeRead || scope == ScopeRespond
+	default:
+		return scope == ScopeRead
+	}
+}
+
+// AuditEvent records a change made through the management API. Before and
+// After only hold the target's fields that changed; Before is null for
+// creations and After for deletions.
+type AuditEvent struct {
+	ID         string          `json:"id"`
+	TeamID     string          `json:"team_id"`
+	Actor      string          `json:"actor"`  // "user:<name>", "api_key:<name>" or "admin"
+	Action     string          `json:"action"` // "create", "update", "delete", "revoke", ...
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
@@ -0,0 +1,50 @@
+package model
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"web-1", "web-1", true},
+		{"web-1", "web-10", false},
+		{"web-1", "Web-1", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"web-*", "web-1", true},
+		{"web-*", "web-", true},
+		{"web-*", "db-web-1", false},
+		{"*-prod", "api-prod", true},
+		{"*-prod", "api-prod-2", false},
+		{"web-*-prod", "web-1-prod", true},
+		{"web-*-prod", "web-prod", false},
+		{"*web*", "prod-web-1", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "acb", false},
+		// Keys are created with "*" for a missing pattern, an empty one only
+		// matches the empty string
+		{"", "", true},
+		{"", "web-1", false},
+	} {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestAllowsMonitor(t *testing.T) {
+	k := ApiKey{MonitorPattern: "web-*", CheckTypePattern: "http"}
+	for _, tt := range []struct {
+		monitor, checkType string
+		want               bool
+	}{
+		{"web-1", "http", true},
+		{"web-1", "tcp", false},
+		{"db-1", "http", false},
+	} {
+		if got := k.AllowsMonitor(tt.monitor, tt.checkType); got != tt.want {
+			t.Errorf("AllowsMonitor(%q, %q) = %v, want %v", tt.monitor, tt.checkType, got, tt.want)
+		}
+	}
+}
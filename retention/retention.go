@@ -0,0 +1,69 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mohsen/alertinGo/store"
+)
+
+// Start prunes heartbeat history every hour, keeping at most
+// HEARTBEAT_RETENTION_DAYS days and HEARTBEAT_RETENTION_COUNT rows per monitor.
+// Setting either to 0 disables that limit. Expired sessions are deleted too.
+// It stops when ctx is cancelled.
+func Start(ctx context.Context, st store.Store) {
+	maxAge := time.Duration(envInt("HEARTBEAT_RETENTION_DAYS", 30)) * 24 * time.Hour
+	maxPerMonitor := envInt("HEARTBEAT_RETENTION_COUNT", 1000)
+
+	// Retention applies to every team
+	ctx = store.Unscoped(ctx)
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			prune(ctx, st, maxAge, maxPerMonitor)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("heartbeat retention started (max age %s, max %d per monitor)", maxAge, maxPerMonitor)
+}
+
+func prune(ctx context.Context, st store.Store, maxAge time.Duration, maxPerMonitor int) {
+	deleted, err := st.PruneHeartbeats(ctx, maxAge, maxPerMonitor)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[retention] error pruning heartbeats: %v", err)
+		}
+	} else if deleted > 0 {
+		log.Printf("[retention] pruned %d heartbeats", deleted)
+	}
+
+	deleted, err = st.PruneSessions(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[retention] error pruning sessions: %v", err)
+		}
+	} else if deleted > 0 {
+		log.Printf("[retention] pruned %d expired sessions", deleted)
+	}
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a non-negative number, got: %s", key, v)
+	}
+	return n
+}
@@ -0,0 +1,267 @@
+// Package sso signs users in with OpenID Connect, using the authorization
+// code flow with PKCE. The groups claim of the ID token decides the user's
+// role, so the identity provider stays the only place passwords live.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// ErrNoRole is returned when none of a user's groups maps to a role and
+// there is no default role.
+var ErrNoRole = errors.New("none of your groups is allowed to sign in")
+
+// Identity is what the identity provider vouches for about a user.
+type Identity struct {
+	Subject  string
+	Username string
+	Groups   []string
+}
+
+// Provider talks to an OIDC identity provider. Its configuration is only
+// discovered on the first sign-in, and again after a failure, so the server
+// starts and keeps alerting while the identity provider is down.
+type Provider struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	usernameClaim string
+	groupsClaim   string
+	// groupRoles maps a group to the role its members get
+	groupRoles  map[string]string
+	defaultRole string
+	// TeamID is the team users signing in for the first time are put in
+	TeamID string
+	client *http.Client
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// New configures a provider from the environment, or returns nil when
+// OIDC_ISSUER is not set.
+func New(ctx context.Context, teams store.Teams) *Provider {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	p := &Provider{
+		issuer:        issuer,
+		clientID:      os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		scopes:        []string{oidc.ScopeOpenID, "profile", "email"},
+		usernameClaim: envOr("OIDC_USERNAME_CLAIM", "preferred_username"),
+		groupsClaim:   envOr("OIDC_GROUPS_CLAIM", "groups"),
+		groupRoles:    map[string]string{},
+		defaultRole:   os.Getenv("OIDC_DEFAULT_ROLE"),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if p.clientID == "" || p.redirectURL == "" {
+		log.Fatal("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set with OIDC_ISSUER")
+	}
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		p.scopes = splitList(v)
+		if !slices.Contains(p.scopes, oidc.ScopeOpenID) {
+			p.scopes = append([]string{oidc.ScopeOpenID}, p.scopes...)
+		}
+	}
+
+	// OIDC_GROUP_ROLES looks like "sre=admin,oncall=responder,eng=viewer"
+	for _, pair := range splitList(os.Getenv("OIDC_GROUP_ROLES")) {
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(group) == "" || !slices.Contains(model.Roles, strings.TrimSpace(role)) {
+			log.Fatalf("OIDC_GROUP_ROLES entries must be group=role with a role of %s, got: %s", strings.Join(model.Roles, ", "), pair)
+		}
+		p.groupRoles[strings.TrimSpace(group)] = strings.TrimSpace(role)
+	}
+	if p.defaultRole != "" && !slices.Contains(model.Roles, p.defaultRole) {
+		log.Fatalf("OIDC_DEFAULT_ROLE must be one of %s, got: %s", strings.Join(model.Roles, ", "), p.defaultRole)
+	}
+	if len(p.groupRoles) == 0 && p.defaultRole == "" {
+		log.Fatal("OIDC_GROUP_ROLES or OIDC_DEFAULT_ROLE must be set with OIDC_ISSUER, otherwise nobody can sign in")
+	}
+
+	p.TeamID = model.DefaultTeamID
+	if name := os.Getenv("OIDC_TEAM"); name != "" {
+		team, err := teams.GetTeamByName(store.Unscoped(ctx), name)
+		if err != nil {
+			log.Fatalf("OIDC_TEAM %q: %v", name, err)
+		}
+		p.TeamID = team.ID
+	}
+
+	log.Printf("[sso] signing in with %s", issuer)
+	return p
+}
+
+// SecureCookies reports whether the redirect URL is served over HTTPS, in
+// which case the sign-in cookie must not be sent over plain HTTP.
+func (p *Provider) SecureCookies() bool {
+	return strings.HasPrefix(p.redirectURL, "https://")
+}
+
+// discover fetches the provider's configuration once it succeeded.
+func (p *Provider) discover() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	// The provider keeps this context to fetch signing keys later on, so it
+	// must outlive the request
+	provider, err := oidc.NewProvider(p.clientContext(context.Background()), p.issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover %s: %w", p.issuer, err)
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  p.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.clientID})
+	return p.oauth, p.verifier, nil
+}
+
+func (p *Provider) clientContext(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, p.client)
+}
+
+// AuthCodeURL returns where to send the user to sign in. state, nonce and
+// verifier must be kept by the client until the callback.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	oauth, _, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange redeems an authorization code and verifies the ID token it comes
+// with.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	oauth, idVerifier, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = p.clientContext(ctx)
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := idVerifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid id_token claims: %w", err)
+	}
+
+	id := &Identity{Subject: idToken.Subject, Groups: stringsClaim(claims[p.groupsClaim])}
+	for _, claim := range []string{p.usernameClaim, "email"} {
+		if v, ok := claims[claim].(string); ok && v != "" {
+			id.Username = v
+			break
+		}
+	}
+	if id.Username == "" {
+		id.Username = idToken.Subject
+	}
+	return id, nil
+}
+
+// Role returns the highest role the identity's groups map to, falling back
+// to OIDC_DEFAULT_ROLE.
+func (p *Provider) Role(id *Identity) (string, error) {
+	best := -1
+	for _, group := range id.Groups {
+		if role, ok := p.groupRoles[group]; ok {
+			best = max(best, slices.Index(model.Roles, role))
+		}
+	}
+	if best >= 0 {
+		return model.Roles[best], nil
+	}
+	if p.defaultRole != "" {
+		return p.defaultRole, nil
+	}
+	return "", ErrNoRole
+}
+
+// RandomString returns a URL-safe random string for states and nonces.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// stringsClaim reads a claim holding a list of strings, or a single one.
+func stringsClaim(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+// Helpers for backends that live inside a single process, such as the memory
+// and SQLite stores.
+
+// NewID returns a random (version 4) UUID.
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Broadcast hands IDs, such as recovered monitors or changed API keys, to
+// in-process listeners, for backends without something like Postgres
+// LISTEN/NOTIFY. The zero value is ready to use.
+type Broadcast struct {
+	mu        sync.Mutex
+	listeners map[chan string]struct{}
+}
+
+// Listen implements ListenRecoveries and ListenApiKeyChanges, calling ready,
+// if not nil, once it is listening. Announcements are dropped while fn is busy
+// and the buffer is full, same as a missed NOTIFY that the watcher's polling
+// pass or the key cache TTL covers later.
+func (r *Broadcast) Listen(ctx context.Context, ready func(), fn func(id string)) error {
+	CheckScope(ctx)
+	ch := make(chan string, 64)
+
+	r.mu.Lock()
+	if r.listeners == nil {
+		r.listeners = map[chan string]struct{}{}
+	}
+	r.listeners[ch] = struct{}{}
+	r.mu.Unlock()
+	if ready != nil {
+		ready()
+	}
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.listeners, ch)
+		r.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case id := <-ch:
+			fn(id)
+		}
+	}
+}
+
+// Publish announces IDs to every listener. Call it only once the change is
+// committed.
+func (r *Broadcast) Publish(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ch := range r.listeners {
+		for _, id := range ids {
+			select {
+			case ch <- id:
+			default:
+			}
+		}
+	}
+}
+
+// SingleInstance is an Elector that always leads, for stores that cannot be
+// shared between instances.
+type SingleInstance struct{}
+
+func (SingleInstance) Run(ctx context.Context) { <-ctx.Done() }
+func (SingleInstance) IsLeader() bool          { return true }
@@ -0,0 +1,1429 @@
+// Package memory is a store.Store that keeps everything in process. It mirrors
+// the semantics of the Postgres queries closely enough to run the handlers and
+// the watcher against it, e.g. in tests or for a quick local try-out.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/migrate"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// Store serializes every call on a single mutex. A transaction holds the mutex
+// until it commits, and restores a snapshot of the data if it fails.
+type Store struct {
+	clock clock.Clock
+
+	mu   sync.Mutex
+	data data
+
+	recoveries    store.Broadcast
+	apiKeyChanges store.Broadcast
+}
+
+var _ store.Store = (*Store)(nil)
+
+type data struct {
+	teams      []model.Team
+	monitors   []model.Monitor
+	heartbeats []model.Heartbeat
+	alerts     []model.AlertState
+	deps       []dependency
+	rules      []model.ThresholdRule
+	channels   []model.NotificationChannel
+	logs       []model.NotificationLog
+	apiKeys    []apiKey
+	users      []user
+	sessions   []session
+	audit      []model.AuditEvent
+
+	// recovered holds monitors that came back up, announced once the
+	// transaction that recovered them commits.
+	recovered []string
+}
+
+type dependency struct {
+	monitorID string
+	parentID  string
+}
+
+type apiKey struct {
+	model.ApiKey
+	hash string
+}
+
+// public returns a copy of the key that callers may modify.
+func (k apiKey) public() *model.ApiKey {
+	pk := k.ApiKey
+	pk.Scopes = slices.Clone(k.Scopes)
+	return &pk
+}
+
+type user struct {
+	model.User
+	passwordHash string
+	oidcSubject  string
+}
+
+type session struct {
+	tokenHash string
+	userID    string
+	expiresAt time.Time
+}
+
+func (d data) clone() data {
+	return data{
+		teams:      slices.Clone(d.teams),
+		monitors:   slices.Clone(d.monitors),
+		heartbeats: slices.Clone(d.heartbeats),
+		alerts:     slices.Clone(d.alerts),
+		deps:       slices.Clone(d.deps),
+		rules:      slices.Clone(d.rules),
+		channels:   slices.Clone(d.channels),
+		logs:       slices.Clone(d.logs),
+		apiKeys:    slices.Clone(d.apiKeys),
+		users:      slices.Clone(d.users),
+		sessions:   slices.Clone(d.sessions),
+		audit:      slices.Clone(d.audit),
+		recovered:  slices.Clone(d.recovered),
+	}
+}
+
+func New(clk clock.Clock) *Store {
+	s := &Store{clock: clk}
+	s.data.teams = []model.Team{{ID: model.DefaultTeamID, Name: "default", CreatedAt: clk.Now()}}
+	return s
+}
+
+// The memory store has no schema, so there is nothing to migrate.
+func (s *Store) Migrate(ctx context.Context) error                             { return nil }
+func (s *Store) MigrateDown(ctx context.Context, steps int) error              { return nil }
+func (s *Store) MigrationStatus(ctx context.Context) ([]migrate.Status, error) { return nil, nil }
+
+func (s *Store) Close() {}
+
+type txKey struct{}
+
+func (s *Store) inTx(ctx context.Context) bool {
+	return ctx.Value(txKey{}) == s
+}
+
+// lock holds the mutex for a single call, unless the call runs inside InTx,
+// which already holds it. Use as defer s.lock(ctx)().
+func (s *Store) lock(ctx context.Context) func() {
+	store.CheckScope(ctx)
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *Store) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	store.CheckScope(ctx)
+	if s.inTx(ctx) {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	snapshot := s.data.clone()
+	committed := false
+	defer func() {
+		if !committed {
+			s.data = snapshot
+		}
+		recovered := s.data.recovered
+		s.data.recovered = nil
+		s.mu.Unlock()
+		s.recoveries.Publish(recovered)
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, s)); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// NewElector always leads, a memory store cannot be shared between instances.
+func (s *Store) NewElector(name string, lockID int64) store.Elector {
+	return store.SingleInstance{}
+}
+
+// --- Teams ---
+
+func (s *Store) CreateTeam(ctx context.Context, name string) (*model.Team, error) {
+	defer s.lock(ctx)()
+
+	if slices.ContainsFunc(s.data.teams, func(t model.Team) bool { return t.Name == name }) {
+		return nil, store.ErrTeamExists
+	}
+	t := model.Team{ID: store.NewID(), Name: name, CreatedAt: s.clock.Now()}
+	s.data.teams = append(s.data.teams, t)
+	return &t, nil
+}
+
+func (s *Store) GetAllTeams(ctx context.Context) ([]model.Team, error) {
+	defer s.lock(ctx)()
+
+	var teams []model.Team
+	for _, t := range s.data.teams {
+		if store.InTeam(ctx, t.ID) {
+			teams = append(teams, t)
+		}
+	}
+	slices.SortFunc(teams, func(a, b model.Team) int { return cmp.Compare(a.Name, b.Name) })
+	return teams, nil
+}
+
+func (s *Store) GetTeamByID(ctx context.Context, id string) (*model.Team, error) {
+	return s.getTeam(ctx, func(t model.Team) bool { return t.ID == id })
+}
+
+func (s *Store) GetTeamByName(ctx context.Context, name string) (*model.Team, error) {
+	return s.getTeam(ctx, func(t model.Team) bool { return t.Name == name })
+}
+
+func (s *Store) getTeam(ctx context.Context, match func(t model.Team) bool) (*model.Team, error) {
+	defer s.lock(ctx)()
+
+	i := slices.IndexFunc(s.data.teams, match)
+	if i < 0 || !store.InTeam(ctx, s.data.teams[i].ID) {
+		return nil, store.ErrNotFound
+	}
+	t := s.data.teams[i]
+	return &t, nil
+}
+
+// --- Monitors ---
+
+func (s *Store) monitor(id string) int {
+	return slices.IndexFunc(s.data.monitors, func(m model.Monitor) bool { return m.ID == id })
+}
+
+// teamMonitor is monitor, but only finds monitors visible with ctx.
+func (s *Store) teamMonitor(ctx context.Context, id string) int {
+	i := s.monitor(id)
+	if i < 0 || !store.InTeam(ctx, s.data.monitors[i].TeamID) {
+		return -1
+	}
+	return i
+}
+
+func overdue(m model.Monitor, now time.Time) bool {
+	return m.LastSeenAt.Add(time.Duration(m.Timeout*m.AlertAfterMisses) * time.Second).Before(now)
+}
+
+// watched joins an active monitor with its channel, like the JOIN on
+// notification_channels in the Postgres queries.
+func (s *Store) watched(m model.Monitor) (store.OverdueMonitor, bool) {
+	if !m.IsActive || m.ChannelID == nil {
+		return store.OverdueMonitor{}, false
+	}
+	i := s.channel(*m.ChannelID)
+	if i < 0 {
+		return store.OverdueMonitor{}, false
+	}
+	return store.OverdueMonitor{Monitor: m, TelegramChatID: s.data.channels[i].TelegramChatID}, true
+}
+
+func (s *Store) UpsertMonitor(ctx context.Context, m *model.Monitor) (*model.Monitor, error) {
+	team := store.OwnerTeam(ctx)
+	var mon model.Monitor
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		now := s.clock.Now()
+		i := slices.IndexFunc(s.data.monitors, func(e model.Monitor) bool {
+			return e.TeamID == team && e.MonitorName == m.MonitorName && e.CheckType == m.CheckType
+		})
+		if i < 0 {
+			s.data.monitors = append(s.data.monitors, model.Monitor{
+				ID:             store.NewID(),
+				TeamID:         team,
+				MonitorName:    m.MonitorName,
+				CheckType:      m.CheckType,
+				Status:         "unknown",
+				CreatedAt:      now,
+				CreatedByKeyID: m.CreatedByKeyID,
+			})
+			i = len(s.data.monitors) - 1
+		} else {
+			e := &s.data.monitors[i]
+			// a down monitor only comes back up after recover_after_heartbeats heartbeats in a row
+			if e.Status == "down" && e.RecoveryHeartbeats+1 < m.RecoverAfterHeartbeats {
+				e.RecoveryHeartbeats++
+			} else {
+				if e.Status == "down" {
+					s.data.recovered = append(s.data.recovered, e.ID)
+				}
+				e.Status = "up"
+				e.RecoveryHeartbeats = 0
+			}
+		}
+
+		e := &s.data.monitors[i]
+		e.Message = m.Message
+		e.Metadata = m.Metadata
+		e.Timeout = m.Timeout
+		e.ReAlertInterval = m.ReAlertInterval
+		e.AlertAfterMisses = m.AlertAfterMisses
+		e.RecoverAfterHeartbeats = m.RecoverAfterHeartbeats
+		e.ServerIP = m.ServerIP
+		e.ServerName = m.ServerName
+		e.Labels = maps.Clone(m.Labels)
+		e.LastSeenAt = now
+		e.UpdatedAt = now
+		mon = *e
+		return nil
+	})
+	return &mon, err
+}
+
+func (s *Store) GetAllMonitors(ctx context.Context) ([]model.Monitor, error) {
+	defer s.lock(ctx)()
+
+	var monitors []model.Monitor
+	for _, m := range slices.Backward(s.data.monitors) {
+		if store.InTeam(ctx, m.TeamID) {
+			monitors = append(monitors, m)
+		}
+	}
+	return monitors, nil
+}
+
+func (s *Store) GetMonitorByID(ctx context.Context, id string) (*model.Monitor, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamMonitor(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	m := s.data.monitors[i]
+	return &m, nil
+}
+
+func (s *Store) UpdateMonitor(ctx context.Context, id string, isActive bool, channelID *string) (*model.Monitor, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamMonitor(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	if channelID != nil {
+		c := s.channel(*channelID)
+		if c < 0 {
+			return nil, fmt.Errorf("channel %s does not exist", *channelID)
+		}
+		if s.data.channels[c].TeamID != s.data.monitors[i].TeamID {
+			return nil, store.ErrNotFound
+		}
+	}
+
+	m := &s.data.monitors[i]
+	m.IsActive = isActive
+	m.ChannelID = channelID
+	m.UpdatedAt = s.clock.Now()
+	mon := *m
+	return &mon, nil
+}
+
+func (s *Store) DeleteMonitor(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, id) < 0 {
+		return nil
+	}
+	d := &s.data
+	d.monitors = slices.DeleteFunc(d.monitors, func(m model.Monitor) bool { return m.ID == id })
+	d.heartbeats = slices.DeleteFunc(d.heartbeats, func(h model.Heartbeat) bool { return h.MonitorID == id })
+	d.alerts = slices.DeleteFunc(d.alerts, func(a model.AlertState) bool { return a.MonitorID == id })
+	d.rules = slices.DeleteFunc(d.rules, func(r model.ThresholdRule) bool { return r.MonitorID == id })
+	d.logs = slices.DeleteFunc(d.logs, func(l model.NotificationLog) bool { return l.MonitorID == id })
+	d.deps = slices.DeleteFunc(d.deps, func(dep dependency) bool { return dep.monitorID == id || dep.parentID == id })
+	return nil
+}
+
+func (s *Store) SilenceMonitor(ctx context.Context, id string, until *time.Time, by *string) (*model.Monitor, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamMonitor(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	m := &s.data.monitors[i]
+	m.SilencedUntil = until
+	m.SilencedBy = by
+	m.UpdatedAt = s.clock.Now()
+	mon := *m
+	return &mon, nil
+}
+
+func (s *Store) SetMonitorStatus(ctx context.Context, id string, status string) error {
+	defer s.lock(ctx)()
+
+	if i := s.teamMonitor(ctx, id); i >= 0 {
+		m := &s.data.monitors[i]
+		m.Status = status
+		m.RecoveryHeartbeats = 0
+		m.UpdatedAt = s.clock.Now()
+	}
+	return nil
+}
+
+// filterWatched returns the watched monitors visible with ctx matching cond.
+func (s *Store) filterWatched(ctx context.Context, cond func(m model.Monitor) bool) []store.OverdueMonitor {
+	var result []store.OverdueMonitor
+	for _, m := range s.data.monitors {
+		if om, ok := s.watched(m); ok && store.InTeam(ctx, m.TeamID) && cond(m) {
+			result = append(result, om)
+		}
+	}
+	return result
+}
+
+// lockWatched returns a watched monitor if it matches cond. The caller already
+// holds the mutex for the whole transaction, so there is nothing to skip.
+func (s *Store) lockWatched(ctx context.Context, id string, cond func(m model.Monitor) bool) (*store.OverdueMonitor, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamMonitor(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	om, ok := s.watched(s.data.monitors[i])
+	if !ok || !cond(om.Monitor) {
+		return nil, store.ErrNotFound
+	}
+	return &om, nil
+}
+
+func (s *Store) isOverdue(m model.Monitor) bool {
+	return overdue(m, s.clock.Now())
+}
+
+func (s *Store) isRecovered(m model.Monitor) bool {
+	return m.Status == "up" && s.firingAlert(m.ID) >= 0
+}
+
+func isFlapping(m model.Monitor) bool {
+	return m.IsFlapping
+}
+
+func (s *Store) GetOverdueMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
+	defer s.lock(ctx)()
+	return s.filterWatched(ctx, s.isOverdue), nil
+}
+
+func (s *Store) LockOverdueMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return s.lockWatched(ctx, id, s.isOverdue)
+}
+
+func (s *Store) GetRecoveredMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
+	defer s.lock(ctx)()
+	return s.filterWatched(ctx, s.isRecovered), nil
+}
+
+func (s *Store) LockRecoveredMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return s.lockWatched(ctx, id, s.isRecovered)
+}
+
+func (s *Store) GetFlappingMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
+	defer s.lock(ctx)()
+	return s.filterWatched(ctx, isFlapping), nil
+}
+
+func (s *Store) LockFlappingMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return s.lockWatched(ctx, id, isFlapping)
+}
+
+func (s *Store) SetMonitorFlapping(ctx context.Context, id string, flapping bool) error {
+	defer s.lock(ctx)()
+
+	if i := s.teamMonitor(ctx, id); i >= 0 {
+		now := s.clock.Now()
+		m := &s.data.monitors[i]
+		m.IsFlapping = flapping
+		m.FlappingSince = nil
+		if flapping {
+			m.FlappingSince = &now
+		}
+		m.UpdatedAt = now
+	}
+	return nil
+}
+
+func (s *Store) CountStateTransitions(ctx context.Context, monitorID string, window time.Duration) (int, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 {
+		return 0, nil
+	}
+	since := s.clock.Now().Add(-window)
+	count := 0
+	for _, a := range s.data.alerts {
+		if a.MonitorID != monitorID || a.Type != "heartbeat" {
+			continue
+		}
+		if a.FiredAt.After(since) {
+			count++
+		}
+		if a.ResolvedAt != nil && a.ResolvedAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// nextCheckAt mirrors the nextCheckAt expression of the Postgres store.
+func (s *Store) nextCheckAt(m model.Monitor) time.Time {
+	at := m.LastSeenAt.Add(time.Duration(m.Timeout*m.AlertAfterMisses) * time.Second)
+	if i := s.firingAlert(m.ID); i >= 0 {
+		reAlert := s.data.alerts[i].LastAlertedAt.Add(time.Duration(m.ReAlertInterval) * time.Second)
+		if reAlert.After(at) {
+			at = reAlert
+		}
+	}
+	return at
+}
+
+func (s *Store) GetMonitorDeadlines(ctx context.Context) (map[string]time.Time, error) {
+	defer s.lock(ctx)()
+
+	deadlines := map[string]time.Time{}
+	for _, m := range s.data.monitors {
+		if _, ok := s.watched(m); ok && store.InTeam(ctx, m.TeamID) {
+			deadlines[m.ID] = s.nextCheckAt(m)
+		}
+	}
+	return deadlines, nil
+}
+
+func (s *Store) GetMonitorDeadline(ctx context.Context, id string) (time.Time, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamMonitor(ctx, id)
+	if i < 0 {
+		return time.Time{}, store.ErrNotFound
+	}
+	m := s.data.monitors[i]
+	if _, ok := s.watched(m); !ok {
+		return time.Time{}, store.ErrNotFound
+	}
+	return s.nextCheckAt(m), nil
+}
+
+func (s *Store) ListenRecoveries(ctx context.Context, fn func(monitorID string)) error {
+	return s.recoveries.Listen(ctx, nil, fn)
+}
+
+// ListenDeadlineChanges never calls fn, a memory store has a single instance and
+// it moves its deadlines itself.
+func (s *Store) ListenDeadlineChanges(ctx context.Context, fn func(monitorID string)) error {
+	store.CheckScope(ctx)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// --- Heartbeat History ---
+
+func (s *Store) CreateHeartbeat(ctx context.Context, monitorID, message, metadata, sourceIP string) error {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 {
+		return nil
+	}
+	s.data.heartbeats = append(s.data.heartbeats, model.Heartbeat{
+		ID:         store.NewID(),
+		MonitorID:  monitorID,
+		Message:    message,
+		Metadata:   metadata,
+		SourceIP:   sourceIP,
+		ReceivedAt: s.clock.Now(),
+	})
+	return nil
+}
+
+func (s *Store) GetHeartbeats(ctx context.Context, monitorID string, from, to *time.Time, limit int) ([]model.Heartbeat, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 {
+		return nil, nil
+	}
+	var heartbeats []model.Heartbeat
+	for _, h := range slices.Backward(s.data.heartbeats) {
+		if len(heartbeats) == limit {
+			break
+		}
+		if h.MonitorID != monitorID ||
+			from != nil && h.ReceivedAt.Before(*from) ||
+			to != nil && h.ReceivedAt.After(*to) {
+			continue
+		}
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, nil
+}
+
+func (s *Store) PruneHeartbeats(ctx context.Context, maxAge time.Duration, maxPerMonitor int) (int64, error) {
+	defer s.lock(ctx)()
+
+	cutoff := s.clock.Now().Add(-maxAge)
+	kept := map[string]int{}
+	var keep []model.Heartbeat
+	// Walk newest first so the per-monitor limit keeps the latest rows
+	for _, h := range slices.Backward(s.data.heartbeats) {
+		if maxAge > 0 && h.ReceivedAt.Before(cutoff) {
+			continue
+		}
+		if maxPerMonitor > 0 && kept[h.MonitorID] >= maxPerMonitor {
+			continue
+		}
+		kept[h.MonitorID]++
+		keep = append(keep, h)
+	}
+	slices.Reverse(keep)
+
+	deleted := int64(len(s.data.heartbeats) - len(keep))
+	s.data.heartbeats = keep
+	return deleted, nil
+}
+
+// --- Alert States ---
+
+func (s *Store) firingAlert(monitorID string) int {
+	return slices.IndexFunc(s.data.alerts, func(a model.AlertState) bool {
+		return a.MonitorID == monitorID && a.Type == "heartbeat" && a.Status == "firing"
+	})
+}
+
+func (s *Store) firingThresholdAlert(ruleID string) int {
+	return slices.IndexFunc(s.data.alerts, func(a model.AlertState) bool {
+		return a.RuleID != nil && *a.RuleID == ruleID && a.Type == "threshold" && a.Status == "firing"
+	})
+}
+
+// teamAlert finds an alert whose monitor is visible with ctx.
+func (s *Store) teamAlert(ctx context.Context, id string) *model.AlertState {
+	i := slices.IndexFunc(s.data.alerts, func(a model.AlertState) bool { return a.ID == id })
+	if i < 0 || s.teamMonitor(ctx, s.data.alerts[i].MonitorID) < 0 {
+		return nil
+	}
+	return &s.data.alerts[i]
+}
+
+func (s *Store) GetFiringAlert(ctx context.Context, monitorID string) (*model.AlertState, error) {
+	defer s.lock(ctx)()
+
+	i := s.firingAlert(monitorID)
+	if i < 0 || s.teamMonitor(ctx, monitorID) < 0 {
+		return nil, store.ErrNotFound
+	}
+	a := s.data.alerts[i]
+	return &a, nil
+}
+
+func (s *Store) GetFiringThresholdAlert(ctx context.Context, ruleID string) (*model.AlertState, error) {
+	defer s.lock(ctx)()
+
+	i := s.firingThresholdAlert(ruleID)
+	if i < 0 || s.teamMonitor(ctx, s.data.alerts[i].MonitorID) < 0 {
+		return nil, store.ErrNotFound
+	}
+	a := s.data.alerts[i]
+	return &a, nil
+}
+
+func (s *Store) GetFiringAlerts(ctx context.Context, monitorID string) ([]model.AlertState, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 {
+		return nil, nil
+	}
+	var alerts []model.AlertState
+	for _, a := range s.data.alerts {
+		if a.MonitorID == monitorID && a.Status == "firing" {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts, nil
+}
+
+func (s *Store) AcknowledgeAlerts(ctx context.Context, monitorID, by string) (int64, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 {
+		return 0, nil
+	}
+	now := s.clock.Now()
+	var n int64
+	for i, a := range s.data.alerts {
+		if a.MonitorID == monitorID && a.Status == "firing" && a.AcknowledgedAt == nil {
+			s.data.alerts[i].AcknowledgedAt = &now
+			s.data.alerts[i].AcknowledgedBy = &by
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) CreateAlertState(ctx context.Context, monitorID string, suppressed bool) (bool, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 || s.firingAlert(monitorID) >= 0 {
+		return false, nil
+	}
+	now := s.clock.Now()
+	s.data.alerts = append(s.data.alerts, model.AlertState{
+		ID:            store.NewID(),
+		MonitorID:     monitorID,
+		Type:          "heartbeat",
+		Status:        "firing",
+		Suppressed:    suppressed,
+		LastAlertedAt: now,
+		FiredAt:       now,
+	})
+	return true, nil
+}
+
+func (s *Store) CreateThresholdAlertState(ctx context.Context, monitorID, ruleID string) (bool, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 || s.firingThresholdAlert(ruleID) >= 0 {
+		return false, nil
+	}
+	now := s.clock.Now()
+	s.data.alerts = append(s.data.alerts, model.AlertState{
+		ID:            store.NewID(),
+		MonitorID:     monitorID,
+		Type:          "threshold",
+		RuleID:        &ruleID,
+		Status:        "firing",
+		LastAlertedAt: now,
+		FiredAt:       now,
+	})
+	return true, nil
+}
+
+func (s *Store) UnsuppressAlert(ctx context.Context, alertID string) error {
+	defer s.lock(ctx)()
+
+	if a := s.teamAlert(ctx, alertID); a != nil {
+		a.Suppressed = false
+		a.LastAlertedAt = s.clock.Now()
+	}
+	return nil
+}
+
+func (s *Store) UpdateAlertLastAlerted(ctx context.Context, alertID string) error {
+	defer s.lock(ctx)()
+
+	if a := s.teamAlert(ctx, alertID); a != nil {
+		a.LastAlertedAt = s.clock.Now()
+	}
+	return nil
+}
+
+func (s *Store) ResolveAlert(ctx context.Context, alertID string) error {
+	defer s.lock(ctx)()
+
+	if a := s.teamAlert(ctx, alertID); a != nil {
+		now := s.clock.Now()
+		a.Status = "resolved"
+		a.ResolvedAt = &now
+	}
+	return nil
+}
+
+// --- Monitor Dependencies ---
+
+func (s *Store) GetMonitorParents(ctx context.Context, monitorID string) ([]model.Monitor, error) {
+	defer s.lock(ctx)()
+
+	var parents []model.Monitor
+	for _, d := range s.data.deps {
+		if d.monitorID != monitorID {
+			continue
+		}
+		if i := s.teamMonitor(ctx, d.parentID); i >= 0 {
+			parents = append(parents, s.data.monitors[i])
+		}
+	}
+	return parents, nil
+}
+
+func (s *Store) AddMonitorParent(ctx context.Context, monitorID, parentID string) error {
+	defer s.lock(ctx)()
+
+	c, p := s.teamMonitor(ctx, monitorID), s.teamMonitor(ctx, parentID)
+	if c < 0 || p < 0 || s.data.monitors[c].TeamID != s.data.monitors[p].TeamID {
+		return store.ErrNotFound
+	}
+
+	// Walk up from the new parent; meeting the monitor means a cycle
+	seen := map[string]bool{}
+	queue := []string{parentID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == monitorID {
+			return store.ErrDependencyCycle
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		for _, d := range s.data.deps {
+			if d.monitorID == id {
+				queue = append(queue, d.parentID)
+			}
+		}
+	}
+
+	dep := dependency{monitorID: monitorID, parentID: parentID}
+	if !slices.Contains(s.data.deps, dep) {
+		s.data.deps = append(s.data.deps, dep)
+	}
+	return nil
+}
+
+func (s *Store) RemoveMonitorParent(ctx context.Context, monitorID, parentID string) error {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 {
+		return nil
+	}
+	s.data.deps = slices.DeleteFunc(s.data.deps, func(d dependency) bool {
+		return d.monitorID == monitorID && d.parentID == parentID
+	})
+	return nil
+}
+
+func (s *Store) isDown(m model.Monitor) bool {
+	return m.IsActive && (m.Status == "down" || s.isOverdue(m))
+}
+
+func (s *Store) HasDownParent(ctx context.Context, monitorID string) (bool, error) {
+	defer s.lock(ctx)()
+
+	for _, d := range s.data.deps {
+		if d.monitorID != monitorID {
+			continue
+		}
+		if i := s.teamMonitor(ctx, d.parentID); i >= 0 && s.isDown(s.data.monitors[i]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Store) GetDownChildren(ctx context.Context, parentID string) ([]model.Monitor, error) {
+	defer s.lock(ctx)()
+
+	var children []model.Monitor
+	for _, d := range s.data.deps {
+		if d.parentID != parentID {
+			continue
+		}
+		if i := s.teamMonitor(ctx, d.monitorID); i >= 0 && s.isDown(s.data.monitors[i]) {
+			children = append(children, s.data.monitors[i])
+		}
+	}
+	slices.SortFunc(children, func(a, b model.Monitor) int {
+		return cmp.Or(cmp.Compare(a.MonitorName, b.MonitorName), cmp.Compare(a.CheckType, b.CheckType))
+	})
+	return children, nil
+}
+
+func (s *Store) GetSuppressedChildren(ctx context.Context, parentID string) ([]store.OverdueMonitor, error) {
+	defer s.lock(ctx)()
+
+	var children []store.OverdueMonitor
+	for _, d := range s.data.deps {
+		if d.parentID != parentID {
+			continue
+		}
+		i := s.teamMonitor(ctx, d.monitorID)
+		if i < 0 {
+			continue
+		}
+		om, ok := s.watched(s.data.monitors[i])
+		if a := s.firingAlert(om.ID); ok && a >= 0 && s.data.alerts[a].Suppressed {
+			children = append(children, om)
+		}
+	}
+	return children, nil
+}
+
+// --- Threshold Rules ---
+
+func (s *Store) rule(id string) int {
+	return slices.IndexFunc(s.data.rules, func(r model.ThresholdRule) bool { return r.ID == id })
+}
+
+// teamRule is rule, but only finds rules of monitors visible with ctx.
+func (s *Store) teamRule(ctx context.Context, id string) int {
+	i := s.rule(id)
+	if i < 0 || s.teamMonitor(ctx, s.data.rules[i].MonitorID) < 0 {
+		return -1
+	}
+	return i
+}
+
+func (s *Store) CreateThresholdRule(ctx context.Context, r *model.ThresholdRule) (*model.ThresholdRule, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, r.MonitorID) < 0 {
+		return nil, store.ErrNotFound
+	}
+	rule := *r
+	rule.ID = store.NewID()
+	rule.BreachCount = 0
+	rule.CreatedAt = s.clock.Now()
+	s.data.rules = append(s.data.rules, rule)
+	return &rule, nil
+}
+
+func (s *Store) GetThresholdRules(ctx context.Context, monitorID string) ([]model.ThresholdRule, error) {
+	defer s.lock(ctx)()
+
+	if s.teamMonitor(ctx, monitorID) < 0 {
+		return nil, nil
+	}
+	var rules []model.ThresholdRule
+	for _, r := range s.data.rules {
+		if r.MonitorID == monitorID {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+func (s *Store) LockThresholdRule(ctx context.Context, id string) (*model.ThresholdRule, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamRule(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	r := s.data.rules[i]
+	return &r, nil
+}
+
+func (s *Store) SetRuleBreachCount(ctx context.Context, id string, count int) error {
+	defer s.lock(ctx)()
+
+	if i := s.teamRule(ctx, id); i >= 0 {
+		s.data.rules[i].BreachCount = count
+	}
+	return nil
+}
+
+func (s *Store) DeleteThresholdRule(ctx context.Context, monitorID, id string) error {
+	defer s.lock(ctx)()
+
+	i := s.rule(id)
+	if i < 0 || s.data.rules[i].MonitorID != monitorID || s.teamMonitor(ctx, monitorID) < 0 {
+		return nil
+	}
+	s.data.rules = slices.Delete(s.data.rules, i, i+1)
+	s.data.alerts = slices.DeleteFunc(s.data.alerts, func(a model.AlertState) bool {
+		return a.RuleID != nil && *a.RuleID == id
+	})
+	return nil
+}
+
+// --- Notification Channels ---
+
+func (s *Store) channel(id string) int {
+	return slices.IndexFunc(s.data.channels, func(ch model.NotificationChannel) bool { return ch.ID == id })
+}
+
+// teamChannel is channel, but only finds channels visible with ctx.
+func (s *Store) teamChannel(ctx context.Context, id string) int {
+	i := s.channel(id)
+	if i < 0 || !store.InTeam(ctx, s.data.channels[i].TeamID) {
+		return -1
+	}
+	return i
+}
+
+func (s *Store) CreateChannel(ctx context.Context, name, telegramChatID string) (*model.NotificationChannel, error) {
+	defer s.lock(ctx)()
+
+	ch := model.NotificationChannel{
+		ID:             store.NewID(),
+		TeamID:         store.OwnerTeam(ctx),
+		Name:           name,
+		TelegramChatID: telegramChatID,
+		CreatedAt:      s.clock.Now(),
+	}
+	s.data.channels = append(s.data.channels, ch)
+	return &ch, nil
+}
+
+func (s *Store) GetChannelByID(ctx context.Context, id string) (*model.NotificationChannel, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamChannel(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	ch := s.data.channels[i]
+	return &ch, nil
+}
+
+func (s *Store) GetAllChannels(ctx context.Context) ([]model.NotificationChannel, error) {
+	defer s.lock(ctx)()
+
+	var channels []model.NotificationChannel
+	for _, ch := range slices.Backward(s.data.channels) {
+		if store.InTeam(ctx, ch.TeamID) {
+			channels = append(channels, ch)
+		}
+	}
+	return channels, nil
+}
+
+func (s *Store) DeleteChannel(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	if s.teamChannel(ctx, id) < 0 {
+		return nil
+	}
+	s.data.channels = slices.DeleteFunc(s.data.channels, func(ch model.NotificationChannel) bool { return ch.ID == id })
+	for i, m := range s.data.monitors {
+		if m.ChannelID != nil && *m.ChannelID == id {
+			s.data.monitors[i].ChannelID = nil
+		}
+	}
+	for i, l := range s.data.logs {
+		if l.ChannelID != nil && *l.ChannelID == id {
+			s.data.logs[i].ChannelID = nil
+		}
+	}
+	return nil
+}
+
+// --- Notification Logs ---
+
+func (s *Store) CreateNotificationLog(ctx context.Context, monitorID string, channelID *string, alertType, message string, success bool, errMsg string) error {
+	defer s.lock(ctx)()
+
+	s.data.logs = append(s.data.logs, model.NotificationLog{
+		ID:        store.NewID(),
+		MonitorID: monitorID,
+		ChannelID: channelID,
+		AlertType: alertType,
+		Message:   message,
+		Success:   success,
+		Error:     errMsg,
+		CreatedAt: s.clock.Now(),
+	})
+	return nil
+}
+
+func (s *Store) GetNotificationLogs(ctx context.Context) ([]model.NotificationLog, error) {
+	defer s.lock(ctx)()
+
+	var logs []model.NotificationLog
+	for _, l := range slices.Backward(s.data.logs) {
+		if len(logs) == 100 {
+			break
+		}
+		if s.teamMonitor(ctx, l.MonitorID) >= 0 {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+// --- API Keys ---
+
+func (s *Store) CreateApiKey(ctx context.Context, k *model.ApiKey, keyHash string) (*model.ApiKey, error) {
+	defer s.lock(ctx)()
+
+	if slices.ContainsFunc(s.data.apiKeys, func(k apiKey) bool { return k.hash == keyHash }) {
+		return nil, fmt.Errorf("API key hash already exists")
+	}
+	key := apiKey{
+		ApiKey: model.ApiKey{
+			ID:               store.NewID(),
+			TeamID:           store.OwnerTeam(ctx),
+			Name:             k.Name,
+			KeyPrefix:        k.KeyPrefix,
+			Scopes:           slices.Clone(k.Scopes),
+			MonitorPattern:   k.MonitorPattern,
+			CheckTypePattern: k.CheckTypePattern,
+			IsActive:         true,
+			ExpiresAt:        k.ExpiresAt,
+			CreatedAt:        s.clock.Now(),
+		},
+		hash: keyHash,
+	}
+	s.data.apiKeys = append(s.data.apiKeys, key)
+	return key.public(), nil
+}
+
+func (s *Store) GetAllApiKeys(ctx context.Context) ([]model.ApiKey, error) {
+	defer s.lock(ctx)()
+
+	var keys []model.ApiKey
+	for _, k := range slices.Backward(s.data.apiKeys) {
+		if store.InTeam(ctx, k.TeamID) {
+			keys = append(keys, *k.public())
+		}
+	}
+	return keys, nil
+}
+
+func (s *Store) GetApiKeyByID(ctx context.Context, id string) (*model.ApiKey, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamApiKey(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	return s.data.apiKeys[i].public(), nil
+}
+
+func (s *Store) apiKey(id string) int {
+	return slices.IndexFunc(s.data.apiKeys, func(k apiKey) bool { return k.ID == id })
+}
+
+// teamApiKey is apiKey, but only finds keys visible with ctx.
+func (s *Store) teamApiKey(ctx context.Context, id string) int {
+	i := s.apiKey(id)
+	if i < 0 || !store.InTeam(ctx, s.data.apiKeys[i].TeamID) {
+		return -1
+	}
+	return i
+}
+
+func (s *Store) UpdateApiKey(ctx context.Context, k *model.ApiKey) (*model.ApiKey, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamApiKey(ctx, k.ID)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	key := &s.data.apiKeys[i]
+	key.Name = k.Name
+	key.IsActive = k.IsActive
+	key.Scopes = slices.Clone(k.Scopes)
+	key.MonitorPattern = k.MonitorPattern
+	key.CheckTypePattern = k.CheckTypePattern
+	key.ExpiresAt = k.ExpiresAt
+	s.apiKeyChanges.Publish([]string{k.ID})
+	return key.public(), nil
+}
+
+func (s *Store) DeleteApiKey(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	if s.teamApiKey(ctx, id) < 0 {
+		return nil
+	}
+	s.data.apiKeys = slices.DeleteFunc(s.data.apiKeys, func(k apiKey) bool { return k.ID == id })
+	for i, m := range s.data.monitors {
+		if m.CreatedByKeyID != nil && *m.CreatedByKeyID == id {
+			s.data.monitors[i].CreatedByKeyID = nil
+		}
+	}
+	s.apiKeyChanges.Publish([]string{id})
+	return nil
+}
+
+func (s *Store) RevokeApiKey(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	i := s.teamApiKey(ctx, id)
+	if i < 0 {
+		return store.ErrNotFound
+	}
+	s.data.apiKeys[i].IsActive = false
+	s.apiKeyChanges.Publish([]string{id})
+	return nil
+}
+
+func (s *Store) ExpireApiKey(ctx context.Context, id string, at time.Time) error {
+	defer s.lock(ctx)()
+
+	i := s.teamApiKey(ctx, id)
+	if i < 0 {
+		return store.ErrNotFound
+	}
+	k := &s.data.apiKeys[i]
+	if k.ExpiresAt == nil || k.ExpiresAt.After(at) {
+		k.ExpiresAt = &at
+	}
+	s.apiKeyChanges.Publish([]string{id})
+	return nil
+}
+
+// ListenApiKeyChanges is fed by the key updates above. They announce changes
+// right away, even inside a transaction: lookups wait for the mutex, so they
+// can't see the key before the transaction ends.
+func (s *Store) ListenApiKeyChanges(ctx context.Context, ready func(), fn func(keyID string)) error {
+	return s.apiKeyChanges.Listen(ctx, ready, fn)
+}
+
+func (s *Store) GetActiveApiKey(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	defer s.lock(ctx)()
+
+	now := s.clock.Now()
+	for _, k := range s.data.apiKeys {
+		if k.hash == keyHash && k.IsActive && (k.ExpiresAt == nil || k.ExpiresAt.After(now)) {
+			return k.public(), nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) RecordApiKeyUses(ctx context.Context, uses []store.ApiKeyUse) error {
+	defer s.lock(ctx)()
+
+	for _, u := range uses {
+		i := s.apiKey(u.KeyID)
+		if i < 0 {
+			continue
+		}
+		k := &s.data.apiKeys[i]
+		if k.LastUsedAt != nil && !k.LastUsedAt.Before(u.At) {
+			continue
+		}
+		k.LastUsedAt = &u.At
+		k.LastUsedIP = u.IP
+	}
+	return nil
+}
+
+// --- Users ---
+
+func (s *Store) userIndex(id string) int {
+	return slices.IndexFunc(s.data.users, func(u user) bool { return u.ID == id })
+}
+
+// teamUser is userIndex, but only finds users visible with ctx.
+func (s *Store) teamUser(ctx context.Context, id string) int {
+	i := s.userIndex(id)
+	if i < 0 || !store.InTeam(ctx, s.data.users[i].TeamID) {
+		return -1
+	}
+	return i
+}
+
+// endSessions deletes every session of a user.
+func (s *Store) endSessions(userID string) {
+	s.data.sessions = slices.DeleteFunc(s.data.sessions, func(se session) bool { return se.userID == userID })
+}
+
+func (s *Store) CreateUser(ctx context.Context, u *model.User, passwordHash string) (*model.User, error) {
+	defer s.lock(ctx)()
+
+	if slices.ContainsFunc(s.data.users, func(e user) bool { return e.Username == u.Username }) {
+		return nil, store.ErrUserExists
+	}
+	nu := user{
+		User: model.User{
+			ID:        store.NewID(),
+			TeamID:    store.OwnerTeam(ctx),
+			Username:  u.Username,
+			Role:      u.Role,
+			IsActive:  true,
+			CreatedAt: s.clock.Now(),
+		},
+		passwordHash: passwordHash,
+	}
+	s.data.users = append(s.data.users, nu)
+	return &nu.User, nil
+}
+
+func (s *Store) GetAllUsers(ctx context.Context) ([]model.User, error) {
+	defer s.lock(ctx)()
+
+	var users []model.User
+	for _, u := range s.data.users {
+		if store.InTeam(ctx, u.TeamID) {
+			users = append(users, u.User)
+		}
+	}
+	slices.SortFunc(users, func(a, b model.User) int { return cmp.Compare(a.Username, b.Username) })
+	return users, nil
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id string) (*model.User, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamUser(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	u := s.data.users[i].User
+	return &u, nil
+}
+
+func (s *Store) UpsertSSOUser(ctx context.Context, u *model.User, subject string) (*model.User, error) {
+	defer s.lock(ctx)()
+
+	i := slices.IndexFunc(s.data.users, func(e user) bool { return e.oidcSubject == subject })
+	if slices.ContainsFunc(s.data.users, func(e user) bool { return e.Username == u.Username && e.oidcSubject != subject }) {
+		return nil, store.ErrUserExists
+	}
+	if i >= 0 {
+		e := &s.data.users[i]
+		e.Username = u.Username
+		e.Role = u.Role
+		pu := e.User
+		return &pu, nil
+	}
+
+	nu := user{
+		User: model.User{
+			ID:        store.NewID(),
+			TeamID:    store.OwnerTeam(ctx),
+			Username:  u.Username,
+			Role:      u.Role,
+			IsActive:  true,
+			SSO:       true,
+			CreatedAt: s.clock.Now(),
+		},
+		oidcSubject: subject,
+	}
+	s.data.users = append(s.data.users, nu)
+	return &nu.User, nil
+}
+
+func (s *Store) GetUserLogin(ctx context.Context, username string) (*model.User, string, error) {
+	defer s.lock(ctx)()
+
+	i := slices.IndexFunc(s.data.users, func(u user) bool { return u.Username == username && u.IsActive })
+	if i < 0 {
+		return nil, "", store.ErrNotFound
+	}
+	u := s.data.users[i]
+	return &u.User, u.passwordHash, nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, id, role string, isActive bool) (*model.User, error) {
+	defer s.lock(ctx)()
+
+	i := s.teamUser(ctx, id)
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	u := &s.data.users[i]
+	u.Role = role
+	u.IsActive = isActive
+	if !isActive {
+		s.endSessions(id)
+	}
+	pu := u.User
+	return &pu, nil
+}
+
+func (s *Store) SetUserPassword(ctx context.Context, id, passwordHash string) error {
+	defer s.lock(ctx)()
+
+	i := s.teamUser(ctx, id)
+	if i < 0 {
+		return store.ErrNotFound
+	}
+	s.data.users[i].passwordHash = passwordHash
+	s.endSessions(id)
+	return nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	if s.teamUser(ctx, id) < 0 {
+		return nil
+	}
+	s.data.users = slices.DeleteFunc(s.data.users, func(u user) bool { return u.ID == id })
+	s.endSessions(id)
+	return nil
+}
+
+func (s *Store) CreateSession(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	defer s.lock(ctx)()
+
+	if s.userIndex(userID) < 0 {
+		return fmt.Errorf("user %s does not exist", userID)
+	}
+	s.data.sessions = append(s.data.sessions, session{tokenHash: tokenHash, userID: userID, expiresAt: expiresAt})
+	return nil
+}
+
+func (s *Store) GetSessionUser(ctx context.Context, tokenHash string) (*model.User, error) {
+	defer s.lock(ctx)()
+
+	now := s.clock.Now()
+	i := slices.IndexFunc(s.data.sessions, func(se session) bool { return se.tokenHash == tokenHash && se.expiresAt.After(now) })
+	if i < 0 {
+		return nil, store.ErrNotFound
+	}
+	u := s.userIndex(s.data.sessions[i].userID)
+	if u < 0 || !s.data.users[u].IsActive {
+		return nil, store.ErrNotFound
+	}
+	pu := s.data.users[u].User
+	return &pu, nil
+}
+
+func (s *Store) DeleteSession(ctx context.Context, tokenHash string) error {
+	defer s.lock(ctx)()
+
+	s.data.sessions = slices.DeleteFunc(s.data.sessions, func(se session) bool { return se.tokenHash == tokenHash })
+	return nil
+}
+
+func (s *Store) PruneSessions(ctx context.Context) (int64, error) {
+	defer s.lock(ctx)()
+
+	now := s.clock.Now()
+	before := len(s.data.sessions)
+	s.data.sessions = slices.DeleteFunc(s.data.sessions, func(se session) bool { return !se.expiresAt.After(now) })
+	return int64(before - len(s.data.sessions)), nil
+}
+
+// --- Audit Events ---
+
+func (s *Store) CreateAuditEvent(ctx context.Context, e *model.AuditEvent) error {
+	defer s.lock(ctx)()
+
+	ne := *e
+	ne.ID = store.NewID()
+	ne.CreatedAt = s.clock.Now()
+	s.data.audit = append(s.data.audit, ne)
+	return nil
+}
+
+func (s *Store) GetAuditEvents(ctx context.Context, f store.AuditFilter) ([]model.AuditEvent, error) {
+	defer s.lock(ctx)()
+
+	var events []model.AuditEvent
+	for _, e := range slices.Backward(s.data.audit) {
+		if len(events) == f.Limit {
+			break
+		}
+		if !store.InTeam(ctx, e.TeamID) ||
+			(f.Actor != "" && e.Actor != f.Actor) ||
+			(f.Action != "" && e.Action != f.Action) ||
+			(f.TargetType != "" && e.TargetType != f.TargetType) ||
+			(f.TargetID != "" && e.TargetID != f.TargetID) ||
+			(f.From != nil && e.CreatedAt.Before(*f.From)) ||
+			(f.To != nil && e.CreatedAt.After(*f.To)) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
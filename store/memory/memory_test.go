@@ -0,0 +1,15 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clk clock.Clock) store.Store {
+		return New(clk)
+	})
+}
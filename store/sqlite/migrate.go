@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/mohsen/alertinGo/migrate"
+)
+
+func (s *Store) Migrate(ctx context.Context) error {
+	ms, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return migrate.Up(ctx, migrator{s}, ms)
+}
+
+func (s *Store) MigrateDown(ctx context.Context, steps int) error {
+	ms, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return migrate.Down(ctx, migrator{s}, ms, steps)
+}
+
+func (s *Store) MigrationStatus(ctx context.Context) ([]migrate.Status, error) {
+	ms, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.GetStatus(ctx, migrator{s}, ms)
+}
+
+func loadMigrations() ([]migrate.Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(sub)
+}
+
+// migrator is the migrate.Driver for SQLite. There is no lock to take: a
+// database file serves a single instance, and each migration runs in an
+// immediate transaction.
+type migrator struct{ s *Store }
+
+func (m migrator) Lock(ctx context.Context) (func(), error) {
+	_, err := m.s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)`)
+	if err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	return func() {}, nil
+}
+
+func (m migrator) Applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.s.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, timeCol{&at}); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Apply follows SQLite's procedure for schema changes: foreign keys are off
+// while a migration rebuilds tables, and checked before it commits.
+func (m migrator) Apply(ctx context.Context, mig migrate.Migration, up bool) error {
+	conn, err := m.s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The pragma is a no-op inside a transaction, so it is set around it
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?1, ?2, ?3)`,
+			mig.Version, mig.Name, ts(m.s.clock.Now()))
+	} else {
+		if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?1`, mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	violated := rows.Next()
+	rows.Close()
+	if violated {
+		return fmt.Errorf("%s leaves rows violating foreign keys", mig)
+	}
+	return tx.Commit()
+}
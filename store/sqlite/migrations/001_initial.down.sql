@@ -0,0 +1,8 @@
+DROP TABLE monitor_dependencies;
+DROP TABLE heartbeats;
+DROP TABLE api_keys;
+DROP TABLE notification_logs;
+DROP TABLE alert_states;
+DROP TABLE threshold_rules;
+DROP TABLE monitors;
+DROP TABLE notification_channels;
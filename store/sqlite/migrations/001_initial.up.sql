@@ -0,0 +1,105 @@
+-- SQLite equivalent of the Postgres migrations 001 to 010. Timestamps are unix
+-- nanoseconds so deadlines can be computed in SQL like the Postgres interval
+-- arithmetic. IF NOT EXISTS keeps databases created before schema_migrations
+-- existed working.
+
+CREATE TABLE IF NOT EXISTS notification_channels (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    telegram_chat_id TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS monitors (
+    id TEXT PRIMARY KEY,
+    monitor_name TEXT NOT NULL,
+    check_type TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    metadata TEXT NOT NULL DEFAULT '{}',
+    timeout INTEGER NOT NULL DEFAULT 60,
+    re_alert_interval INTEGER NOT NULL DEFAULT 300,
+    alert_after_misses INTEGER NOT NULL DEFAULT 1,
+    recover_after_heartbeats INTEGER NOT NULL DEFAULT 1,
+    recovery_heartbeats INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'unknown',
+    is_active INTEGER NOT NULL DEFAULT 0,
+    channel_id TEXT REFERENCES notification_channels(id) ON DELETE SET NULL,
+    server_ip TEXT NOT NULL DEFAULT '',
+    server_name TEXT NOT NULL DEFAULT '',
+    labels TEXT NOT NULL DEFAULT '{}',
+    last_seen_at INTEGER NOT NULL,
+    is_flapping INTEGER NOT NULL DEFAULT 0,
+    flapping_since INTEGER,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    UNIQUE(monitor_name, check_type)
+);
+
+CREATE TABLE IF NOT EXISTS threshold_rules (
+    id TEXT PRIMARY KEY,
+    monitor_id TEXT NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    metric TEXT NOT NULL,
+    operator TEXT NOT NULL,
+    threshold REAL NOT NULL,
+    consecutive INTEGER NOT NULL DEFAULT 1,
+    breach_count INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS alert_states (
+    id TEXT PRIMARY KEY,
+    monitor_id TEXT NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    type TEXT NOT NULL DEFAULT 'heartbeat',
+    rule_id TEXT REFERENCES threshold_rules(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'firing',
+    suppressed INTEGER NOT NULL DEFAULT 0,
+    last_alerted_at INTEGER NOT NULL,
+    fired_at INTEGER NOT NULL,
+    resolved_at INTEGER
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS alert_states_one_firing_heartbeat_idx
+    ON alert_states (monitor_id) WHERE status = 'firing' AND type = 'heartbeat';
+CREATE UNIQUE INDEX IF NOT EXISTS alert_states_one_firing_threshold_idx
+    ON alert_states (rule_id) WHERE status = 'firing' AND type = 'threshold';
+
+CREATE TABLE IF NOT EXISTS notification_logs (
+    id TEXT PRIMARY KEY,
+    monitor_id TEXT NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    channel_id TEXT REFERENCES notification_channels(id) ON DELETE SET NULL,
+    alert_type TEXT NOT NULL,
+    message TEXT NOT NULL,
+    success INTEGER NOT NULL DEFAULT 1,
+    error TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    key_prefix TEXT NOT NULL,
+    is_active INTEGER NOT NULL DEFAULT 1,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS heartbeats (
+    id TEXT PRIMARY KEY,
+    monitor_id TEXT NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    message TEXT NOT NULL DEFAULT '',
+    metadata TEXT NOT NULL DEFAULT '{}',
+    source_ip TEXT NOT NULL DEFAULT '',
+    received_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS heartbeats_monitor_received_at_idx ON heartbeats (monitor_id, received_at DESC);
+
+CREATE TABLE IF NOT EXISTS monitor_dependencies (
+    monitor_id TEXT NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    parent_id TEXT NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (monitor_id, parent_id),
+    CHECK (monitor_id <> parent_id)
+);
+
+CREATE INDEX IF NOT EXISTS monitor_dependencies_parent_id_idx ON monitor_dependencies (parent_id);
@@ -0,0 +1,3 @@
+-- JSON array of scopes. Keys created before scopes only ever authenticated
+-- heartbeats.
+ALTER TABLE api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT '["ingest"]';
@@ -0,0 +1,3 @@
+ALTER TABLE monitors DROP COLUMN created_by_key_id;
+ALTER TABLE api_keys DROP COLUMN check_type_pattern;
+ALTER TABLE api_keys DROP COLUMN monitor_pattern;
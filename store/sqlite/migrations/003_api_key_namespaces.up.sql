@@ -0,0 +1,4 @@
+ALTER TABLE api_keys ADD COLUMN monitor_pattern TEXT NOT NULL DEFAULT '*';
+ALTER TABLE api_keys ADD COLUMN check_type_pattern TEXT NOT NULL DEFAULT '*';
+
+ALTER TABLE monitors ADD COLUMN created_by_key_id TEXT REFERENCES api_keys(id) ON DELETE SET NULL;
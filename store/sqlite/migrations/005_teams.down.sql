@@ -0,0 +1,41 @@
+-- Fails if two teams have a monitor with the same name and check type
+
+CREATE TABLE monitors_old (
+    id TEXT PRIMARY KEY,
+    monitor_name TEXT NOT NULL,
+    check_type TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    metadata TEXT NOT NULL DEFAULT '{}',
+    timeout INTEGER NOT NULL DEFAULT 60,
+    re_alert_interval INTEGER NOT NULL DEFAULT 300,
+    alert_after_misses INTEGER NOT NULL DEFAULT 1,
+    recover_after_heartbeats INTEGER NOT NULL DEFAULT 1,
+    recovery_heartbeats INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'unknown',
+    is_active INTEGER NOT NULL DEFAULT 0,
+    channel_id TEXT REFERENCES notification_channels(id) ON DELETE SET NULL,
+    server_ip TEXT NOT NULL DEFAULT '',
+    server_name TEXT NOT NULL DEFAULT '',
+    labels TEXT NOT NULL DEFAULT '{}',
+    last_seen_at INTEGER NOT NULL,
+    is_flapping INTEGER NOT NULL DEFAULT 0,
+    flapping_since INTEGER,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    created_by_key_id TEXT REFERENCES api_keys(id) ON DELETE SET NULL,
+    UNIQUE(monitor_name, check_type)
+);
+
+INSERT INTO monitors_old (id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, alert_after_misses, recover_after_heartbeats, recovery_heartbeats, status, is_active, channel_id, server_ip, server_name, labels, last_seen_at, is_flapping, flapping_since, created_at, updated_at, created_by_key_id)
+SELECT id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, alert_after_misses, recover_after_heartbeats, recovery_heartbeats, status, is_active, channel_id, server_ip, server_name, labels, last_seen_at, is_flapping, flapping_since, created_at, updated_at, created_by_key_id
+FROM monitors;
+
+DROP TABLE monitors;
+ALTER TABLE monitors_old RENAME TO monitors;
+
+DROP INDEX api_keys_team_id_idx;
+DROP INDEX notification_channels_team_id_idx;
+ALTER TABLE api_keys DROP COLUMN team_id;
+ALTER TABLE notification_channels DROP COLUMN team_id;
+
+DROP TABLE teams;
@@ -0,0 +1,7 @@
+ALTER TABLE monitors DROP COLUMN silenced_by;
+ALTER TABLE monitors DROP COLUMN silenced_until;
+ALTER TABLE alert_states DROP COLUMN acknowledged_by;
+ALTER TABLE alert_states DROP COLUMN acknowledged_at;
+
+DROP TABLE sessions;
+DROP TABLE users;
@@ -0,0 +1,28 @@
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id),
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'responder', 'admin')),
+    is_active INTEGER NOT NULL DEFAULT 1,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX users_team_id_idx ON users (team_id);
+
+-- Like API keys, only the SHA-256 of a session token is stored
+CREATE TABLE sessions (
+    token_hash TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
+
+ALTER TABLE alert_states ADD COLUMN acknowledged_at INTEGER;
+ALTER TABLE alert_states ADD COLUMN acknowledged_by TEXT;
+
+ALTER TABLE monitors ADD COLUMN silenced_until INTEGER;
+ALTER TABLE monitors ADD COLUMN silenced_by TEXT;
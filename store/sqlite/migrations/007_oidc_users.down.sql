@@ -0,0 +1,3 @@
+DROP INDEX users_oidc_subject_idx;
+
+ALTER TABLE users DROP COLUMN oidc_subject;
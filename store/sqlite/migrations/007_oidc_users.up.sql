@@ -0,0 +1,4 @@
+-- The subject identifies an SSO user at the OIDC provider, usernames may change
+ALTER TABLE users ADD COLUMN oidc_subject TEXT;
+
+CREATE UNIQUE INDEX users_oidc_subject_idx ON users (oidc_subject);
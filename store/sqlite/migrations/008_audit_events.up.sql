@@ -0,0 +1,15 @@
+-- target_id has no foreign key, deleted targets keep their history
+CREATE TABLE audit_events (
+    id TEXT PRIMARY KEY,
+    team_id TEXT NOT NULL REFERENCES teams(id),
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    before_state TEXT,
+    after_state TEXT,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX audit_events_team_created_at_idx ON audit_events (team_id, created_at DESC);
+CREATE INDEX audit_events_target_idx ON audit_events (target_type, target_id);
@@ -0,0 +1,1362 @@
+// Package sqlite is a store.Store backed by a single SQLite file, for small
+// deployments that don't want to run Postgres. It only supports one alertinGo
+// instance per database file.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+	_ "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Store is the store.Store implementation backed by SQLite. Transactions start
+// with BEGIN IMMEDIATE and so run one after another, which is what the
+// FOR UPDATE locks of the Postgres store achieve.
+type Store struct {
+	db    *sql.DB
+	clock clock.Clock
+
+	recoveries    store.Broadcast
+	apiKeyChanges store.Broadcast
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open opens (or creates) the database file at path.
+func Open(path string, clk clock.Clock) (*Store, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	dsn := path + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to open database: %w", err)
+	}
+
+	log.Printf("opened sqlite database %s", path)
+	return &Store{db: db, clock: clk}, nil
+}
+
+func (s *Store) Close() {
+	s.db.Close()
+}
+
+// NewElector always leads, see the package documentation.
+func (s *Store) NewElector(name string, lockID int64) store.Elector {
+	return store.SingleInstance{}
+}
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// tx is the transaction started by InTx, along with the monitors it
+// recovered, which are announced once it commits.
+type tx struct {
+	*sql.Tx
+	recovered   []string
+	changedKeys []string
+}
+
+type txKey struct{}
+
+// conn returns the transaction started by InTx for this context, or the pool.
+func (s *Store) conn(ctx context.Context) querier {
+	store.CheckScope(ctx)
+	if t, ok := ctx.Value(txKey{}).(*tx); ok {
+		return t
+	}
+	return s.db
+}
+
+func (s *Store) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	store.CheckScope(ctx)
+	if _, ok := ctx.Value(txKey{}).(*tx); ok {
+		return fn(ctx)
+	}
+
+	sqlTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+
+	t := &tx{Tx: sqlTx}
+	if err := fn(context.WithValue(ctx, txKey{}, t)); err != nil {
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return err
+	}
+	s.recoveries.Publish(t.recovered)
+	s.apiKeyChanges.Publish(t.changedKeys)
+	return nil
+}
+
+// notFound translates sql.ErrNoRows into store.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var sqlErr interface{ Code() int }
+	return errors.As(err, &sqlErr) && sqlErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// teamArg returns the team ctx is scoped to as a query argument, NULL if it
+// isn't scoped.
+func teamArg(ctx context.Context) any {
+	if id := store.TeamID(ctx); id != "" {
+		return id
+	}
+	return nil
+}
+
+// inTeam is a condition matching rows whose column holds the team passed as
+// parameter n, or every row if that parameter is NULL. See teamArg.
+func inTeam(column string, n int) string {
+	return fmt.Sprintf("(?%d IS NULL OR %s = ?%d)", n, column, n)
+}
+
+// monitorInTeam is inTeam for rows owned by the monitor in column.
+func monitorInTeam(column string, n int) string {
+	return fmt.Sprintf("(?%d IS NULL OR %s IN (SELECT id FROM monitors WHERE team_id = ?%d))", n, column, n)
+}
+
+// --- Column types ---
+
+// ts converts a timestamp to the stored unix nanoseconds.
+func ts(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+// nullTs is ts for nullable timestamps.
+func nullTs(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return ts(*t)
+}
+
+// timeCol scans unix nanoseconds into a time.Time.
+type timeCol struct{ t *time.Time }
+
+func (c timeCol) Scan(src any) error {
+	n, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("unexpected timestamp %T", src)
+	}
+	*c.t = time.Unix(0, n).UTC()
+	return nil
+}
+
+// nullTimeCol scans nullable unix nanoseconds into a *time.Time.
+type nullTimeCol struct{ t **time.Time }
+
+func (c nullTimeCol) Scan(src any) error {
+	if src == nil {
+		*c.t = nil
+		return nil
+	}
+	var t time.Time
+	if err := (timeCol{&t}).Scan(src); err != nil {
+		return err
+	}
+	*c.t = &t
+	return nil
+}
+
+// jsonCol scans a JSON column, such as monitor labels, into v.
+type jsonCol struct{ v any }
+
+func (c jsonCol) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unexpected JSON column %T", src)
+	}
+	return json.Unmarshal(b, c.v)
+}
+
+// --- Teams ---
+
+const teamColumns = `id, name, created_at`
+
+func (s *Store) CreateTeam(ctx context.Context, name string) (*model.Team, error) {
+	var t model.Team
+	err := s.conn(ctx).QueryRowContext(ctx, `INSERT INTO teams (id, name, created_at) VALUES (?1, ?2, ?3) RETURNING `+teamColumns,
+		store.NewID(), name, ts(s.clock.Now())).Scan(&t.ID, &t.Name, timeCol{&t.CreatedAt})
+	if isUniqueViolation(err) {
+		return nil, store.ErrTeamExists
+	}
+	return &t, err
+}
+
+func (s *Store) GetAllTeams(ctx context.Context) ([]model.Team, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+teamColumns+` FROM teams WHERE `+inTeam("id", 1)+` ORDER BY name`, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []model.Team
+	for rows.Next() {
+		var t model.Team
+		if err := rows.Scan(&t.ID, &t.Name, timeCol{&t.CreatedAt}); err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+	return teams, rows.Err()
+}
+
+func (s *Store) GetTeamByID(ctx context.Context, id string) (*model.Team, error) {
+	return s.getTeam(ctx, `id = ?1`, id)
+}
+
+func (s *Store) GetTeamByName(ctx context.Context, name string) (*model.Team, error) {
+	return s.getTeam(ctx, `name = ?1`, name)
+}
+
+func (s *Store) getTeam(ctx context.Context, condition string, arg any) (*model.Team, error) {
+	var t model.Team
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT `+teamColumns+` FROM teams WHERE `+condition+` AND `+inTeam("id", 2), arg, teamArg(ctx)).
+		Scan(&t.ID, &t.Name, timeCol{&t.CreatedAt})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &t, nil
+}
+
+// --- Monitors ---
+
+const monitorColumns = `id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, alert_after_misses, recover_after_heartbeats, recovery_heartbeats, status, is_active, channel_id, server_ip, server_name, labels, last_seen_at, is_flapping, flapping_since, created_at, updated_at, created_by_key_id, team_id, silenced_until, silenced_by`
+
+// monitorColumnsAs returns monitorColumns qualified with a table alias, for joins.
+func monitorColumnsAs(alias string) string {
+	return prefixColumns(alias, monitorColumns)
+}
+
+// prefixColumns qualifies a comma-separated column list with a table alias.
+func prefixColumns(alias, columns string) string {
+	cols := strings.Split(columns, ", ")
+	for i, col := range cols {
+		cols[i] = alias + "." + col
+	}
+	return strings.Join(cols, ", ")
+}
+
+// monitorFields returns scan destinations matching monitorColumns.
+func monitorFields(m *model.Monitor) []any {
+	return []any{
+		&m.ID, &m.MonitorName, &m.CheckType, &m.Message, &m.Metadata,
+		&m.Timeout, &m.ReAlertInterval, &m.AlertAfterMisses, &m.RecoverAfterHeartbeats, &m.RecoveryHeartbeats, &m.Status, &m.IsActive, &m.ChannelID,
+		&m.ServerIP, &m.ServerName, jsonCol{&m.Labels}, timeCol{&m.LastSeenAt}, &m.IsFlapping, nullTimeCol{&m.FlappingSince},
+		timeCol{&m.CreatedAt}, timeCol{&m.UpdatedAt}, &m.CreatedByKeyID, &m.TeamID, nullTimeCol{&m.SilencedUntil}, &m.SilencedBy,
+	}
+}
+
+// overdueAt is when a monitor (aliased m) becomes overdue.
+const overdueAt = `(m.last_seen_at + m.timeout * m.alert_after_misses * 1000000000)`
+
+func (s *Store) UpsertMonitor(ctx context.Context, m *model.Monitor) (*model.Monitor, error) {
+	labels, err := json.Marshal(m.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO monitors (id, monitor_name, check_type, message, metadata, timeout, re_alert_interval, alert_after_misses, recover_after_heartbeats, server_ip, server_name, labels, last_seen_at, created_at, updated_at, created_by_key_id, team_id)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?13, ?13, ?14, ?15)
+		ON CONFLICT (team_id, monitor_name, check_type)
+		DO UPDATE SET
+			message = excluded.message,
+			metadata = excluded.metadata,
+			timeout = excluded.timeout,
+			re_alert_interval = excluded.re_alert_interval,
+			alert_after_misses = excluded.alert_after_misses,
+			recover_after_heartbeats = excluded.recover_after_heartbeats,
+			server_ip = excluded.server_ip,
+			server_name = excluded.server_name,
+			labels = excluded.labels,
+			last_seen_at = ?13,
+			updated_at = ?13,
+			-- a down monitor only comes back up after recover_after_heartbeats heartbeats in a row
+			status = CASE
+				WHEN monitors.status = 'down' AND monitors.recovery_heartbeats + 1 < excluded.recover_after_heartbeats THEN 'down'
+				ELSE 'up'
+			END,
+			recovery_heartbeats = CASE
+				WHEN monitors.status = 'down' AND monitors.recovery_heartbeats + 1 < excluded.recover_after_heartbeats THEN monitors.recovery_heartbeats + 1
+				ELSE 0
+			END
+		RETURNING ` + monitorColumns
+
+	team := store.OwnerTeam(ctx)
+	var mon model.Monitor
+	err = s.InTx(ctx, func(ctx context.Context) error {
+		// There is no trigger to announce recoveries, compare with the previous status instead
+		var prev string
+		err := s.conn(ctx).QueryRowContext(ctx, `SELECT status FROM monitors WHERE team_id = ?1 AND monitor_name = ?2 AND check_type = ?3`,
+			team, m.MonitorName, m.CheckType).Scan(&prev)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		err = s.conn(ctx).QueryRowContext(ctx, query,
+			store.NewID(), m.MonitorName, m.CheckType, m.Message, m.Metadata,
+			m.Timeout, m.ReAlertInterval, m.AlertAfterMisses, m.RecoverAfterHeartbeats,
+			m.ServerIP, m.ServerName, string(labels), ts(s.clock.Now()), m.CreatedByKeyID, team,
+		).Scan(monitorFields(&mon)...)
+		if err != nil {
+			return err
+		}
+
+		if prev == "down" && mon.Status == "up" {
+			t := ctx.Value(txKey{}).(*tx)
+			t.recovered = append(t.recovered, mon.ID)
+		}
+		return nil
+	})
+	return &mon, err
+}
+
+func (s *Store) GetAllMonitors(ctx context.Context) ([]model.Monitor, error) {
+	return s.queryMonitors(ctx, `SELECT `+monitorColumns+` FROM monitors WHERE `+inTeam("team_id", 1)+` ORDER BY created_at DESC`, teamArg(ctx))
+}
+
+func (s *Store) queryMonitors(ctx context.Context, query string, args ...any) ([]model.Monitor, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var monitors []model.Monitor
+	for rows.Next() {
+		var m model.Monitor
+		if err := rows.Scan(monitorFields(&m)...); err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, m)
+	}
+	return monitors, rows.Err()
+}
+
+func (s *Store) GetMonitorByID(ctx context.Context, id string) (*model.Monitor, error) {
+	var m model.Monitor
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT `+monitorColumns+` FROM monitors WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx)).
+		Scan(monitorFields(&m)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+func (s *Store) UpdateMonitor(ctx context.Context, id string, isActive bool, channelID *string) (*model.Monitor, error) {
+	query := `UPDATE monitors SET is_active = ?1, channel_id = ?2, updated_at = ?4
+		WHERE id = ?3 AND ` + inTeam("team_id", 5) + `
+		  AND (?2 IS NULL OR EXISTS (SELECT 1 FROM notification_channels c WHERE c.id = ?2 AND c.team_id = monitors.team_id))
+		RETURNING ` + monitorColumns
+
+	var m model.Monitor
+	err := s.conn(ctx).QueryRowContext(ctx, query, isActive, channelID, id, ts(s.clock.Now()), teamArg(ctx)).Scan(monitorFields(&m)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+func (s *Store) DeleteMonitor(ctx context.Context, id string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM monitors WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
+	return err
+}
+
+func (s *Store) SilenceMonitor(ctx context.Context, id string, until *time.Time, by *string) (*model.Monitor, error) {
+	query := `UPDATE monitors SET silenced_until = ?2, silenced_by = ?3, updated_at = ?4
+		WHERE id = ?1 AND ` + inTeam("team_id", 5) + ` RETURNING ` + monitorColumns
+
+	var m model.Monitor
+	err := s.conn(ctx).QueryRowContext(ctx, query, id, nullTs(until), by, ts(s.clock.Now()), teamArg(ctx)).Scan(monitorFields(&m)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+func (s *Store) SetMonitorStatus(ctx context.Context, id string, status string) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE monitors SET status = ?1, recovery_heartbeats = 0, updated_at = ?3 WHERE id = ?2 AND `+inTeam("team_id", 4),
+		status, id, ts(s.clock.Now()), teamArg(ctx))
+	return err
+}
+
+// --- Heartbeat History ---
+
+func (s *Store) CreateHeartbeat(ctx context.Context, monitorID, message, metadata, sourceIP string) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO heartbeats (id, monitor_id, message, metadata, source_ip, received_at)
+		SELECT ?1, id, ?3, ?4, ?5, ?6 FROM monitors WHERE id = ?2 AND `+inTeam("team_id", 7),
+		store.NewID(), monitorID, message, metadata, sourceIP, ts(s.clock.Now()), teamArg(ctx))
+	return err
+}
+
+func (s *Store) GetHeartbeats(ctx context.Context, monitorID string, from, to *time.Time, limit int) ([]model.Heartbeat, error) {
+	var fromTS, toTS *int64
+	if from != nil {
+		v := ts(*from)
+		fromTS = &v
+	}
+	if to != nil {
+		v := ts(*to)
+		toTS = &v
+	}
+
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT id, monitor_id, message, metadata, source_ip, received_at FROM heartbeats
+		WHERE monitor_id = ?1
+		  AND (?2 IS NULL OR received_at >= ?2)
+		  AND (?3 IS NULL OR received_at <= ?3)
+		  AND `+monitorInTeam("monitor_id", 5)+`
+		ORDER BY received_at DESC LIMIT ?4`,
+		monitorID, fromTS, toTS, limit, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var heartbeats []model.Heartbeat
+	for rows.Next() {
+		var h model.Heartbeat
+		if err := rows.Scan(&h.ID, &h.MonitorID, &h.Message, &h.Metadata, &h.SourceIP, timeCol{&h.ReceivedAt}); err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, rows.Err()
+}
+
+func (s *Store) PruneHeartbeats(ctx context.Context, maxAge time.Duration, maxPerMonitor int) (int64, error) {
+	var deleted int64
+
+	if maxAge > 0 {
+		res, err := s.conn(ctx).ExecContext(ctx,
+			`DELETE FROM heartbeats WHERE received_at < ?1`, ts(s.clock.Now().Add(-maxAge)))
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	if maxPerMonitor > 0 {
+		res, err := s.conn(ctx).ExecContext(ctx, `
+			DELETE FROM heartbeats WHERE id IN (
+				SELECT id FROM (
+					SELECT id, row_number() OVER (PARTITION BY monitor_id ORDER BY received_at DESC) AS rn
+					FROM heartbeats
+				) ranked WHERE ranked.rn > ?1
+			)`, maxPerMonitor)
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// --- Watched monitors ---
+
+func (s *Store) GetOverdueMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		WHERE m.is_active = 1 AND ` + inTeam("m.team_id", 2) + `
+		  AND ` + overdueAt + ` < ?1`
+
+	return s.queryOverdueMonitors(ctx, query, ts(s.clock.Now()), teamArg(ctx))
+}
+
+func (s *Store) LockOverdueMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return s.lockMonitor(ctx, id, overdueAt+` < ?2`, ts(s.clock.Now()))
+}
+
+// nextCheckAt is when the watcher next needs to look at a monitor: when it
+// becomes overdue, or when a firing alert is due for a re-alert.
+const nextCheckAt = `CASE
+		WHEN a.id IS NULL THEN ` + overdueAt + `
+		ELSE max(` + overdueAt + `, a.last_alerted_at + m.re_alert_interval * 1000000000)
+	END`
+
+func (s *Store) GetMonitorDeadlines(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `
+		SELECT m.id, `+nextCheckAt+`
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		LEFT JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing'
+		WHERE m.is_active = 1 AND `+inTeam("m.team_id", 1), teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadlines := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var at time.Time
+		if err := rows.Scan(&id, timeCol{&at}); err != nil {
+			return nil, err
+		}
+		deadlines[id] = at
+	}
+	return deadlines, rows.Err()
+}
+
+func (s *Store) GetMonitorDeadline(ctx context.Context, id string) (time.Time, error) {
+	var at time.Time
+	err := s.conn(ctx).QueryRowContext(ctx, `
+		SELECT `+nextCheckAt+`
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		LEFT JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing'
+		WHERE m.id = ?1 AND m.is_active = 1 AND `+inTeam("m.team_id", 2), id, teamArg(ctx)).Scan(timeCol{&at})
+	return at, notFound(err)
+}
+
+func (s *Store) GetRecoveredMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing'
+		WHERE m.is_active = 1 AND ` + inTeam("m.team_id", 1) + `
+		  AND m.status = 'up'`
+
+	return s.queryOverdueMonitors(ctx, query, teamArg(ctx))
+}
+
+func (s *Store) LockRecoveredMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return s.lockMonitor(ctx, id, `m.status = 'up' AND EXISTS (
+		SELECT 1 FROM alert_states a WHERE a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing')`)
+}
+
+// ListenRecoveries is fed by UpsertMonitor within this process.
+func (s *Store) ListenRecoveries(ctx context.Context, fn func(monitorID string)) error {
+	return s.recoveries.Listen(ctx, nil, fn)
+}
+
+// ListenDeadlineChanges never calls fn, a SQLite store has a single instance and
+// it moves its deadlines itself.
+func (s *Store) ListenDeadlineChanges(ctx context.Context, fn func(monitorID string)) error {
+	store.CheckScope(ctx)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *Store) GetFlappingMonitors(ctx context.Context) ([]store.OverdueMonitor, error) {
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		WHERE m.is_active = 1 AND ` + inTeam("m.team_id", 1) + `
+		  AND m.is_flapping = 1`
+
+	return s.queryOverdueMonitors(ctx, query, teamArg(ctx))
+}
+
+func (s *Store) LockFlappingMonitor(ctx context.Context, id string) (*store.OverdueMonitor, error) {
+	return s.lockMonitor(ctx, id, `m.is_flapping = 1`)
+}
+
+func (s *Store) SetMonitorFlapping(ctx context.Context, id string, flapping bool) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE monitors SET is_flapping = ?1, flapping_since = CASE WHEN ?1 THEN ?3 END, updated_at = ?3
+		WHERE id = ?2 AND `+inTeam("team_id", 4),
+		flapping, id, ts(s.clock.Now()), teamArg(ctx))
+	return err
+}
+
+func (s *Store) CountStateTransitions(ctx context.Context, monitorID string, window time.Duration) (int, error) {
+	var count int
+	err := s.conn(ctx).QueryRowContext(ctx, `
+		SELECT count(*) FILTER (WHERE fired_at > ?2)
+			+ count(*) FILTER (WHERE resolved_at > ?2)
+		FROM alert_states
+		WHERE monitor_id = ?1 AND type = 'heartbeat' AND `+monitorInTeam("monitor_id", 3),
+		monitorID, ts(s.clock.Now().Add(-window)), teamArg(ctx)).Scan(&count)
+	return count, err
+}
+
+// lockMonitor fetches a watched monitor matching condition, whose parameters
+// start at ?2. Inside InTx the database is already locked for writing, so the
+// row cannot change under us.
+func (s *Store) lockMonitor(ctx context.Context, id, condition string, args ...any) (*store.OverdueMonitor, error) {
+	team := len(args) + 2
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitors m
+		JOIN notification_channels c ON m.channel_id = c.id
+		WHERE m.id = ?1 AND m.is_active = 1 AND ` + inTeam("m.team_id", team) + ` AND ` + condition
+
+	args = append(append([]any{id}, args...), teamArg(ctx))
+	var om store.OverdueMonitor
+	if err := s.conn(ctx).QueryRowContext(ctx, query, args...).Scan(append(monitorFields(&om.Monitor), &om.TelegramChatID)...); err != nil {
+		return nil, notFound(err)
+	}
+	return &om, nil
+}
+
+func (s *Store) queryOverdueMonitors(ctx context.Context, query string, args ...any) ([]store.OverdueMonitor, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []store.OverdueMonitor
+	for rows.Next() {
+		var om store.OverdueMonitor
+		if err := rows.Scan(append(monitorFields(&om.Monitor), &om.TelegramChatID)...); err != nil {
+			return nil, err
+		}
+		result = append(result, om)
+	}
+	return result, rows.Err()
+}
+
+// --- Alert States ---
+
+const alertColumns = `id, monitor_id, type, rule_id, status, suppressed, last_alerted_at, fired_at, resolved_at, acknowledged_at, acknowledged_by`
+
+func alertFields(a *model.AlertState) []any {
+	return []any{&a.ID, &a.MonitorID, &a.Type, &a.RuleID, &a.Status, &a.Suppressed,
+		timeCol{&a.LastAlertedAt}, timeCol{&a.FiredAt}, nullTimeCol{&a.ResolvedAt}, nullTimeCol{&a.AcknowledgedAt}, &a.AcknowledgedBy}
+}
+
+func (s *Store) GetFiringAlert(ctx context.Context, monitorID string) (*model.AlertState, error) {
+	var a model.AlertState
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+alertColumns+` FROM alert_states WHERE monitor_id = ?1 AND type = 'heartbeat' AND status = 'firing' AND `+monitorInTeam("monitor_id", 2),
+		monitorID, teamArg(ctx)).
+		Scan(alertFields(&a)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &a, nil
+}
+
+func (s *Store) GetFiringThresholdAlert(ctx context.Context, ruleID string) (*model.AlertState, error) {
+	var a model.AlertState
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+alertColumns+` FROM alert_states WHERE rule_id = ?1 AND type = 'threshold' AND status = 'firing' AND `+monitorInTeam("monitor_id", 2),
+		ruleID, teamArg(ctx)).
+		Scan(alertFields(&a)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &a, nil
+}
+
+func (s *Store) GetFiringAlerts(ctx context.Context, monitorID string) ([]model.AlertState, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+alertColumns+` FROM alert_states
+		WHERE monitor_id = ?1 AND status = 'firing' AND `+monitorInTeam("monitor_id", 2)+`
+		ORDER BY fired_at`, monitorID, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []model.AlertState
+	for rows.Next() {
+		var a model.AlertState
+		if err := rows.Scan(alertFields(&a)...); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+func (s *Store) AcknowledgeAlerts(ctx context.Context, monitorID, by string) (int64, error) {
+	res, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE alert_states SET acknowledged_at = ?2, acknowledged_by = ?3
+		WHERE monitor_id = ?1 AND status = 'firing' AND acknowledged_at IS NULL AND `+monitorInTeam("monitor_id", 4),
+		monitorID, ts(s.clock.Now()), by, teamArg(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) CreateAlertState(ctx context.Context, monitorID string, suppressed bool) (bool, error) {
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO alert_states (id, monitor_id, status, suppressed, last_alerted_at, fired_at)
+		SELECT ?1, id, 'firing', ?3, ?4, ?4 FROM monitors WHERE id = ?2 AND `+inTeam("team_id", 5)+`
+		ON CONFLICT (monitor_id) WHERE status = 'firing' AND type = 'heartbeat' DO NOTHING`,
+		store.NewID(), monitorID, suppressed, ts(s.clock.Now()), teamArg(ctx))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *Store) UnsuppressAlert(ctx context.Context, alertID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE alert_states SET suppressed = 0, last_alerted_at = ?2 WHERE id = ?1 AND `+monitorInTeam("monitor_id", 3),
+		alertID, ts(s.clock.Now()), teamArg(ctx))
+	return err
+}
+
+func (s *Store) CreateThresholdAlertState(ctx context.Context, monitorID, ruleID string) (bool, error) {
+	res, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO alert_states (id, monitor_id, type, rule_id, status, last_alerted_at, fired_at)
+		SELECT ?1, id, 'threshold', ?3, 'firing', ?4, ?4 FROM monitors WHERE id = ?2 AND `+inTeam("team_id", 5)+`
+		ON CONFLICT (rule_id) WHERE status = 'firing' AND type = 'threshold' DO NOTHING`,
+		store.NewID(), monitorID, ruleID, ts(s.clock.Now()), teamArg(ctx))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *Store) UpdateAlertLastAlerted(ctx context.Context, alertID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE alert_states SET last_alerted_at = ?2 WHERE id = ?1 AND `+monitorInTeam("monitor_id", 3),
+		alertID, ts(s.clock.Now()), teamArg(ctx))
+	return err
+}
+
+func (s *Store) ResolveAlert(ctx context.Context, alertID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE alert_states SET status = 'resolved', resolved_at = ?2 WHERE id = ?1 AND `+monitorInTeam("monitor_id", 3),
+		alertID, ts(s.clock.Now()), teamArg(ctx))
+	return err
+}
+
+// --- Monitor Dependencies ---
+
+func (s *Store) GetMonitorParents(ctx context.Context, monitorID string) ([]model.Monitor, error) {
+	query := `SELECT ` + monitorColumnsAs("m") + `
+		FROM monitor_dependencies d
+		JOIN monitors m ON m.id = d.parent_id
+		WHERE d.monitor_id = ?1 AND ` + inTeam("m.team_id", 2) + `
+		ORDER BY d.created_at`
+
+	return s.queryMonitors(ctx, query, monitorID, teamArg(ctx))
+}
+
+func (s *Store) AddMonitorParent(ctx context.Context, monitorID, parentID string) error {
+	var found, cycle bool
+	err := s.conn(ctx).QueryRowContext(ctx, `
+		WITH RECURSIVE ancestors(id) AS (
+			SELECT ?2
+			UNION
+			SELECT d.parent_id FROM monitor_dependencies d JOIN ancestors a ON d.monitor_id = a.id
+		)
+		SELECT EXISTS (
+				SELECT 1 FROM monitors c JOIN monitors p ON p.team_id = c.team_id
+				WHERE c.id = ?1 AND p.id = ?2 AND `+inTeam("c.team_id", 3)+`
+			),
+			EXISTS (SELECT 1 FROM ancestors WHERE id = ?1)`,
+		monitorID, parentID, teamArg(ctx)).Scan(&found, &cycle)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return store.ErrNotFound
+	}
+	if cycle {
+		return store.ErrDependencyCycle
+	}
+
+	_, err = s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO monitor_dependencies (monitor_id, parent_id, created_at) VALUES (?1, ?2, ?3) ON CONFLICT DO NOTHING`,
+		monitorID, parentID, ts(s.clock.Now()))
+	return err
+}
+
+func (s *Store) RemoveMonitorParent(ctx context.Context, monitorID, parentID string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM monitor_dependencies WHERE monitor_id = ?1 AND parent_id = ?2 AND `+monitorInTeam("monitor_id", 3),
+		monitorID, parentID, teamArg(ctx))
+	return err
+}
+
+func (s *Store) HasDownParent(ctx context.Context, monitorID string) (bool, error) {
+	var down bool
+	err := s.conn(ctx).QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM monitor_dependencies d
+			JOIN monitors m ON m.id = d.parent_id
+			WHERE d.monitor_id = ?1 AND m.is_active = 1 AND `+inTeam("m.team_id", 3)+`
+			  AND (m.status = 'down' OR `+overdueAt+` < ?2)
+		)`, monitorID, ts(s.clock.Now()), teamArg(ctx)).Scan(&down)
+	return down, err
+}
+
+func (s *Store) GetDownChildren(ctx context.Context, parentID string) ([]model.Monitor, error) {
+	query := `SELECT ` + monitorColumnsAs("m") + `
+		FROM monitor_dependencies d
+		JOIN monitors m ON m.id = d.monitor_id
+		WHERE d.parent_id = ?1 AND m.is_active = 1 AND ` + inTeam("m.team_id", 3) + `
+		  AND (m.status = 'down' OR ` + overdueAt + ` < ?2)
+		ORDER BY m.monitor_name, m.check_type`
+
+	return s.queryMonitors(ctx, query, parentID, ts(s.clock.Now()), teamArg(ctx))
+}
+
+func (s *Store) GetSuppressedChildren(ctx context.Context, parentID string) ([]store.OverdueMonitor, error) {
+	query := `
+		SELECT ` + monitorColumnsAs("m") + `, c.telegram_chat_id
+		FROM monitor_dependencies d
+		JOIN monitors m ON m.id = d.monitor_id
+		JOIN notification_channels c ON m.channel_id = c.id
+		JOIN alert_states a ON a.monitor_id = m.id AND a.type = 'heartbeat' AND a.status = 'firing' AND a.suppressed = 1
+		WHERE d.parent_id = ?1 AND m.is_active = 1 AND ` + inTeam("m.team_id", 2)
+
+	return s.queryOverdueMonitors(ctx, query, parentID, teamArg(ctx))
+}
+
+// --- Threshold Rules ---
+
+const ruleColumns = `id, monitor_id, metric, operator, threshold, consecutive, breach_count, created_at`
+
+func ruleFields(r *model.ThresholdRule) []any {
+	return []any{&r.ID, &r.MonitorID, &r.Metric, &r.Operator, &r.Threshold, &r.Consecutive, &r.BreachCount, timeCol{&r.CreatedAt}}
+}
+
+func (s *Store) CreateThresholdRule(ctx context.Context, r *model.ThresholdRule) (*model.ThresholdRule, error) {
+	query := `INSERT INTO threshold_rules (id, monitor_id, metric, operator, threshold, consecutive, created_at)
+		SELECT ?1, id, ?3, ?4, ?5, ?6, ?7 FROM monitors WHERE id = ?2 AND ` + inTeam("team_id", 8) + `
+		RETURNING ` + ruleColumns
+
+	var rule model.ThresholdRule
+	err := s.conn(ctx).QueryRowContext(ctx, query,
+		store.NewID(), r.MonitorID, r.Metric, r.Operator, r.Threshold, r.Consecutive, ts(s.clock.Now()), teamArg(ctx)).Scan(ruleFields(&rule)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &rule, nil
+}
+
+func (s *Store) GetThresholdRules(ctx context.Context, monitorID string) ([]model.ThresholdRule, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT `+ruleColumns+` FROM threshold_rules WHERE monitor_id = ?1 AND `+monitorInTeam("monitor_id", 2)+` ORDER BY created_at`,
+		monitorID, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []model.ThresholdRule
+	for rows.Next() {
+		var r model.ThresholdRule
+		if err := rows.Scan(ruleFields(&r)...); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Store) LockThresholdRule(ctx context.Context, id string) (*model.ThresholdRule, error) {
+	var r model.ThresholdRule
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT `+ruleColumns+` FROM threshold_rules WHERE id = ?1 AND `+monitorInTeam("monitor_id", 2), id, teamArg(ctx)).Scan(ruleFields(&r)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+func (s *Store) SetRuleBreachCount(ctx context.Context, id string, count int) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `UPDATE threshold_rules SET breach_count = ?1 WHERE id = ?2 AND `+monitorInTeam("monitor_id", 3),
+		count, id, teamArg(ctx))
+	return err
+}
+
+func (s *Store) DeleteThresholdRule(ctx context.Context, monitorID, id string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM threshold_rules WHERE id = ?1 AND monitor_id = ?2 AND `+monitorInTeam("monitor_id", 3),
+		id, monitorID, teamArg(ctx))
+	return err
+}
+
+// --- Notification Channels ---
+
+const channelColumns = `id, team_id, name, telegram_chat_id, created_at`
+
+// channelFields returns scan destinations matching channelColumns.
+func channelFields(ch *model.NotificationChannel) []any {
+	return []any{&ch.ID, &ch.TeamID, &ch.Name, &ch.TelegramChatID, timeCol{&ch.CreatedAt}}
+}
+
+func (s *Store) CreateChannel(ctx context.Context, name, telegramChatID string) (*model.NotificationChannel, error) {
+	query := `INSERT INTO notification_channels (id, team_id, name, telegram_chat_id, created_at) VALUES (?1, ?2, ?3, ?4, ?5)
+		RETURNING ` + channelColumns
+
+	var ch model.NotificationChannel
+	err := s.conn(ctx).QueryRowContext(ctx, query, store.NewID(), store.OwnerTeam(ctx), name, telegramChatID, ts(s.clock.Now())).
+		Scan(channelFields(&ch)...)
+	return &ch, err
+}
+
+func (s *Store) GetChannelByID(ctx context.Context, id string) (*model.NotificationChannel, error) {
+	var ch model.NotificationChannel
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT `+channelColumns+` FROM notification_channels WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx)).
+		Scan(channelFields(&ch)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &ch, nil
+}
+
+func (s *Store) GetAllChannels(ctx context.Context) ([]model.NotificationChannel, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+channelColumns+` FROM notification_channels WHERE `+inTeam("team_id", 1)+` ORDER BY created_at DESC`, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []model.NotificationChannel
+	for rows.Next() {
+		var ch model.NotificationChannel
+		if err := rows.Scan(channelFields(&ch)...); err != nil {
+			return nil, err
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+func (s *Store) DeleteChannel(ctx context.Context, id string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM notification_channels WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
+	return err
+}
+
+// --- Notification Logs ---
+
+func (s *Store) CreateNotificationLog(ctx context.Context, monitorID string, channelID *string, alertType, message string, success bool, errMsg string) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO notification_logs (id, monitor_id, channel_id, alert_type, message, success, error, created_at) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)`,
+		store.NewID(), monitorID, channelID, alertType, message, success, errMsg, ts(s.clock.Now()))
+	return err
+}
+
+func (s *Store) GetNotificationLogs(ctx context.Context) ([]model.NotificationLog, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		`SELECT id, monitor_id, channel_id, alert_type, message, success, error, created_at FROM notification_logs
+		WHERE `+monitorInTeam("monitor_id", 1)+` ORDER BY created_at DESC LIMIT 100`, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []model.NotificationLog
+	for rows.Next() {
+		var l model.NotificationLog
+		if err := rows.Scan(&l.ID, &l.MonitorID, &l.ChannelID, &l.AlertType, &l.Message, &l.Success, &l.Error, timeCol{&l.CreatedAt}); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+// --- API Keys ---
+
+const apiKeyColumns = `id, name, key_prefix, scopes, monitor_pattern, check_type_pattern, is_active, expires_at, last_used_at, last_used_ip, created_at, team_id`
+
+// apiKeyFields returns scan destinations matching apiKeyColumns.
+func apiKeyFields(k *model.ApiKey) []any {
+	return []any{
+		&k.ID, &k.Name, &k.KeyPrefix, jsonCol{&k.Scopes}, &k.MonitorPattern, &k.CheckTypePattern,
+		&k.IsActive, nullTimeCol{&k.ExpiresAt}, nullTimeCol{&k.LastUsedAt}, &k.LastUsedIP, timeCol{&k.CreatedAt}, &k.TeamID,
+	}
+}
+
+func (s *Store) CreateApiKey(ctx context.Context, k *model.ApiKey, keyHash string) (*model.ApiKey, error) {
+	scopes, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO api_keys (id, name, key_hash, key_prefix, scopes, monitor_pattern, check_type_pattern, expires_at, created_at, team_id)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10) RETURNING ` + apiKeyColumns
+
+	var key model.ApiKey
+	err = s.conn(ctx).QueryRowContext(ctx, query,
+		store.NewID(), k.Name, keyHash, k.KeyPrefix, string(scopes), k.MonitorPattern, k.CheckTypePattern,
+		nullTs(k.ExpiresAt), ts(s.clock.Now()), store.OwnerTeam(ctx),
+	).Scan(apiKeyFields(&key)...)
+	return &key, err
+}
+
+func (s *Store) GetAllApiKeys(ctx context.Context) ([]model.ApiKey, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE `+inTeam("team_id", 1)+` ORDER BY created_at DESC`, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []model.ApiKey
+	for rows.Next() {
+		var k model.ApiKey
+		if err := rows.Scan(apiKeyFields(&k)...); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *Store) GetApiKeyByID(ctx context.Context, id string) (*model.ApiKey, error) {
+	var k model.ApiKey
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx)).
+		Scan(apiKeyFields(&k)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &k, nil
+}
+
+func (s *Store) UpdateApiKey(ctx context.Context, k *model.ApiKey) (*model.ApiKey, error) {
+	scopes, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE api_keys SET name = ?2, is_active = ?3, scopes = ?4, monitor_pattern = ?5, check_type_pattern = ?6, expires_at = ?7
+		WHERE id = ?1 AND ` + inTeam("team_id", 8) + ` RETURNING ` + apiKeyColumns
+
+	var key model.ApiKey
+	err = s.conn(ctx).QueryRowContext(ctx, query,
+		k.ID, k.Name, k.IsActive, string(scopes), k.MonitorPattern, k.CheckTypePattern, nullTs(k.ExpiresAt), teamArg(ctx),
+	).Scan(apiKeyFields(&key)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	s.apiKeyChanged(ctx, k.ID)
+	return &key, nil
+}
+
+func (s *Store) DeleteApiKey(ctx context.Context, id string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM api_keys WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
+	if err == nil {
+		s.apiKeyChanged(ctx, id)
+	}
+	return err
+}
+
+func (s *Store) RevokeApiKey(ctx context.Context, id string) error {
+	res, err := s.conn(ctx).ExecContext(ctx, `UPDATE api_keys SET is_active = 0 WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
+	if err = affectedOne(res, err); err == nil {
+		s.apiKeyChanged(ctx, id)
+	}
+	return err
+}
+
+func (s *Store) ExpireApiKey(ctx context.Context, id string, at time.Time) error {
+	res, err := s.conn(ctx).ExecContext(ctx, `
+		UPDATE api_keys SET expires_at = CASE WHEN expires_at IS NULL OR expires_at > ?2 THEN ?2 ELSE expires_at END
+		WHERE id = ?1 AND `+inTeam("team_id", 3), id, ts(at), teamArg(ctx))
+	if err = affectedOne(res, err); err == nil {
+		s.apiKeyChanged(ctx, id)
+	}
+	return err
+}
+
+// apiKeyChanged announces a changed key once the change is committed.
+func (s *Store) apiKeyChanged(ctx context.Context, id string) {
+	if t, ok := ctx.Value(txKey{}).(*tx); ok {
+		t.changedKeys = append(t.changedKeys, id)
+		return
+	}
+	s.apiKeyChanges.Publish([]string{id})
+}
+
+// ListenApiKeyChanges is fed by the key updates within this process.
+func (s *Store) ListenApiKeyChanges(ctx context.Context, ready func(), fn func(keyID string)) error {
+	return s.apiKeyChanges.Listen(ctx, ready, fn)
+}
+
+// affectedOne returns store.ErrNotFound if an update matched no row.
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *Store) GetActiveApiKey(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE key_hash = ?1 AND is_active = 1 AND (expires_at IS NULL OR expires_at > ?2)`
+
+	var k model.ApiKey
+	err := s.conn(ctx).QueryRowContext(ctx, query, keyHash, ts(s.clock.Now())).Scan(apiKeyFields(&k)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &k, nil
+}
+
+func (s *Store) RecordApiKeyUses(ctx context.Context, uses []store.ApiKeyUse) error {
+	return s.InTx(ctx, func(ctx context.Context) error {
+		for _, u := range uses {
+			_, err := s.conn(ctx).ExecContext(ctx, `
+				UPDATE api_keys SET last_used_at = ?2, last_used_ip = ?3
+				WHERE id = ?1 AND (last_used_at IS NULL OR last_used_at < ?2)`,
+				u.KeyID, ts(u.At), u.IP)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// --- Users ---
+
+const userColumns = `id, team_id, username, role, is_active, oidc_subject IS NOT NULL, created_at`
+
+// userFields returns scan destinations matching userColumns.
+func userFields(u *model.User) []any {
+	return []any{&u.ID, &u.TeamID, &u.Username, &u.Role, &u.IsActive, &u.SSO, timeCol{&u.CreatedAt}}
+}
+
+func (s *Store) CreateUser(ctx context.Context, u *model.User, passwordHash string) (*model.User, error) {
+	query := `INSERT INTO users (id, team_id, username, password_hash, role, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6) RETURNING ` + userColumns
+
+	var user model.User
+	err := s.conn(ctx).QueryRowContext(ctx, query, store.NewID(), store.OwnerTeam(ctx), u.Username, passwordHash, u.Role, ts(s.clock.Now())).
+		Scan(userFields(&user)...)
+	if isUniqueViolation(err) {
+		return nil, store.ErrUserExists
+	}
+	return &user, err
+}
+
+func (s *Store) GetAllUsers(ctx context.Context) ([]model.User, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE `+inTeam("team_id", 1)+` ORDER BY username`, teamArg(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []model.User
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(userFields(&u)...); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id string) (*model.User, error) {
+	var u model.User
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx)).
+		Scan(userFields(&u)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (s *Store) UpsertSSOUser(ctx context.Context, u *model.User, subject string) (*model.User, error) {
+	query := `INSERT INTO users (id, team_id, username, password_hash, role, oidc_subject, created_at)
+		VALUES (?1, ?2, ?3, '', ?4, ?5, ?6)
+		ON CONFLICT (oidc_subject) DO UPDATE SET username = excluded.username, role = excluded.role
+		RETURNING ` + userColumns
+
+	var user model.User
+	err := s.conn(ctx).QueryRowContext(ctx, query, store.NewID(), store.OwnerTeam(ctx), u.Username, u.Role, subject, ts(s.clock.Now())).
+		Scan(userFields(&user)...)
+	if isUniqueViolation(err) {
+		return nil, store.ErrUserExists
+	}
+	return &user, err
+}
+
+func (s *Store) GetUserLogin(ctx context.Context, username string) (*model.User, string, error) {
+	var u model.User
+	var hash string
+	err := s.conn(ctx).QueryRowContext(ctx, `SELECT `+userColumns+`, password_hash FROM users WHERE username = ?1 AND is_active = 1`, username).
+		Scan(append(userFields(&u), &hash)...)
+	if err != nil {
+		return nil, "", notFound(err)
+	}
+	return &u, hash, nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, id, role string, isActive bool) (*model.User, error) {
+	var u model.User
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		query := `UPDATE users SET role = ?2, is_active = ?3 WHERE id = ?1 AND ` + inTeam("team_id", 4) + ` RETURNING ` + userColumns
+		if err := s.conn(ctx).QueryRowContext(ctx, query, id, role, isActive, teamArg(ctx)).Scan(userFields(&u)...); err != nil {
+			return notFound(err)
+		}
+		if isActive {
+			return nil
+		}
+		_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?1`, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *Store) SetUserPassword(ctx context.Context, id, passwordHash string) error {
+	return s.InTx(ctx, func(ctx context.Context) error {
+		res, err := s.conn(ctx).ExecContext(ctx, `UPDATE users SET password_hash = ?2 WHERE id = ?1 AND `+inTeam("team_id", 3), id, passwordHash, teamArg(ctx))
+		if err := affectedOne(res, err); err != nil {
+			return err
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?1`, id)
+		return err
+	})
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM users WHERE id = ?1 AND `+inTeam("team_id", 2), id, teamArg(ctx))
+	return err
+}
+
+func (s *Store) CreateSession(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?1, ?2, ?3, ?4)`,
+		tokenHash, userID, ts(s.clock.Now()), ts(expiresAt))
+	return err
+}
+
+func (s *Store) GetSessionUser(ctx context.Context, tokenHash string) (*model.User, error) {
+	query := `SELECT ` + prefixColumns("u", userColumns) + ` FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ?1 AND s.expires_at > ?2 AND u.is_active = 1`
+
+	var u model.User
+	err := s.conn(ctx).QueryRowContext(ctx, query, tokenHash, ts(s.clock.Now())).Scan(userFields(&u)...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (s *Store) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?1`, tokenHash)
+	return err
+}
+
+func (s *Store) PruneSessions(ctx context.Context) (int64, error) {
+	res, err := s.conn(ctx).ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?1`, ts(s.clock.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// --- Audit Events ---
+
+const auditEventColumns = `id, team_id, actor, action, target_type, target_id, before_state, after_state, created_at`
+
+func (s *Store) CreateAuditEvent(ctx context.Context, e *model.AuditEvent) error {
+	_, err := s.conn(ctx).ExecContext(ctx, `INSERT INTO audit_events (id, team_id, actor, action, target_type, target_id, before_state, after_state, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)`,
+		store.NewID(), e.TeamID, e.Actor, e.Action, e.TargetType, e.TargetID, nullJSON(e.Before), nullJSON(e.After), ts(s.clock.Now()))
+	return err
+}
+
+func (s *Store) GetAuditEvents(ctx context.Context, f store.AuditFilter) ([]model.AuditEvent, error) {
+	conds := []string{inTeam("team_id", 1)}
+	args := []any{teamArg(ctx)}
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Actor != "" {
+		where("actor = ?%d", f.Actor)
+	}
+	if f.Action != "" {
+		where("action = ?%d", f.Action)
+	}
+	if f.TargetType != "" {
+		where("target_type = ?%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		where("target_id = ?%d", f.TargetID)
+	}
+	if f.From != nil {
+		where("created_at >= ?%d", ts(*f.From))
+	}
+	if f.To != nil {
+		where("created_at <= ?%d", ts(*f.To))
+	}
+	args = append(args, f.Limit)
+
+	// rowid breaks ties between events of the same instant
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events WHERE ` + strings.Join(conds, " AND ") +
+		fmt.Sprintf(` ORDER BY created_at DESC, rowid DESC LIMIT ?%d`, len(args))
+	rows, err := s.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.AuditEvent
+	for rows.Next() {
+		var e model.AuditEvent
+		if err := rows.Scan(&e.ID, &e.TeamID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID, (*[]byte)(&e.Before), (*[]byte)(&e.After), timeCol{&e.CreatedAt}); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// nullJSON stores an empty JSON document as NULL.
+func nullJSON(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
@@ -0,0 +1,25 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clk clock.Clock) store.Store {
+		s, err := Open(filepath.Join(t.TempDir(), "alertingo.db"), clk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		if err := s.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
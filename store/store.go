@@ -0,0 +1,286 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mohsen/alertinGo/migrate"
+	"github.com/mohsen/alertinGo/model"
+)
+
+var (
+	// ErrNotFound is returned when a looked up or locked row does not exist,
+	// or no longer matches the condition it was locked on.
+	ErrNotFound = errors.New("not found")
+
+	ErrDependencyCycle = errors.New("dependency would create a cycle")
+
+	ErrTeamExists = errors.New("a team with this name already exists")
+
+	ErrUserExists = errors.New("a user with this username already exists")
+)
+
+// Store is the storage layer used by the handlers, the watcher and background
+// jobs. db.Postgres is the production implementation, memory.Store keeps
+// everything in process for tests and local development.
+//
+// Every call reading, changing or listening to data needs a context from
+// WithTeam, which only sees and changes that team's data, or from Unscoped,
+// which sees every team. Any other context makes it panic, see CheckScope.
+type Store interface {
+	Teams
+	Monitors
+	Heartbeats
+	Alerts
+	Dependencies
+	ThresholdRules
+	Channels
+	NotificationLogs
+	ApiKeys
+	Users
+	AuditEvents
+
+	// InTx runs fn inside a transaction. Every call given the context passed
+	// to fn takes part in it. Nested calls reuse the outer transaction.
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// NewElector returns an elector campaigning for the given lock, so only
+	// one instance sharing this store runs the watcher loop.
+	NewElector(name string, lockID int64) Elector
+
+	// Migrate applies pending schema migrations, MigrateDown reverts the
+	// latest steps of them.
+	Migrate(ctx context.Context) error
+	MigrateDown(ctx context.Context, steps int) error
+	MigrationStatus(ctx context.Context) ([]migrate.Status, error)
+
+	Close()
+}
+
+// ApiKeyUse is the latest request authenticated with a key.
+type ApiKeyUse struct {
+	KeyID string
+	At    time.Time
+	IP    string
+}
+
+// Elector campaigns for leadership until Run's context is cancelled.
+type Elector interface {
+	Run(ctx context.Context)
+	IsLeader() bool
+}
+
+// OverdueMonitor is a watched monitor together with the chat its
+// notifications go to.
+type OverdueMonitor struct {
+	model.Monitor
+	TelegramChatID string
+}
+
+type Teams interface {
+	// CreateTeam returns ErrTeamExists if the name is taken.
+	CreateTeam(ctx context.Context, name string) (*model.Team, error)
+	GetAllTeams(ctx context.Context) ([]model.Team, error)
+	GetTeamByID(ctx context.Context, id string) (*model.Team, error)
+	GetTeamByName(ctx context.Context, name string) (*model.Team, error)
+}
+
+type Monitors interface {
+	// UpsertMonitor records a heartbeat for the monitor with this name and
+	// check type in the context's team. A down monitor only comes back up
+	// after recover_after_heartbeats heartbeats in a row. m.CreatedByKeyID is
+	// only stored when the monitor is created.
+	UpsertMonitor(ctx context.Context, m *model.Monitor) (*model.Monitor, error)
+	GetAllMonitors(ctx context.Context) ([]model.Monitor, error)
+	// GetMonitorByID keeps the monitor from changing until the end of the
+	// transaction when called within InTx, so it can be updated based on it.
+	GetMonitorByID(ctx context.Context, id string) (*model.Monitor, error)
+	// UpdateMonitor returns ErrNotFound if the channel belongs to another team.
+	UpdateMonitor(ctx context.Context, id string, isActive bool, channelID *string) (*model.Monitor, error)
+	DeleteMonitor(ctx context.Context, id string) error
+	// SilenceMonitor stops the monitor's notifications until the given time,
+	// a nil until lifts the silence.
+	SilenceMonitor(ctx context.Context, id string, until *time.Time, by *string) (*model.Monitor, error)
+	// SetMonitorStatus also resets any heartbeats counted towards recovery.
+	SetMonitorStatus(ctx context.Context, id string, status string) error
+
+	// The Get*Monitors and Lock*Monitor calls only return active monitors
+	// with a channel. The Lock calls lock the monitor for the rest of the
+	// transaction if it still matches, and return ErrNotFound if it no longer
+	// does or another transaction holds it.
+	GetOverdueMonitors(ctx context.Context) ([]OverdueMonitor, error)
+	LockOverdueMonitor(ctx context.Context, id string) (*OverdueMonitor, error)
+	GetRecoveredMonitors(ctx context.Context) ([]OverdueMonitor, error)
+	LockRecoveredMonitor(ctx context.Context, id string) (*OverdueMonitor, error)
+	GetFlappingMonitors(ctx context.Context) ([]OverdueMonitor, error)
+	LockFlappingMonitor(ctx context.Context, id string) (*OverdueMonitor, error)
+
+	SetMonitorFlapping(ctx context.Context, id string, flapping bool) error
+	// CountStateTransitions returns how often a monitor went down or came
+	// back up within the given window, based on its heartbeat alert history.
+	CountStateTransitions(ctx context.Context, monitorID string, window time.Duration) (int, error)
+
+	// GetMonitorDeadlines returns when the watcher next needs to look at each
+	// watched monitor: when it becomes overdue, or when its firing alert is
+	// due for a re-alert. GetMonitorDeadline returns ErrNotFound if the
+	// monitor is not watched.
+	GetMonitorDeadlines(ctx context.Context) (map[string]time.Time, error)
+	GetMonitorDeadline(ctx context.Context, id string) (time.Time, error)
+
+	// ListenRecoveries calls fn with the ID of every monitor that comes back
+	// up, until ctx is cancelled or the listener fails.
+	ListenRecoveries(ctx context.Context, fn func(monitorID string)) error
+	// ListenDeadlineChanges calls fn with the ID of every monitor whose
+	// deadline may have moved, after a heartbeat or an update, until ctx is
+	// cancelled or the listener fails. It lets the leader follow heartbeats
+	// received by other instances.
+	ListenDeadlineChanges(ctx context.Context, fn func(monitorID string)) error
+}
+
+type Heartbeats interface {
+	CreateHeartbeat(ctx context.Context, monitorID, message, metadata, sourceIP string) error
+	// GetHeartbeats returns the newest heartbeats of a monitor, optionally
+	// limited to the [from, to] time range.
+	GetHeartbeats(ctx context.Context, monitorID string, from, to *time.Time, limit int) ([]model.Heartbeat, error)
+	// PruneHeartbeats deletes heartbeats older than maxAge and keeps at most
+	// maxPerMonitor rows per monitor. A zero limit is ignored.
+	PruneHeartbeats(ctx context.Context, maxAge time.Duration, maxPerMonitor int) (int64, error)
+}
+
+type Alerts interface {
+	GetFiringAlert(ctx context.Context, monitorID string) (*model.AlertState, error)
+	GetFiringThresholdAlert(ctx context.Context, ruleID string) (*model.AlertState, error)
+	// GetFiringAlerts returns every firing alert of a monitor, heartbeat and
+	// threshold alike.
+	GetFiringAlerts(ctx context.Context, monitorID string) ([]model.AlertState, error)
+	// AcknowledgeAlerts acknowledges the firing alerts of a monitor that are
+	// not acknowledged yet and returns how many there were.
+	AcknowledgeAlerts(ctx context.Context, monitorID, by string) (int64, error)
+	// CreateAlertState opens a heartbeat alert, reporting false if the
+	// monitor already has a firing one. Suppressed alerts are tracked
+	// without notifying, because a parent monitor is already down.
+	CreateAlertState(ctx context.Context, monitorID string, suppressed bool) (bool, error)
+	// CreateThresholdAlertState reports false if the rule already has a firing alert.
+	CreateThresholdAlertState(ctx context.Context, monitorID, ruleID string) (bool, error)
+	UnsuppressAlert(ctx context.Context, alertID string) error
+	UpdateAlertLastAlerted(ctx context.Context, alertID string) error
+	ResolveAlert(ctx context.Context, alertID string) error
+}
+
+type Dependencies interface {
+	GetMonitorParents(ctx context.Context, monitorID string) ([]model.Monitor, error)
+	// AddMonitorParent returns ErrDependencyCycle if the parent already
+	// depends on the monitor, and ErrNotFound unless both are in the same
+	// team.
+	AddMonitorParent(ctx context.Context, monitorID, parentID string) error
+	RemoveMonitorParent(ctx context.Context, monitorID, parentID string) error
+	// HasDownParent reports whether any active parent of the monitor is down
+	// or overdue, even if the watcher has not processed it yet.
+	HasDownParent(ctx context.Context, monitorID string) (bool, error)
+	// GetDownChildren returns the active children of a monitor that are down or overdue.
+	GetDownChildren(ctx context.Context, parentID string) ([]model.Monitor, error)
+	// GetSuppressedChildren returns the watched children of a monitor whose
+	// alert is suppressed, locking them inside a transaction.
+	GetSuppressedChildren(ctx context.Context, parentID string) ([]OverdueMonitor, error)
+}
+
+type ThresholdRules interface {
+	CreateThresholdRule(ctx context.Context, r *model.ThresholdRule) (*model.ThresholdRule, error)
+	GetThresholdRules(ctx context.Context, monitorID string) ([]model.ThresholdRule, error)
+	// LockThresholdRule locks a rule for the rest of the transaction, so
+	// concurrent heartbeats update its breach count one after another.
+	LockThresholdRule(ctx context.Context, id string) (*model.ThresholdRule, error)
+	SetRuleBreachCount(ctx context.Context, id string, count int) error
+	DeleteThresholdRule(ctx context.Context, monitorID, id string) error
+}
+
+type Channels interface {
+	CreateChannel(ctx context.Context, name, telegramChatID string) (*model.NotificationChannel, error)
+	GetChannelByID(ctx context.Context, id string) (*model.NotificationChannel, error)
+	GetAllChannels(ctx context.Context) ([]model.NotificationChannel, error)
+	DeleteChannel(ctx context.Context, id string) error
+}
+
+type NotificationLogs interface {
+	CreateNotificationLog(ctx context.Context, monitorID string, channelID *string, alertType, message string, success bool, errMsg string) error
+	// GetNotificationLogs returns the 100 newest entries.
+	GetNotificationLogs(ctx context.Context) ([]model.NotificationLog, error)
+}
+
+type ApiKeys interface {
+	// CreateApiKey stores a key with the name, prefix, scopes, patterns and
+	// expiry of k.
+	CreateApiKey(ctx context.Context, k *model.ApiKey, keyHash string) (*model.ApiKey, error)
+	GetAllApiKeys(ctx context.Context) ([]model.ApiKey, error)
+	GetApiKeyByID(ctx context.Context, id string) (*model.ApiKey, error)
+	// UpdateApiKey sets the name, active flag, scopes, patterns and expiry of
+	// the key with k's ID to those of k.
+	UpdateApiKey(ctx context.Context, k *model.ApiKey) (*model.ApiKey, error)
+	DeleteApiKey(ctx context.Context, id string) error
+	// RevokeApiKey deactivates a key but keeps it listed.
+	RevokeApiKey(ctx context.Context, id string) error
+	// ExpireApiKey makes a key expire at the given time, unless it already
+	// expires sooner.
+	ExpireApiKey(ctx context.Context, id string, at time.Time) error
+	// GetActiveApiKey returns the active, unexpired key with this hash, or
+	// ErrNotFound. It looks at every team, the key decides which team the
+	// request is scoped to.
+	GetActiveApiKey(ctx context.Context, keyHash string) (*model.ApiKey, error)
+	// RecordApiKeyUses stores when and from where keys were last used. Uses
+	// older than the one already recorded are ignored.
+	RecordApiKeyUses(ctx context.Context, uses []ApiKeyUse) error
+
+	// ListenApiKeyChanges calls fn with the ID of every key that is updated,
+	// revoked, expired or deleted, until ctx is cancelled or the listener
+	// fails. It calls ready once changes are being listened to, changes made
+	// before then are missed.
+	ListenApiKeyChanges(ctx context.Context, ready func(), fn func(keyID string)) error
+}
+
+type Users interface {
+	// CreateUser stores a user in the context's team. Usernames are unique
+	// across teams, CreateUser returns ErrUserExists if it is taken.
+	CreateUser(ctx context.Context, u *model.User, passwordHash string) (*model.User, error)
+	GetAllUsers(ctx context.Context) ([]model.User, error)
+	GetUserByID(ctx context.Context, id string) (*model.User, error)
+	// UpsertSSOUser finds the user signed in with an OIDC subject, updating
+	// its username and role, or creates it in the context's team without a
+	// password. It returns ErrUserExists if a different user has the username.
+	UpsertSSOUser(ctx context.Context, u *model.User, subject string) (*model.User, error)
+	// GetUserLogin returns an active user and its password hash, looking at
+	// every team.
+	GetUserLogin(ctx context.Context, username string) (*model.User, string, error)
+	// UpdateUser also ends the user's sessions when it is deactivated.
+	UpdateUser(ctx context.Context, id, role string, isActive bool) (*model.User, error)
+	// SetUserPassword also ends every session of the user.
+	SetUserPassword(ctx context.Context, id, passwordHash string) error
+	DeleteUser(ctx context.Context, id string) error
+
+	CreateSession(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	// GetSessionUser returns the active user owning an unexpired session,
+	// looking at every team.
+	GetSessionUser(ctx context.Context, tokenHash string) (*model.User, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+	// PruneSessions deletes expired sessions.
+	PruneSessions(ctx context.Context) (int64, error)
+}
+
+type AuditEvents interface {
+	// CreateAuditEvent stores e in e.TeamID.
+	CreateAuditEvent(ctx context.Context, e *model.AuditEvent) error
+	// GetAuditEvents returns the newest events of the context's team matching
+	// f, optionally limited to the [From, To] time range.
+	GetAuditEvents(ctx context.Context, f AuditFilter) ([]model.AuditEvent, error)
+}
+
+// AuditFilter selects audit events. Empty fields match every event.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+}
@@ -0,0 +1,752 @@
+// Package storetest checks that a store.Store behaves the way the handlers and
+// the watcher expect. Every implementation runs the same suite, so the memory
+// store used in tests can't drift from the Postgres and SQLite stores.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// Start is the time the fake clock passed to newStore starts at.
+var Start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Run runs the suite. newStore returns an empty, migrated store using clk, and
+// is called once per test.
+func Run(t *testing.T, newStore func(t *testing.T, clk clock.Clock) store.Store) {
+	for _, tc := range []struct {
+		name string
+		fn   func(t *testing.T, s *suite)
+	}{
+		{"Teams", testTeams},
+		{"PlainContext", testPlainContext},
+		{"TeamIsolation", testTeamIsolation},
+		{"WatcherIsolation", testWatcherIsolation},
+		{"UpsertMonitor", testUpsertMonitor},
+		{"Recovery", testRecovery},
+		{"Overdue", testOverdue},
+		{"Alerts", testAlerts},
+		{"ConcurrentAlerts", testConcurrentAlerts},
+		{"Heartbeats", testHeartbeats},
+		{"Retention", testRetention},
+		{"ApiKeys", testApiKeys},
+		{"ApiKeyChanges", testApiKeyChanges},
+		{"Users", testUsers},
+		{"Sessions", testSessions},
+		{"AuditEvents", testAuditEvents},
+		{"InTx", testInTx},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := clock.NewFake(Start)
+			tc.fn(t, &suite{t: t, ctx: store.Unscoped(context.Background()), clock: clk, store: newStore(t, clk)})
+		})
+	}
+}
+
+type suite struct {
+	t     *testing.T
+	ctx   context.Context
+	clock *clock.Fake
+	store store.Store
+}
+
+func (s *suite) team(name string) context.Context {
+	s.t.Helper()
+	team, err := s.store.CreateTeam(s.ctx, name)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return store.WithTeam(context.Background(), team.ID)
+}
+
+// monitor records a heartbeat of a monitor that times out after 60s.
+func (s *suite) monitor(ctx context.Context, name string) *model.Monitor {
+	s.t.Helper()
+	m, err := s.store.UpsertMonitor(ctx, &model.Monitor{MonitorName: name, CheckType: "http", Metadata: "{}",
+		Timeout: 60, ReAlertInterval: 300, AlertAfterMisses: 1, RecoverAfterHeartbeats: 1})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return m
+}
+
+// watched is monitor, made active with a channel.
+func (s *suite) watched(ctx context.Context, name string) *model.Monitor {
+	s.t.Helper()
+	ch, err := s.store.CreateChannel(ctx, name, "chat-"+name)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	m, err := s.store.UpdateMonitor(ctx, s.monitor(ctx, name).ID, true, &ch.ID)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return m
+}
+
+func (s *suite) key(ctx context.Context, hash string) *model.ApiKey {
+	s.t.Helper()
+	k, err := s.store.CreateApiKey(ctx, &model.ApiKey{Name: hash, KeyPrefix: hash[:4], Scopes: []string{model.ScopeRead}}, hash)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return k
+}
+
+func (s *suite) user(ctx context.Context, username string) *model.User {
+	s.t.Helper()
+	u, err := s.store.CreateUser(ctx, &model.User{Username: username, Role: model.RoleViewer, IsActive: true}, "hash-"+username)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return u
+}
+
+func ids[T any](items []T, id func(T) string) []string {
+	var result []string
+	for _, item := range items {
+		result = append(result, id(item))
+	}
+	return result
+}
+
+func monitorID(m model.Monitor) string          { return m.ID }
+func overdueID(m store.OverdueMonitor) string   { return m.ID }
+func auditAction(e model.AuditEvent) string     { return e.Action }
+func heartbeatMessage(h model.Heartbeat) string { return h.Message }
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testTeams(t *testing.T, s *suite) {
+	team, err := s.store.CreateTeam(s.ctx, "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.CreateTeam(s.ctx, "payments"); !errors.Is(err, store.ErrTeamExists) {
+		t.Fatalf("creating a team twice: %v, want ErrTeamExists", err)
+	}
+	if got, err := s.store.GetTeamByName(s.ctx, "payments"); err != nil || got.ID != team.ID {
+		t.Fatalf("GetTeamByName = %+v, %v", got, err)
+	}
+	if _, err := s.store.GetTeamByID(s.ctx, model.DefaultTeamID); err != nil {
+		t.Fatalf("default team: %v", err)
+	}
+
+	// A team only sees itself
+	teams, err := s.store.GetAllTeams(store.WithTeam(context.Background(), team.ID))
+	if err != nil || len(teams) != 1 || teams[0].ID != team.ID {
+		t.Fatalf("GetAllTeams in a team = %+v, %v", teams, err)
+	}
+	if _, err := s.store.GetTeamByID(store.WithTeam(context.Background(), team.ID), model.DefaultTeamID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("another team: %v, want ErrNotFound", err)
+	}
+}
+
+func testPlainContext(t *testing.T, s *suite) {
+	for name, fn := range map[string]func(){
+		"GetAllMonitors": func() { s.store.GetAllMonitors(context.Background()) },
+		"CreateTeam":     func() { s.store.CreateTeam(context.Background(), "plain") },
+		"InTx": func() {
+			s.store.InTx(context.Background(), func(ctx context.Context) error { return nil })
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("did not panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+func testTeamIsolation(t *testing.T, s *suite) {
+	a, b := s.team("a"), s.team("b")
+	mine, theirs := s.watched(a, "web"), s.watched(b, "web")
+	if mine.ID == theirs.ID {
+		t.Fatal("monitors with the same name in two teams share an ID")
+	}
+
+	monitors, err := s.store.GetAllMonitors(a)
+	if err != nil || !equal(ids(monitors, monitorID), []string{mine.ID}) {
+		t.Fatalf("GetAllMonitors in team a = %v, %v", ids(monitors, monitorID), err)
+	}
+	if monitors, _ := s.store.GetAllMonitors(s.ctx); len(monitors) != 2 {
+		t.Fatalf("GetAllMonitors unscoped returns %d monitors, want 2", len(monitors))
+	}
+
+	if _, err := s.store.GetMonitorByID(a, theirs.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetMonitorByID of another team: %v, want ErrNotFound", err)
+	}
+	if _, err := s.store.UpdateMonitor(a, theirs.ID, false, nil); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("UpdateMonitor of another team: %v, want ErrNotFound", err)
+	}
+	if _, err := s.store.UpdateMonitor(a, mine.ID, true, theirs.ChannelID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("UpdateMonitor with another team's channel: %v, want ErrNotFound", err)
+	}
+	if err := s.store.AddMonitorParent(a, mine.ID, theirs.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("AddMonitorParent across teams: %v, want ErrNotFound", err)
+	}
+	if _, err := s.store.GetChannelByID(a, *theirs.ChannelID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetChannelByID of another team: %v, want ErrNotFound", err)
+	}
+
+	key := s.key(b, "hash-b")
+	if _, err := s.store.GetApiKeyByID(a, key.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetApiKeyByID of another team: %v, want ErrNotFound", err)
+	}
+	if err := s.store.RevokeApiKey(a, key.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("RevokeApiKey of another team: %v, want ErrNotFound", err)
+	}
+	// Lookups by hash look at every team
+	if got, err := s.store.GetActiveApiKey(s.ctx, "hash-b"); err != nil || got.TeamID != store.TeamID(b) {
+		t.Fatalf("GetActiveApiKey = %+v, %v", got, err)
+	}
+}
+
+// testWatcherIsolation checks the queries the watcher makes unscoped also
+// stay within the context's team.
+func testWatcherIsolation(t *testing.T, s *suite) {
+	a, b := s.team("a"), s.team("b")
+	mine, theirs := s.watched(a, "web"), s.watched(b, "web")
+	child := s.watched(b, "api")
+	if err := s.store.AddMonitorParent(b, child.ID, theirs.ID); err != nil {
+		t.Fatal(err)
+	}
+	rule, err := s.store.CreateThresholdRule(b, &model.ThresholdRule{MonitorID: theirs.ID, Metric: "cpu", Operator: ">", Threshold: 90, Consecutive: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Advance(61 * time.Second)
+
+	if overdue, err := s.store.GetOverdueMonitors(a); err != nil || !equal(ids(overdue, overdueID), []string{mine.ID}) {
+		t.Fatalf("GetOverdueMonitors in team a = %v, %v", ids(overdue, overdueID), err)
+	}
+	if deadlines, err := s.store.GetMonitorDeadlines(a); err != nil || len(deadlines) != 1 {
+		t.Fatalf("GetMonitorDeadlines in team a = %v, %v", deadlines, err)
+	}
+	if _, err := s.store.GetMonitorDeadline(a, theirs.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetMonitorDeadline of another team: %v, want ErrNotFound", err)
+	}
+	err = s.store.InTx(a, func(ctx context.Context) error {
+		_, err := s.store.LockOverdueMonitor(ctx, theirs.ID)
+		return err
+	})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("LockOverdueMonitor of another team: %v, want ErrNotFound", err)
+	}
+
+	if err := s.store.SetMonitorStatus(a, theirs.ID, "down"); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := s.store.GetMonitorByID(s.ctx, theirs.ID); m.Status == "down" {
+		t.Fatal("another team set the monitor down")
+	}
+	if err := s.store.SetMonitorStatus(s.ctx, theirs.ID, "down"); err != nil {
+		t.Fatal(err)
+	}
+	if down, err := s.store.HasDownParent(a, child.ID); err != nil || down {
+		t.Fatalf("HasDownParent in another team = %v, %v", down, err)
+	}
+	if children, err := s.store.GetDownChildren(a, theirs.ID); err != nil || len(children) != 0 {
+		t.Fatalf("GetDownChildren in another team = %v, %v", ids(children, monitorID), err)
+	}
+
+	if created, err := s.store.CreateAlertState(a, child.ID, true); err != nil || created {
+		t.Fatalf("CreateAlertState in another team = %v, %v", created, err)
+	}
+	if _, err := s.store.CreateAlertState(s.ctx, child.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.GetFiringAlert(a, child.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetFiringAlert of another team: %v, want ErrNotFound", err)
+	}
+	if children, err := s.store.GetSuppressedChildren(a, theirs.ID); err != nil || len(children) != 0 {
+		t.Fatalf("GetSuppressedChildren in another team = %v, %v", ids(children, overdueID), err)
+	}
+	alert, err := s.store.GetFiringAlert(s.ctx, child.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.UnsuppressAlert(a, alert.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.ResolveAlert(a, alert.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.store.GetFiringAlert(s.ctx, child.ID); err != nil || !got.Suppressed {
+		t.Fatalf("another team changed the alert: %+v, %v", got, err)
+	}
+
+	if _, err := s.store.LockThresholdRule(a, rule.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("LockThresholdRule of another team: %v, want ErrNotFound", err)
+	}
+	if err := s.store.SetRuleBreachCount(a, rule.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.store.LockThresholdRule(s.ctx, rule.ID); err != nil || got.BreachCount != 0 {
+		t.Fatalf("another team set the breach count: %+v, %v", got, err)
+	}
+}
+
+func testUpsertMonitor(t *testing.T, s *suite) {
+	created := s.monitor(s.ctx, "web")
+	if created.Status != "unknown" || created.TeamID != model.DefaultTeamID || !created.LastSeenAt.Equal(Start) {
+		t.Fatalf("created %+v, want an unknown monitor in the default team last seen at the start", created)
+	}
+
+	s.clock.Advance(time.Minute)
+	updated := s.monitor(s.ctx, "web")
+	if updated.ID != created.ID || updated.Status != "up" || !updated.LastSeenAt.Equal(Start.Add(time.Minute)) {
+		t.Fatalf("updated %+v, want the same monitor up and last seen a minute later", updated)
+	}
+
+	if other := s.monitor(s.ctx, "api"); other.ID == created.ID {
+		t.Fatal("monitors with different names share an ID")
+	}
+}
+
+func testRecovery(t *testing.T, s *suite) {
+	m := s.monitor(s.ctx, "web")
+	m.RecoverAfterHeartbeats = 2
+	if err := s.store.SetMonitorStatus(s.ctx, m.ID, "down"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"down", "up"} {
+		got, err := s.store.UpsertMonitor(s.ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != want {
+			t.Fatalf("status after %d heartbeats is %s, want %s", i+1, got.Status, want)
+		}
+	}
+}
+
+func testOverdue(t *testing.T, s *suite) {
+	m := s.monitor(s.ctx, "unwatched")
+	if _, err := s.store.GetMonitorDeadline(s.ctx, m.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("deadline of a monitor without a channel: %v, want ErrNotFound", err)
+	}
+
+	m = s.watched(s.ctx, "web")
+	deadline, err := s.store.GetMonitorDeadline(s.ctx, m.ID)
+	if err != nil || !deadline.Equal(Start.Add(time.Minute)) {
+		t.Fatalf("deadline = %v, %v, want a minute after the heartbeat", deadline, err)
+	}
+	if deadlines, err := s.store.GetMonitorDeadlines(s.ctx); err != nil || len(deadlines) != 1 || !deadlines[m.ID].Equal(deadline) {
+		t.Fatalf("GetMonitorDeadlines = %v, %v", deadlines, err)
+	}
+
+	if overdue, _ := s.store.GetOverdueMonitors(s.ctx); len(overdue) != 0 {
+		t.Fatalf("overdue before the deadline: %v", ids(overdue, overdueID))
+	}
+	s.clock.Advance(61 * time.Second)
+	overdue, err := s.store.GetOverdueMonitors(s.ctx)
+	if err != nil || !equal(ids(overdue, overdueID), []string{m.ID}) || overdue[0].TelegramChatID != "chat-web" {
+		t.Fatalf("GetOverdueMonitors = %+v, %v", overdue, err)
+	}
+
+	err = s.store.InTx(s.ctx, func(ctx context.Context) error {
+		_, err := s.store.LockOverdueMonitor(ctx, m.ID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("LockOverdueMonitor: %v", err)
+	}
+
+	// Another heartbeat pushes the deadline back
+	s.monitor(s.ctx, "web")
+	err = s.store.InTx(s.ctx, func(ctx context.Context) error {
+		_, err := s.store.LockOverdueMonitor(ctx, m.ID)
+		return err
+	})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("LockOverdueMonitor after a heartbeat: %v, want ErrNotFound", err)
+	}
+}
+
+func testAlerts(t *testing.T, s *suite) {
+	m := s.watched(s.ctx, "web")
+
+	for _, want := range []bool{true, false} {
+		if created, err := s.store.CreateAlertState(s.ctx, m.ID, false); err != nil || created != want {
+			t.Fatalf("CreateAlertState = %v, %v, want %v", created, err, want)
+		}
+	}
+	alert, err := s.store.GetFiringAlert(s.ctx, m.ID)
+	if err != nil || alert.Status != "firing" || alert.Type != "heartbeat" || !alert.FiredAt.Equal(Start) {
+		t.Fatalf("GetFiringAlert = %+v, %v", alert, err)
+	}
+
+	// A heartbeat brings it up, with the alert still to be resolved
+	if err := s.store.SetMonitorStatus(s.ctx, m.ID, "down"); err != nil {
+		t.Fatal(err)
+	}
+	s.monitor(s.ctx, "web")
+	recovered, err := s.store.GetRecoveredMonitors(s.ctx)
+	if err != nil || !equal(ids(recovered, overdueID), []string{m.ID}) {
+		t.Fatalf("GetRecoveredMonitors = %v, %v", ids(recovered, overdueID), err)
+	}
+
+	for _, want := range []int64{1, 0} {
+		if n, err := s.store.AcknowledgeAlerts(s.ctx, m.ID, "alice"); err != nil || n != want {
+			t.Fatalf("AcknowledgeAlerts = %d, %v, want %d", n, err, want)
+		}
+	}
+	alerts, err := s.store.GetFiringAlerts(s.ctx, m.ID)
+	if err != nil || len(alerts) != 1 || alerts[0].AcknowledgedBy == nil || *alerts[0].AcknowledgedBy != "alice" {
+		t.Fatalf("GetFiringAlerts = %+v, %v", alerts, err)
+	}
+
+	s.clock.Advance(time.Minute)
+	if err := s.store.ResolveAlert(s.ctx, alert.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.GetFiringAlert(s.ctx, m.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetFiringAlert after resolving: %v, want ErrNotFound", err)
+	}
+	if n, err := s.store.CountStateTransitions(s.ctx, m.ID, time.Hour); err != nil || n != 2 {
+		t.Fatalf("CountStateTransitions = %d, %v, want 2", n, err)
+	}
+}
+
+// testConcurrentAlerts races watchers opening the same monitor's alert, only
+// one of them may open it.
+func testConcurrentAlerts(t *testing.T, s *suite) {
+	m := s.watched(s.ctx, "web")
+
+	const callers = 8
+	start := make(chan struct{})
+	results := make(chan error, callers)
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			ok, err := s.store.CreateAlertState(s.ctx, m.ID, false)
+			if ok {
+				created.Add(1)
+			}
+			results <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	for err := range results {
+		if err != nil {
+			t.Fatalf("CreateAlertState: %v", err)
+		}
+	}
+	if n := created.Load(); n != 1 {
+		t.Fatalf("%d concurrent CreateAlertState calls opened an alert, want 1", n)
+	}
+	if alerts, err := s.store.GetFiringAlerts(s.ctx, m.ID); err != nil || len(alerts) != 1 {
+		t.Fatalf("GetFiringAlerts = %d alerts, %v, want 1", len(alerts), err)
+	}
+}
+
+func testHeartbeats(t *testing.T, s *suite) {
+	m := s.monitor(s.ctx, "web")
+	for _, msg := range []string{"first", "second", "third"} {
+		if err := s.store.CreateHeartbeat(s.ctx, m.ID, msg, "{}", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		s.clock.Advance(time.Minute)
+	}
+
+	heartbeats, err := s.store.GetHeartbeats(s.ctx, m.ID, nil, nil, 2)
+	if err != nil || !equal(ids(heartbeats, heartbeatMessage), []string{"third", "second"}) {
+		t.Fatalf("GetHeartbeats = %v, %v, want the newest two", ids(heartbeats, heartbeatMessage), err)
+	}
+	from, to := Start.Add(30*time.Second), Start.Add(90*time.Second)
+	heartbeats, err = s.store.GetHeartbeats(s.ctx, m.ID, &from, &to, 10)
+	if err != nil || !equal(ids(heartbeats, heartbeatMessage), []string{"second"}) {
+		t.Fatalf("GetHeartbeats in a range = %v, %v", ids(heartbeats, heartbeatMessage), err)
+	}
+
+	if n, err := s.store.PruneHeartbeats(s.ctx, 0, 1); err != nil || n != 2 {
+		t.Fatalf("PruneHeartbeats = %d, %v, want 2", n, err)
+	}
+	heartbeats, _ = s.store.GetHeartbeats(s.ctx, m.ID, nil, nil, 10)
+	if !equal(ids(heartbeats, heartbeatMessage), []string{"third"}) {
+		t.Fatalf("kept %v, want the newest heartbeat", ids(heartbeats, heartbeatMessage))
+	}
+}
+
+func testRetention(t *testing.T, s *suite) {
+	a, b := s.monitor(s.ctx, "a"), s.monitor(s.ctx, "b")
+	for _, day := range []string{"day 0", "day 1", "day 2", "day 3"} {
+		for _, m := range []*model.Monitor{a, b} {
+			if err := s.store.CreateHeartbeat(s.ctx, m.ID, day, "{}", "10.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.clock.Advance(24 * time.Hour)
+	}
+
+	u := s.user(s.ctx, "alice")
+	for token, ttl := range map[string]time.Duration{"expired": -time.Minute, "valid": time.Minute} {
+		if err := s.store.CreateSession(s.ctx, u.ID, token, s.clock.Now().Add(ttl)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The cutoff falls between day 1 and day 2, count limits are off
+	if n, err := s.store.PruneHeartbeats(s.ctx, 60*time.Hour, 0); err != nil || n != 4 {
+		t.Fatalf("PruneHeartbeats = %d, %v, want 4", n, err)
+	}
+	for _, m := range []*model.Monitor{a, b} {
+		heartbeats, _ := s.store.GetHeartbeats(s.ctx, m.ID, nil, nil, 10)
+		if !equal(ids(heartbeats, heartbeatMessage), []string{"day 3", "day 2"}) {
+			t.Fatalf("kept %v of monitor %s, want the heartbeats after the cutoff", ids(heartbeats, heartbeatMessage), m.MonitorName)
+		}
+	}
+
+	if n, err := s.store.PruneSessions(s.ctx); err != nil || n != 1 {
+		t.Fatalf("PruneSessions = %d, %v, want 1", n, err)
+	}
+	if _, err := s.store.GetSessionUser(s.ctx, "valid"); err != nil {
+		t.Fatalf("valid session pruned: %v", err)
+	}
+}
+
+func testApiKeys(t *testing.T, s *suite) {
+	k := s.key(s.ctx, "hash-1")
+	if !k.IsActive || k.TeamID != model.DefaultTeamID {
+		t.Fatalf("created %+v, want an active key in the default team", k)
+	}
+	if _, err := s.store.GetActiveApiKey(s.ctx, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Older uses don't overwrite newer ones
+	later := Start.Add(time.Minute)
+	err := s.store.RecordApiKeyUses(s.ctx, []store.ApiKeyUse{{KeyID: k.ID, At: later, IP: "10.0.0.2"}, {KeyID: k.ID, At: Start, IP: "10.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.store.GetApiKeyByID(s.ctx, k.ID); got.LastUsedAt == nil || !got.LastUsedAt.Equal(later) || got.LastUsedIP != "10.0.0.2" {
+		t.Fatalf("last used %v from %s, want %v from 10.0.0.2", got.LastUsedAt, got.LastUsedIP, later)
+	}
+
+	update := *k
+	update.Name, update.IsActive, update.Scopes = "renamed", false, []string{model.ScopeIngest, model.ScopeRead}
+	update.MonitorPattern, update.CheckTypePattern = "web-*", "http"
+	expiresAt := Start.Add(24 * time.Hour)
+	update.ExpiresAt = &expiresAt
+	got, err := s.store.UpdateApiKey(s.ctx, &update)
+	if err != nil || got.Name != "renamed" || got.IsActive || !equal(got.Scopes, update.Scopes) ||
+		got.MonitorPattern != "web-*" || got.CheckTypePattern != "http" || got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("UpdateApiKey = %+v, %v, want %+v", got, err, update)
+	}
+	update.IsActive = true
+	if _, err := s.store.UpdateApiKey(s.ctx, &update); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.store.ExpireApiKey(s.ctx, k.ID, Start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Advance(time.Hour)
+	if _, err := s.store.GetActiveApiKey(s.ctx, "hash-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expired key: %v, want ErrNotFound", err)
+	}
+
+	s.key(s.ctx, "hash-2")
+	if err := s.store.RevokeApiKey(s.ctx, s.key(s.ctx, "hash-3").ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.GetActiveApiKey(s.ctx, "hash-3"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("revoked key: %v, want ErrNotFound", err)
+	}
+	if keys, err := s.store.GetAllApiKeys(s.ctx); err != nil || len(keys) != 3 {
+		t.Fatalf("GetAllApiKeys returns %d keys, %v, want 3", len(keys), err)
+	}
+}
+
+func testApiKeyChanges(t *testing.T, s *suite) {
+	k := s.key(s.ctx, "hash-1")
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	ready := make(chan struct{})
+	changed := make(chan string, 1)
+	go s.store.ListenApiKeyChanges(ctx, func() { close(ready) }, func(keyID string) {
+		select {
+		case changed <- keyID:
+		default:
+		}
+	})
+
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener did not get ready")
+	}
+	if err := s.store.RevokeApiKey(s.ctx, k.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-changed:
+		if id != k.ID {
+			t.Fatalf("announced key %s, want %s", id, k.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("revocation was not announced")
+	}
+}
+
+func testUsers(t *testing.T, s *suite) {
+	a, b := s.team("a"), s.team("b")
+	u := s.user(a, "alice")
+	if u.TeamID != store.TeamID(a) {
+		t.Fatalf("created in team %s, want %s", u.TeamID, store.TeamID(a))
+	}
+	if _, err := s.store.CreateUser(b, &model.User{Username: "alice", Role: model.RoleViewer, IsActive: true}, "hash"); !errors.Is(err, store.ErrUserExists) {
+		t.Fatalf("taken username in another team: %v, want ErrUserExists", err)
+	}
+
+	if got, hash, err := s.store.GetUserLogin(s.ctx, "alice"); err != nil || got.ID != u.ID || hash != "hash-alice" {
+		t.Fatalf("GetUserLogin = %+v, %q, %v", got, hash, err)
+	}
+	if _, err := s.store.GetUserByID(b, u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetUserByID of another team: %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.store.UpdateUser(a, u.ID, model.RoleAdmin, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.store.GetUserLogin(s.ctx, "alice"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetUserLogin of a deactivated user: %v, want ErrNotFound", err)
+	}
+
+	// SSO users are found by subject, even after a rename
+	sso, err := s.store.UpsertSSOUser(b, &model.User{Username: "bob", Role: model.RoleViewer}, "subject-1")
+	if err != nil || !sso.SSO || sso.TeamID != store.TeamID(b) {
+		t.Fatalf("UpsertSSOUser = %+v, %v", sso, err)
+	}
+	renamed, err := s.store.UpsertSSOUser(b, &model.User{Username: "robert", Role: model.RoleAdmin}, "subject-1")
+	if err != nil || renamed.ID != sso.ID || renamed.Username != "robert" || renamed.Role != model.RoleAdmin {
+		t.Fatalf("UpsertSSOUser again = %+v, %v", renamed, err)
+	}
+	if _, err := s.store.UpsertSSOUser(b, &model.User{Username: "robert", Role: model.RoleViewer}, "subject-2"); !errors.Is(err, store.ErrUserExists) {
+		t.Fatalf("UpsertSSOUser with a taken username: %v, want ErrUserExists", err)
+	}
+}
+
+func testSessions(t *testing.T, s *suite) {
+	u := s.user(s.ctx, "alice")
+	for _, token := range []string{"short", "long"} {
+		expires := Start.Add(time.Hour)
+		if token == "long" {
+			expires = Start.Add(24 * time.Hour)
+		}
+		if err := s.store.CreateSession(s.ctx, u.ID, token, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := s.store.GetSessionUser(s.ctx, "short"); err != nil || got.ID != u.ID {
+		t.Fatalf("GetSessionUser = %+v, %v", got, err)
+	}
+
+	s.clock.Advance(2 * time.Hour)
+	if _, err := s.store.GetSessionUser(s.ctx, "short"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expired session: %v, want ErrNotFound", err)
+	}
+	if n, err := s.store.PruneSessions(s.ctx); err != nil || n != 1 {
+		t.Fatalf("PruneSessions = %d, %v, want 1", n, err)
+	}
+
+	// A new password ends every session
+	if err := s.store.SetUserPassword(s.ctx, u.ID, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.GetSessionUser(s.ctx, "long"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("session after a password change: %v, want ErrNotFound", err)
+	}
+}
+
+func testAuditEvents(t *testing.T, s *suite) {
+	a, b := s.team("a"), s.team("b")
+	for _, e := range []model.AuditEvent{
+		{TeamID: store.TeamID(a), Actor: "user:alice", Action: "create", TargetType: "monitor", TargetID: "m1"},
+		{TeamID: store.TeamID(a), Actor: "user:alice", Action: "delete", TargetType: "monitor", TargetID: "m1"},
+		{TeamID: store.TeamID(b), Actor: "admin", Action: "create", TargetType: "channel", TargetID: "c1"},
+	} {
+		e.After = []byte(`{"name":"x"}`)
+		if err := s.store.CreateAuditEvent(s.ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+		s.clock.Advance(time.Minute)
+	}
+
+	events, err := s.store.GetAuditEvents(a, store.AuditFilter{Limit: 10})
+	if err != nil || !equal(ids(events, auditAction), []string{"delete", "create"}) {
+		t.Fatalf("GetAuditEvents in team a = %v, %v, want its two events newest first", ids(events, auditAction), err)
+	}
+	events, err = s.store.GetAuditEvents(s.ctx, store.AuditFilter{Action: "create", Limit: 10})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("GetAuditEvents of every team by action = %v, %v", ids(events, auditAction), err)
+	}
+	from := Start.Add(30 * time.Second)
+	events, err = s.store.GetAuditEvents(s.ctx, store.AuditFilter{From: &from, Limit: 1})
+	if err != nil || len(events) != 1 || events[0].TeamID != store.TeamID(b) {
+		t.Fatalf("GetAuditEvents with a range and limit = %+v, %v", events, err)
+	}
+}
+
+func testInTx(t *testing.T, s *suite) {
+	failed := errors.New("failed")
+	var id string
+	err := s.store.InTx(s.ctx, func(ctx context.Context) error {
+		ch, err := s.store.CreateChannel(ctx, "rolled back", "chat")
+		if err != nil {
+			return err
+		}
+		id = ch.ID
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("InTx = %v, want the error of fn", err)
+	}
+	if _, err := s.store.GetChannelByID(s.ctx, id); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("channel of a failed transaction: %v, want ErrNotFound", err)
+	}
+
+	err = s.store.InTx(s.ctx, func(ctx context.Context) error {
+		ch, err := s.store.CreateChannel(ctx, "committed", "chat")
+		id = ch.ID
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.GetChannelByID(s.ctx, id); err != nil {
+		t.Fatalf("channel of a committed transaction: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+
+	"github.com/mohsen/alertinGo/model"
+)
+
+type scopeKey struct{}
+
+// scope is the team a context's store calls are limited to, "" for every team.
+type scope struct {
+	teamID string
+}
+
+// WithTeam scopes the store calls made with the returned context to a team.
+// They only see and change that team's monitors, channels, API keys and what
+// hangs off them, and create new ones in it.
+func WithTeam(ctx context.Context, teamID string) context.Context {
+	if teamID == "" {
+		panic("store: WithTeam needs a team, use Unscoped for every team")
+	}
+	return context.WithValue(ctx, scopeKey{}, scope{teamID: teamID})
+}
+
+// Unscoped lets the store calls made with the returned context see every
+// team, and create rows in the default team. Only the watcher, background
+// jobs, authentication lookups and the admin token use it.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope{})
+}
+
+// CheckScope panics unless ctx comes from WithTeam or Unscoped. Stores call it
+// on every query, so a code path that forgot to scope its context fails
+// instead of reading or changing every team's data.
+func CheckScope(ctx context.Context) {
+	if _, ok := ctx.Value(scopeKey{}).(scope); !ok {
+		panic("store: context is neither scoped to a team nor Unscoped")
+	}
+}
+
+// TeamID returns the team ctx is scoped to, or "" if it is Unscoped.
+func TeamID(ctx context.Context) string {
+	CheckScope(ctx)
+	return ctx.Value(scopeKey{}).(scope).teamID
+}
+
+// InTeam reports whether a row owned by teamID is visible with ctx.
+func InTeam(ctx context.Context, teamID string) bool {
+	scope := TeamID(ctx)
+	return scope == "" || scope == teamID
+}
+
+// OwnerTeam returns the team owning rows created with ctx.
+func OwnerTeam(ctx context.Context) string {
+	if id := TeamID(ctx); id != "" {
+		return id
+	}
+	return model.DefaultTeamID
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	ctx := context.Background()
+
+	if got := TeamID(WithTeam(ctx, "t1")); got != "t1" {
+		t.Fatalf("TeamID = %q, want t1", got)
+	}
+	if got := TeamID(Unscoped(ctx)); got != "" {
+		t.Fatalf("TeamID of Unscoped = %q, want empty", got)
+	}
+	if !InTeam(Unscoped(ctx), "t2") || InTeam(WithTeam(ctx, "t1"), "t2") {
+		t.Fatal("InTeam: Unscoped must see every team, WithTeam only its own")
+	}
+}
+
+func TestPlainContextIsRejected(t *testing.T) {
+	for name, fn := range map[string]func(){
+		"CheckScope":     func() { CheckScope(context.Background()) },
+		"TeamID":         func() { TeamID(context.Background()) },
+		"WithTeam(\"\")": func() { WithTeam(context.Background(), "") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("did not panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mohsen/alertinGo/store"
+)
+
+// A monitor is flapping when it changes state (down or back up) at least
+// flapThreshold times within flapWindow. It is considered stable again once
+// the transitions in the window drop below half the threshold.
+func (w *Watcher) loadFlapConfig() {
+	if v := os.Getenv("FLAP_WINDOW"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("FLAP_WINDOW must be a positive number of seconds, got: %s", v)
+		}
+		w.flapWindow = time.Duration(n) * time.Second
+	}
+	if v := os.Getenv("FLAP_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			log.Fatalf("FLAP_THRESHOLD must be a number >= 2, got: %s", v)
+		}
+		w.flapThreshold = n
+	}
+}
+
+// detectFlapping is called after a monitor changed state. It reports whether
+// the monitor is flapping, in which case individual alerts are suppressed, and
+// sends a single "flapping" notification when the monitor starts flapping.
+func (w *Watcher) detectFlapping(ctx context.Context, om store.OverdueMonitor) bool {
+	if om.IsFlapping {
+		return true
+	}
+
+	transitions, err := w.store.CountStateTransitions(ctx, om.ID, w.flapWindow)
+	if err != nil {
+		log.Printf("[watcher] error counting transitions for monitor %s: %v", om.ID, err)
+		return false
+	}
+	if transitions < w.flapThreshold {
+		return false
+	}
+
+	if err := w.store.SetMonitorFlapping(ctx, om.ID, true); err != nil {
+		log.Printf("[watcher] error marking monitor %s as flapping: %v", om.ID, err)
+		return false
+	}
+
+	msg := fmt.Sprintf("🟡 *FLAPPING: %s (%s)*\nChanged state %d times in the last %s\nAlerts are paused until it stabilizes",
+		om.MonitorName, om.CheckType, transitions, formatDuration(w.flapWindow))
+	w.notify(ctx, om, "flapping", msg)
+	return true
+}
+
+// checkFlapping clears the flapping state of monitors that have stabilized and
+// reports the state they settled in.
+func (w *Watcher) checkFlapping(ctx context.Context) {
+	monitors, err := w.store.GetFlappingMonitors(ctx)
+	if err != nil {
+		log.Printf("[watcher] error fetching flapping monitors: %v", err)
+		return
+	}
+
+	for _, om := range monitors {
+		if ctx.Err() != nil || !w.elector.IsLeader() {
+			return
+		}
+		err := w.transition(ctx, func(ctx context.Context) error {
+			locked, err := w.store.LockFlappingMonitor(ctx, om.ID)
+			if err != nil {
+				return err
+			}
+			return w.handleFlapping(ctx, *locked)
+		})
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[watcher] error processing flapping monitor %s: %v", om.ID, err)
+		}
+	}
+}
+
+// handleFlapping clears the flapping state of a locked monitor once it has
+// stabilized.
+func (w *Watcher) handleFlapping(ctx context.Context, om store.OverdueMonitor) error {
+	transitions, err := w.store.CountStateTransitions(ctx, om.ID, w.flapWindow)
+	if err != nil {
+		return fmt.Errorf("counting transitions: %w", err)
+	}
+	if transitions*2 >= w.flapThreshold {
+		return nil
+	}
+
+	if err := w.store.SetMonitorFlapping(ctx, om.ID, false); err != nil {
+		return fmt.Errorf("clearing flapping state: %w", err)
+	}
+
+	state := "🟢 currently UP"
+	if om.Status == "down" {
+		state = "🔴 currently DOWN"
+	}
+	msg := fmt.Sprintf("*STABLE: %s (%s) stopped flapping*\n%s\nWas flapping for: %s",
+		om.MonitorName, om.CheckType, state, formatDuration(w.clock.Now().Sub(*om.FlappingSince)))
+	w.notify(ctx, om, "flapping_stopped", msg)
+	return nil
+}
@@ -0,0 +1,189 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohsen/alertinGo/store"
+)
+
+// Notifications are grouped by the monitor fields listed in ALERT_GROUP_BY
+// (server_name, check_type or label:<name>) and the destination chat. A new
+// group waits groupWait to collect related notifications before its first
+// digest; later digests only describe changes and go out at most once per
+// groupInterval. A group with nothing new for groupInterval is closed.
+type alertGroup struct {
+	chatID    string
+	title     string
+	pending   []notification
+	createdAt time.Time
+	flushedAt time.Time
+}
+
+func (w *Watcher) loadGroupConfig() {
+	if v := os.Getenv("ALERT_GROUP_BY"); v != "" {
+		for _, key := range strings.Split(v, ",") {
+			key = strings.TrimSpace(key)
+			if key != "server_name" && key != "check_type" && !strings.HasPrefix(key, "label:") {
+				log.Fatalf("ALERT_GROUP_BY supports server_name, check_type and label:<name>, got: %s", key)
+			}
+			w.groupBy = append(w.groupBy, key)
+		}
+	}
+	if v := os.Getenv("ALERT_GROUP_WAIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("ALERT_GROUP_WAIT must be a non-negative number of seconds, got: %s", v)
+		}
+		w.groupWait = time.Duration(n) * time.Second
+	}
+	if v := os.Getenv("ALERT_GROUP_INTERVAL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("ALERT_GROUP_INTERVAL must be a positive number of seconds, got: %s", v)
+		}
+		w.groupInterval = time.Duration(n) * time.Second
+	}
+}
+
+// groupLabels returns the "key=value" pairs identifying the monitor's group.
+func (w *Watcher) groupLabels(om store.OverdueMonitor) []string {
+	labels := make([]string, 0, len(w.groupBy))
+	for _, key := range w.groupBy {
+		var value string
+		switch {
+		case key == "server_name":
+			value = om.ServerName
+		case key == "check_type":
+			value = om.CheckType
+		default:
+			name := strings.TrimPrefix(key, "label:")
+			value = om.Labels[name]
+			key = name
+		}
+		labels = append(labels, key+"="+value)
+	}
+	return labels
+}
+
+func (w *Watcher) enqueue(om store.OverdueMonitor, alertType, msg string) {
+	labels := w.groupLabels(om)
+	key := om.TelegramChatID + "|" + strings.Join(labels, "|")
+
+	w.groupsMu.Lock()
+	defer w.groupsMu.Unlock()
+
+	g, ok := w.groups[key]
+	if !ok {
+		g = &alertGroup{
+			chatID:    om.TelegramChatID,
+			title:     strings.Join(labels, ", "),
+			createdAt: w.clock.Now(),
+		}
+		w.groups[key] = g
+	}
+	g.pending = append(g.pending, notification{om: om, alertType: alertType, msg: msg})
+}
+
+func (w *Watcher) flushGroups(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.clock.After(time.Second):
+		}
+		for _, batch := range w.dueGroups(false) {
+			w.sendDigest(batch.group, batch.events, batch.first)
+		}
+	}
+}
+
+// flushAllGroups sends every pending notification right away, used on shutdown.
+func (w *Watcher) flushAllGroups() {
+	for _, batch := range w.dueGroups(true) {
+		w.sendDigest(batch.group, batch.events, batch.first)
+	}
+}
+
+type dueBatch struct {
+	group  *alertGroup
+	events []notification
+	first  bool
+}
+
+// dueGroups takes the pending events of every group whose wait or interval
+// has elapsed (or of all groups, if all is set) and closes groups that stayed
+// quiet for a whole interval.
+func (w *Watcher) dueGroups(all bool) []dueBatch {
+	w.groupsMu.Lock()
+	defer w.groupsMu.Unlock()
+
+	now := w.clock.Now()
+	var due []dueBatch
+	for key, g := range w.groups {
+		first := g.flushedAt.IsZero()
+		switch {
+		case all && len(g.pending) > 0,
+			first && now.Sub(g.createdAt) >= w.groupWait,
+			!first && len(g.pending) > 0 && now.Sub(g.flushedAt) >= w.groupInterval:
+			due = append(due, dueBatch{group: g, events: g.pending, first: first})
+			g.pending = nil
+			g.flushedAt = now
+		case !first && len(g.pending) == 0 && now.Sub(g.flushedAt) >= w.groupInterval:
+			delete(w.groups, key)
+		}
+	}
+	return due
+}
+
+func (w *Watcher) sendDigest(g *alertGroup, events []notification, first bool) {
+	ctx := store.Unscoped(context.Background())
+
+	// A lone notification is sent as-is, there is nothing to aggregate
+	msg := events[0].msg
+	if len(events) > 1 || !first {
+		msg = digestMessage(g.title, events, first)
+	}
+
+	success, errMsg := w.Send(g.chatID, msg)
+	for _, e := range events {
+		w.store.CreateNotificationLog(ctx, e.om.ID, e.om.ChannelID, e.alertType, msg, success, errMsg)
+	}
+}
+
+// markdownEscaper escapes group titles such as "server_name=web-1", whose
+// underscores would otherwise open an italic entity in Telegram Markdown.
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+func digestMessage(title string, events []notification, first bool) string {
+	counts := map[string]int{}
+	for _, e := range events {
+		counts[e.alertType]++
+	}
+
+	var summary []string
+	for alertType, n := range counts {
+		summary = append(summary, fmt.Sprintf("%d %s", n, strings.ReplaceAll(alertType, "_", " ")))
+	}
+	sort.Strings(summary)
+
+	var b strings.Builder
+	if first {
+		fmt.Fprintf(&b, "📋 *%s*\n", markdownEscaper.Replace(title))
+	} else {
+		fmt.Fprintf(&b, "📋 *Update: %s*\n", markdownEscaper.Replace(title))
+	}
+	fmt.Fprintf(&b, "%s\n", strings.Join(summary, ", "))
+	for _, e := range events {
+		// Each entry is the headline of the individual notification
+		headline, _, _ := strings.Cut(e.msg, "\n")
+		fmt.Fprintf(&b, "\n%s", headline)
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+// grouped is a fixture grouping notifications by check type, with the
+// default wait and interval.
+func grouped(t *testing.T) *fixture {
+	f := newFixture(t)
+	f.w.groupBy = []string{"check_type"}
+	return f
+}
+
+// flushAfter advances the clock by d and sends the digests that are due, as
+// flushGroups does every second.
+func (f *fixture) flushAfter(d time.Duration) {
+	f.clock.Advance(d)
+	for _, batch := range f.w.dueGroups(false) {
+		f.w.sendDigest(batch.group, batch.events, batch.first)
+	}
+}
+
+func TestGroupWaitBatches(t *testing.T) {
+	f := grouped(t)
+	f.named("web-1", 60, 3600)
+	f.named("web-2", 60, 3600)
+
+	f.reconcileAfter(61 * time.Second)
+	f.flushAfter(29 * time.Second)
+	f.expect()
+
+	f.flushAfter(time.Second)
+	f.expect("📋 *check\\_type=http*\n2 alert\n\n🔴 *ALERT: web-1 (http) is DOWN*\n🔴 *ALERT: web-2 (http) is DOWN*")
+}
+
+func TestGroupIntervalUpdates(t *testing.T) {
+	f := grouped(t)
+	m := f.named("web-1", 60, 3600)
+	f.named("web-2", 60, 3600)
+
+	f.reconcileAfter(61 * time.Second)
+	f.flushAfter(30 * time.Second)
+	f.expect("📋 *check\\_type=http*\n2 alert")
+
+	// Later digests wait for the interval and describe only what changed,
+	// even a single change
+	f.heartbeat(m)
+	f.reconcileAfter(0)
+	f.flushAfter(299 * time.Second)
+	f.expect()
+
+	f.flushAfter(time.Second)
+	f.expect("📋 *Update: check\\_type=http*\n1 recovered\n\n🟢 *RECOVERED: web-1 (http) is back UP*")
+}
+
+func TestQuietGroupCloses(t *testing.T) {
+	f := grouped(t)
+	m := f.monitor(60, 3600)
+
+	// A lone notification goes out as it is
+	f.reconcileAfter(61 * time.Second)
+	f.flushAfter(30 * time.Second)
+	f.expect("🔴 *ALERT: web-1 (http) is DOWN*")
+
+	f.flushAfter(5 * time.Minute)
+	if len(f.w.groups) != 0 {
+		t.Fatalf("%d groups still open after a quiet interval", len(f.w.groups))
+	}
+
+	// The next notification opens a new group, which waits again and sends
+	// a first digest rather than an update
+	f.heartbeat(m)
+	f.reconcileAfter(0)
+	f.flushAfter(29 * time.Second)
+	f.expect()
+
+	f.flushAfter(time.Second)
+	f.expect("🟢 *RECOVERED: web-1 (http) is back UP*")
+}
+
+func TestDigestMessage(t *testing.T) {
+	events := []notification{
+		{alertType: "re_alert", msg: "🔴 *RE-ALERT: db (tcp) still DOWN*\nDown for: 5m 0s"},
+		{alertType: "alert", msg: "🔴 *ALERT: web-1 (http) is DOWN*\nLast seen: 1m 1s ago"},
+		{alertType: "alert", msg: "🔴 *ALERT: web-2 (http) is DOWN*\nLast seen: 1m 1s ago"},
+	}
+
+	want := "📋 *server\\_name=web*\n1 re alert, 2 alert\n\n" +
+		"🔴 *RE-ALERT: db (tcp) still DOWN*\n🔴 *ALERT: web-1 (http) is DOWN*\n🔴 *ALERT: web-2 (http) is DOWN*"
+	if got := digestMessage("server_name=web", events, true); got != want {
+		t.Errorf("first digest is %q, want %q", got, want)
+	}
+	if got := digestMessage("server_name=web", events[:1], false); got != "📋 *Update: server\\_name=web*\n1 re alert\n\n🔴 *RE-ALERT: db (tcp) still DOWN*" {
+		t.Errorf("update digest is %q", got)
+	}
+}
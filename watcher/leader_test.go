@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// Two watchers sharing a Postgres database elect one leader, so an overdue
+// monitor pages once.
+func TestTwoInstancesAlertOnce(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := store.Unscoped(context.Background())
+	name := "two-instances-" + store.NewID()
+
+	var sent atomic.Int32
+	var stores []*db.Postgres
+	var watchers []*Watcher
+	for range 2 {
+		st, err := db.Connect(ctx, dsn, clock.Real{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(st.Close)
+		if err := st.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+		w := New(st, clock.Real{})
+		w.Send = func(chatID, msg string) (bool, string) {
+			// Other monitors in the database may page too
+			if strings.Contains(msg, name) {
+				sent.Add(1)
+			}
+			return true, ""
+		}
+		stores = append(stores, st)
+		watchers = append(watchers, w)
+	}
+
+	st := stores[0]
+	ch, err := st.CreateChannel(ctx, "two instances", "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.DeleteChannel(ctx, ch.ID) })
+	m, err := st.UpsertMonitor(ctx, &model.Monitor{
+		MonitorName: name, CheckType: "test", Metadata: "{}",
+		Timeout: 1, ReAlertInterval: 3600, AlertAfterMisses: 1, RecoverAfterHeartbeats: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.DeleteMonitor(ctx, m.ID) })
+	if m, err = st.UpdateMonitor(ctx, m.ID, true, &ch.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range watchers {
+		w.Start(ctx)
+		w.Touch(m)
+	}
+	defer func() {
+		for _, w := range watchers {
+			w.Stop()
+		}
+	}()
+
+	deadline := time.Now().Add(15 * time.Second)
+	for sent.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Give the other instance a reconcile pass to send it again
+	time.Sleep(6 * time.Second)
+
+	if n := sent.Load(); n != 1 {
+		t.Fatalf("sent %d alerts, want 1", n)
+	}
+	if watchers[0].elector.IsLeader() == watchers[1].elector.IsLeader() {
+		t.Fatal("want exactly one leader")
+	}
+}
@@ -0,0 +1,153 @@
+package watcher
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+)
+
+// scheduler wakes up exactly when a monitor's next deadline (becoming overdue
+// or being due for a re-alert) passes. Deadlines are only hints: the monitor
+// is re-checked against the database when it fires, so a stale entry costs a
+// query, never a wrong alert.
+type scheduler struct {
+	clock clock.Clock
+	mu    sync.Mutex
+	queue deadlineQueue
+	byID  map[string]*deadline
+	wake  chan struct{}
+}
+
+type deadline struct {
+	monitorID string
+	at        time.Time
+	index     int
+}
+
+func newScheduler(clk clock.Clock) *scheduler {
+	return &scheduler{
+		clock: clk,
+		byID:  map[string]*deadline{},
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Schedule sets (or moves) the deadline of a monitor.
+func (s *scheduler) Schedule(monitorID string, at time.Time) {
+	s.mu.Lock()
+	if d, ok := s.byID[monitorID]; ok {
+		d.at = at
+		heap.Fix(&s.queue, d.index)
+	} else {
+		d := &deadline{monitorID: monitorID, at: at}
+		heap.Push(&s.queue, d)
+		s.byID[monitorID] = d
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Remove drops a monitor that no longer needs watching.
+func (s *scheduler) Remove(monitorID string) {
+	s.mu.Lock()
+	if d, ok := s.byID[monitorID]; ok {
+		heap.Remove(&s.queue, d.index)
+		delete(s.byID, monitorID)
+	}
+	s.mu.Unlock()
+}
+
+// Reset replaces every deadline, e.g. with a fresh snapshot from the database.
+func (s *scheduler) Reset(deadlines map[string]time.Time) {
+	s.mu.Lock()
+	s.queue = s.queue[:0]
+	s.byID = make(map[string]*deadline, len(deadlines))
+	for id, at := range deadlines {
+		d := &deadline{monitorID: id, at: at, index: len(s.queue)}
+		s.queue = append(s.queue, d)
+		s.byID[id] = d
+	}
+	heap.Init(&s.queue)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Run calls fire for each monitor whose deadline passed, removing it from the
+// queue, until ctx is cancelled. fire is expected to schedule the monitor's
+// next deadline.
+func (s *scheduler) Run(ctx context.Context, fire func(ctx context.Context, monitorID string)) {
+	for {
+		for _, id := range s.popDue(s.clock.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+			fire(ctx, id)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.untilNext()):
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *scheduler) popDue(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []string
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		d := heap.Pop(&s.queue).(*deadline)
+		delete(s.byID, d.monitorID)
+		due = append(due, d.monitorID)
+	}
+	return due
+}
+
+func (s *scheduler) untilNext() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return time.Hour
+	}
+	return s.queue[0].at.Sub(s.clock.Now())
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deadlineQueue is a min-heap of deadlines, see container/heap.
+type deadlineQueue []*deadline
+
+func (q deadlineQueue) Len() int           { return len(q) }
+func (q deadlineQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q deadlineQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *deadlineQueue) Push(x any) {
+	d := x.(*deadline)
+	d.index = len(*q)
+	*q = append(*q, d)
+}
+
+func (q *deadlineQueue) Pop() any {
+	old := *q
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return d
+}
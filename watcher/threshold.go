@@ -0,0 +1,232 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// queuedHeartbeat is a heartbeat waiting for its threshold rules. seq orders
+// it against the heartbeats dropped from the queue.
+type queuedHeartbeat struct {
+	monitor *model.Monitor
+	seq     uint64
+}
+
+// QueueThresholds hands a freshly ingested heartbeat to the watcher to be
+// checked against its monitor's threshold rules. It never blocks: when the
+// queue is full the heartbeat is dropped, and since the breaches around it
+// are no longer consecutive, the breach counts of its monitor restart with
+// the next heartbeat evaluated.
+func (w *Watcher) QueueThresholds(m *model.Monitor) {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+
+	w.queued++
+	select {
+	case w.thresholds <- queuedHeartbeat{monitor: m, seq: w.queued}:
+	default:
+		w.dropped[m.ID] = w.queued
+		log.Printf("[threshold] queue full, skipping heartbeat of monitor %s and restarting its breach counts", m.ID)
+	}
+}
+
+// restarts reports whether a heartbeat of the same monitor was dropped before
+// hb was queued, since the last one evaluated.
+func (w *Watcher) restarts(hb queuedHeartbeat) bool {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+
+	seq, ok := w.dropped[hb.monitor.ID]
+	if !ok || hb.seq < seq {
+		return false
+	}
+	delete(w.dropped, hb.monitor.ID)
+	return true
+}
+
+// thresholdLoop evaluates queued heartbeats in the order they arrived.
+func (w *Watcher) thresholdLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case hb := <-w.thresholds:
+			w.EvaluateThresholds(ctx, hb.monitor, w.restarts(hb))
+		}
+	}
+}
+
+// drainThresholds evaluates what is still queued, once the loops stopped.
+func (w *Watcher) drainThresholds() {
+	for {
+		select {
+		case hb := <-w.thresholds:
+			w.EvaluateThresholds(store.Unscoped(context.Background()), hb.monitor, w.restarts(hb))
+		default:
+			return
+		}
+	}
+}
+
+// EvaluateThresholds checks the metadata of a freshly ingested heartbeat against
+// the monitor's threshold rules, firing, re-alerting or resolving threshold alerts.
+// With restart set, breach counts start over from this heartbeat.
+func (w *Watcher) EvaluateThresholds(ctx context.Context, m *model.Monitor, restart bool) {
+	rules, err := w.store.GetThresholdRules(ctx, m.ID)
+	if err != nil {
+		log.Printf("[threshold] error fetching rules for monitor %s: %v", m.ID, err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	var metadata map[string]interface{}
+	if err := json.Unmarshal([]byte(m.Metadata), &metadata); err != nil {
+		log.Printf("[threshold] monitor %s has non-object metadata, skipping rules", m.ID)
+		return
+	}
+
+	// Alerts are only sent for active monitors with a channel, same as timeouts
+	om := store.OverdueMonitor{Monitor: *m}
+	if m.IsActive && m.ChannelID != nil {
+		if ch, err := w.store.GetChannelByID(ctx, *m.ChannelID); err == nil {
+			om.TelegramChatID = ch.TelegramChatID
+		}
+	}
+
+	for _, rule := range rules {
+		value, ok := metricValue(metadata, rule.Metric)
+		if !ok {
+			continue
+		}
+
+		err := w.transition(ctx, func(ctx context.Context) error {
+			locked, err := w.store.LockThresholdRule(ctx, rule.ID)
+			if err != nil {
+				return err
+			}
+			if restart {
+				locked.BreachCount = 0
+			}
+			return w.evaluateRule(ctx, om, *locked, value)
+		})
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[threshold] error evaluating rule %s: %v", rule.ID, err)
+		}
+	}
+}
+
+// evaluateRule updates the breach count of a locked rule and fires, re-alerts
+// or resolves its alert.
+func (w *Watcher) evaluateRule(ctx context.Context, om store.OverdueMonitor, rule model.ThresholdRule, value float64) error {
+	count := 0
+	if breaches(rule.Operator, value, rule.Threshold) {
+		count = rule.BreachCount + 1
+	}
+	if count != rule.BreachCount {
+		if err := w.store.SetRuleBreachCount(ctx, rule.ID, count); err != nil {
+			return fmt.Errorf("updating breach count: %w", err)
+		}
+	}
+
+	alert, err := w.store.GetFiringThresholdAlert(ctx, rule.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("fetching alert: %w", err)
+	}
+
+	condition := fmt.Sprintf("%s %s %s", rule.Metric, rule.Operator, formatValue(rule.Threshold))
+
+	switch {
+	case count >= rule.Consecutive && alert == nil:
+		if om.TelegramChatID == "" {
+			return nil
+		}
+		created, err := w.store.CreateThresholdAlertState(ctx, om.ID, rule.ID)
+		if err != nil {
+			return fmt.Errorf("creating alert state: %w", err)
+		}
+		if !created {
+			return nil
+		}
+
+		msg := fmt.Sprintf("🟠 *THRESHOLD: %s (%s)*\n%s for %d consecutive heartbeats\nCurrent: %s\nMessage: %s",
+			om.MonitorName, om.CheckType, condition, count, formatValue(value), om.Message)
+		w.notify(ctx, om, "alert", msg)
+
+	case count >= rule.Consecutive:
+		if om.TelegramChatID == "" || alert.AcknowledgedAt != nil || w.clock.Now().Sub(alert.LastAlertedAt) < time.Duration(om.ReAlertInterval)*time.Second {
+			return nil
+		}
+		if err := w.store.UpdateAlertLastAlerted(ctx, alert.ID); err != nil {
+			return fmt.Errorf("updating alert %s: %w", alert.ID, err)
+		}
+
+		msg := fmt.Sprintf("🟠 *RE-ALERT: %s (%s) still over threshold*\n%s for %s\nCurrent: %s\nMessage: %s",
+			om.MonitorName, om.CheckType, condition, formatDuration(w.clock.Now().Sub(alert.FiredAt)), formatValue(value), om.Message)
+		w.notify(ctx, om, "re_alert", msg)
+
+	case count == 0 && alert != nil:
+		if err := w.store.ResolveAlert(ctx, alert.ID); err != nil {
+			return fmt.Errorf("resolving alert %s: %w", alert.ID, err)
+		}
+		if om.TelegramChatID == "" {
+			return nil
+		}
+
+		msg := fmt.Sprintf("🟢 *RECOVERED: %s (%s) back within threshold*\n%s no longer true (current: %s)\nWas firing for: %s%s",
+			om.MonitorName, om.CheckType, condition, formatValue(value), formatDuration(w.clock.Now().Sub(alert.FiredAt)), acknowledgedBy(alert))
+		w.notify(ctx, om, "recovered", msg)
+	}
+	return nil
+}
+
+// metricValue resolves a dotted path such as "disk.root.used_percent" in the
+// heartbeat metadata. Only numeric values can be compared against a threshold.
+func metricValue(metadata map[string]interface{}, metric string) (float64, bool) {
+	var current interface{} = metadata
+	for _, part := range strings.Split(metric, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		current, ok = obj[part]
+		if !ok {
+			return 0, false
+		}
+	}
+
+	value, ok := current.(float64)
+	return value, ok
+}
+
+func breaches(operator string, value, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+)
+
+// rule adds a threshold rule to a monitor.
+func (f *fixture) rule(m *model.Monitor, metric, operator string, threshold float64, consecutive int) *model.ThresholdRule {
+	f.t.Helper()
+	r, err := f.store.CreateThresholdRule(f.ctx, &model.ThresholdRule{
+		MonitorID: m.ID, Metric: metric, Operator: operator, Threshold: threshold, Consecutive: consecutive,
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return r
+}
+
+// report records a heartbeat of m carrying metadata.
+func (f *fixture) report(m *model.Monitor, metadata string) *model.Monitor {
+	f.t.Helper()
+	m.Metadata = metadata
+	return f.heartbeat(m)
+}
+
+func TestDroppedHeartbeatRestartsBreachCount(t *testing.T) {
+	f := newFixture(t)
+	f.w.thresholds = make(chan queuedHeartbeat, 1)
+	m := f.monitor(60, 300)
+	f.rule(m, "cpu", ">", 90, 2)
+
+	// The heartbeat within the threshold is dropped, so the two breaches
+	// around it are not consecutive
+	f.w.QueueThresholds(f.report(m, `{"cpu": 95}`))
+	f.w.QueueThresholds(f.report(m, `{"cpu": 10}`))
+	f.w.drainThresholds()
+	f.w.QueueThresholds(f.report(m, `{"cpu": 95}`))
+	f.w.drainThresholds()
+	f.expect()
+
+	f.w.QueueThresholds(f.report(m, `{"cpu": 95}`))
+	f.w.drainThresholds()
+	f.expect("THRESHOLD")
+}
+
+// evaluate reports a heartbeat of m and evaluates its threshold rules.
+func (f *fixture) evaluate(m *model.Monitor, metadata string) {
+	f.t.Helper()
+	f.w.EvaluateThresholds(f.ctx, f.report(m, metadata), false)
+}
+
+func TestThresholdConsecutiveBreaches(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+	f.rule(m, "cpu", ">", 90, 3)
+
+	f.evaluate(m, `{"cpu": 95}`)
+	f.evaluate(m, `{"cpu": 95}`)
+	f.expect()
+
+	// A heartbeat within the threshold starts the count over
+	f.evaluate(m, `{"cpu": 90}`)
+	f.evaluate(m, `{"cpu": 95}`)
+	f.evaluate(m, `{"cpu": 95}`)
+	f.expect()
+
+	f.evaluate(m, `{"cpu": 95}`)
+	f.expect("THRESHOLD: web-1 (http)*\ncpu > 90 for 3 consecutive heartbeats\nCurrent: 95")
+}
+
+func TestThresholdReAlertAndRecovery(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+	rule := f.rule(m, "cpu", ">=", 90, 1)
+
+	f.evaluate(m, `{"cpu": 90}`)
+	f.expect("THRESHOLD")
+
+	f.clock.Advance(299 * time.Second)
+	f.evaluate(m, `{"cpu": 91}`)
+	f.expect()
+
+	f.clock.Advance(time.Second)
+	f.evaluate(m, `{"cpu": 92}`)
+	f.expect("RE-ALERT: web-1 (http) still over threshold*\ncpu >= 90 for 5m 0s\nCurrent: 92")
+
+	// Metadata without the metric leaves the alert as it is
+	f.clock.Advance(time.Minute)
+	f.evaluate(m, `{"memory": 10}`)
+	f.expect()
+
+	f.evaluate(m, `{"cpu": 50}`)
+	f.expect("RECOVERED: web-1 (http) back within threshold*\ncpu >= 90 no longer true (current: 50)\nWas firing for: 6m 0s")
+	if _, err := f.store.GetFiringThresholdAlert(f.ctx, rule.ID); err != store.ErrNotFound {
+		t.Fatalf("threshold alert still firing: %v", err)
+	}
+}
+
+func TestThresholdDottedMetric(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+	f.rule(m, "disk.used_pct", ">", 80, 1)
+
+	f.evaluate(m, `{"disk": 85}`)
+	f.evaluate(m, `{"disk.used_pct": 85}`)
+	f.evaluate(m, `{"disk": {"used_pct": "85"}}`)
+	f.expect()
+
+	f.evaluate(m, `{"disk": {"used_pct": 85.5, "free_gb": 3}}`)
+	f.expect("disk.used_pct > 80 for 1 consecutive heartbeats\nCurrent: 85.5")
+}
+
+func TestMetricValue(t *testing.T) {
+	metadata := map[string]interface{}{
+		"cpu":  42.5,
+		"name": "web-1",
+		"disk": map[string]interface{}{"root": map[string]interface{}{"used_pct": 71.0}},
+	}
+	for _, tt := range []struct {
+		metric string
+		want   float64
+		ok     bool
+	}{
+		{"cpu", 42.5, true},
+		{"disk.root.used_pct", 71, true},
+		{"disk.root", 0, false},
+		{"disk.root.used_pct.more", 0, false},
+		{"disk.home.used_pct", 0, false},
+		{"name", 0, false},
+		{"memory", 0, false},
+	} {
+		got, ok := metricValue(metadata, tt.metric)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("metricValue(%q) = %v, %v, want %v, %v", tt.metric, got, ok, tt.want, tt.ok)
+		}
+	}
+}
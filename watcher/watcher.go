@@ -2,109 +2,519 @@ package watcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/mohsen/alertinGo/db"
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/leader"
+	"github.com/mohsen/alertinGo/model"
 	"github.com/mohsen/alertinGo/notifier"
+	"github.com/mohsen/alertinGo/store"
 )
 
-func Start() {
-	ticker := time.NewTicker(10 * time.Second)
+// Watcher raises, repeats and resolves alerts for the monitors in a store.
+type Watcher struct {
+	store store.Store
+	// clock drives every timeout, re-alert and recovery decision. It should be
+	// the clock the store was opened with.
+	clock clock.Clock
+
+	// Send delivers a message to a Telegram chat and reports (success,
+	// errorMessage), see notifier.SendTelegram.
+	Send func(chatID, message string) (bool, string)
+
+	// elector makes sure only one instance runs the watcher loop, so replicas
+	// don't send every alert twice.
+	elector store.Elector
+
+	// deadlines wakes the watcher when a monitor becomes overdue or is due for
+	// a re-alert. reconcileInterval is how often the full polling pass runs as
+	// a safety net and reloads every deadline from the store.
+	deadlines         *scheduler
+	reconcileInterval time.Duration
+	recheckDelay      time.Duration
+
+	flapWindow    time.Duration
+	flapThreshold int
+
+	// thresholds holds heartbeats waiting for their threshold rules to be
+	// evaluated, so ingestion doesn't wait on it, see QueueThresholds. dropped
+	// holds, per monitor, the sequence number of its last heartbeat dropped
+	// from a full queue.
+	thresholds chan queuedHeartbeat
+	queueMu    sync.Mutex
+	queued     uint64
+	dropped    map[string]uint64
+
+	groupBy       []string
+	groupWait     time.Duration
+	groupInterval time.Duration
+	groupsMu      sync.Mutex
+	groups        map[string]*alertGroup
+
+	// Stop handles for the running watcher, see Start and Stop.
+	cancelLoops   context.CancelFunc
+	cancelElector context.CancelFunc
+	loops         sync.WaitGroup
+	electorDone   chan struct{}
+}
+
+// New creates a watcher for st, configured from the FLAP_* and ALERT_GROUP_*
+// environment variables.
+func New(st store.Store, clk clock.Clock) *Watcher {
+	w := &Watcher{
+		store:             st,
+		clock:             clk,
+		Send:              notifier.SendTelegram,
+		deadlines:         newScheduler(clk),
+		reconcileInterval: time.Minute,
+		recheckDelay:      30 * time.Second,
+		flapWindow:        10 * time.Minute,
+		flapThreshold:     6,
+		thresholds:        make(chan queuedHeartbeat, 1024),
+		dropped:           map[string]uint64{},
+		groupWait:         30 * time.Second,
+		groupInterval:     5 * time.Minute,
+		groups:            map[string]*alertGroup{},
+		electorDone:       make(chan struct{}),
+	}
+	w.loadFlapConfig()
+	w.loadGroupConfig()
+	return w
+}
+
+// Start runs the watcher until ctx is cancelled or Stop is called.
+func (w *Watcher) Start(ctx context.Context) {
+	// Leadership outlives the loops, so no other instance takes over while
+	// in-flight checks are still finishing during shutdown
+	var electorCtx context.Context
+	electorCtx, w.cancelElector = context.WithCancel(context.WithoutCancel(ctx))
+	hostname, _ := os.Hostname()
+	w.elector = w.store.NewElector(fmt.Sprintf("%s/%d", hostname, os.Getpid()), leader.WatcherLockID)
 	go func() {
-		for range ticker.C {
-			checkOverdue()
-			checkRecovered()
-		}
+		defer close(w.electorDone)
+		w.elector.Run(electorCtx)
 	}()
-	log.Println("watcher started (every 10s)")
+
+	// The watcher looks after every team's monitors
+	ctx, w.cancelLoops = context.WithCancel(store.Unscoped(ctx))
+	w.goLoop(func() { w.deadlines.Run(ctx, w.checkMonitor) })
+	w.goLoop(func() { w.reconcileLoop(ctx) })
+	w.goLoop(func() { w.listenRecoveries(ctx) })
+	w.goLoop(func() { w.listenDeadlines(ctx) })
+	w.goLoop(func() { w.thresholdLoop(ctx) })
+	if len(w.groupBy) > 0 {
+		w.goLoop(func() { w.flushGroups(ctx) })
+	}
+	log.Printf("watcher started (deadline scheduler, reconciling every %s)", w.reconcileInterval)
 }
 
-func checkOverdue() {
-	ctx := context.Background()
+// Stop stops the watcher loops, waits for in-flight checks and notifications,
+// evaluates queued heartbeats, sends any digests still waiting in a group and
+// finally gives up leadership.
+func (w *Watcher) Stop() {
+	w.cancelLoops()
+	w.loops.Wait()
+	w.drainThresholds()
+	w.flushAllGroups()
 
-	monitors, err := db.GetOverdueMonitors(ctx)
+	w.cancelElector()
+	<-w.electorDone
+	log.Println("watcher stopped")
+}
+
+func (w *Watcher) goLoop(fn func()) {
+	w.loops.Add(1)
+	go func() {
+		defer w.loops.Done()
+		fn()
+	}()
+}
+
+func (w *Watcher) reconcileLoop(ctx context.Context) {
+	var leading bool
+	var lastReconcile time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.clock.After(5 * time.Second):
+		}
+
+		if !w.elector.IsLeader() {
+			leading = false
+			continue
+		}
+		// Reconcile right away after taking over, our deadlines may be stale
+		if leading && w.clock.Now().Sub(lastReconcile) < w.reconcileInterval {
+			continue
+		}
+		leading = true
+		lastReconcile = w.clock.Now()
+		w.reconcile(ctx)
+	}
+}
+
+// Touch moves a monitor's deadline after a heartbeat or activation. It only
+// reaches this instance's scheduler, the leader hears about it through
+// ListenDeadlineChanges.
+func (w *Watcher) Touch(m *model.Monitor) {
+	if !m.IsActive || m.ChannelID == nil {
+		return
+	}
+	timeout := time.Duration(m.Timeout*m.AlertAfterMisses) * time.Second
+	w.deadlines.Schedule(m.ID, m.LastSeenAt.Add(timeout))
+}
+
+func (w *Watcher) reconcile(ctx context.Context) {
+	w.checkOverdue(ctx)
+	w.checkRecovered(ctx)
+	w.checkFlapping(ctx)
+
+	all, err := w.store.GetMonitorDeadlines(ctx)
 	if err != nil {
-		log.Printf("[watcher] error fetching overdue monitors: %v", err)
+		log.Printf("[watcher] error loading deadlines: %v", err)
 		return
 	}
+	w.deadlines.Reset(all)
+}
 
-	for _, om := range monitors {
-		// Mark monitor as down
-		if om.Status != "down" {
-			if err := db.SetMonitorStatus(ctx, om.ID, "down"); err != nil {
-				log.Printf("[watcher] error setting monitor %s to down: %v", om.ID, err)
-				continue
+// checkMonitor runs when a monitor's deadline passes.
+func (w *Watcher) checkMonitor(ctx context.Context, id string) {
+	if !w.elector.IsLeader() {
+		return
+	}
+
+	w.processOverdue(ctx, id)
+
+	next, err := w.store.GetMonitorDeadline(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("[watcher] error fetching deadline of monitor %s: %v", id, err)
+	}
+
+	// Suppressed, acknowledged or flapping monitors don't move their deadline when checked,
+	// look at them again a little later instead of spinning
+	if now := w.clock.Now(); !next.After(now) {
+		next = now.Add(w.recheckDelay)
+	}
+	w.deadlines.Schedule(id, next)
+}
+
+// listenRecoveries processes recoveries as soon as a heartbeat brings a monitor
+// back up, instead of waiting for the next tick. The polling loop still picks
+// up anything missed while the listener was reconnecting.
+func (w *Watcher) listenRecoveries(ctx context.Context) {
+	w.listen(ctx, "recovery", w.store.ListenRecoveries, w.recoverMonitor)
+}
+
+// listenDeadlines reschedules monitors whose heartbeats or updates were
+// received by another instance, whose Touch only reached its own scheduler.
+func (w *Watcher) listenDeadlines(ctx context.Context) {
+	w.listen(ctx, "deadline", w.store.ListenDeadlineChanges, w.refreshDeadline)
+}
+
+// listen hands the monitors announced by a store listener to fn while this
+// instance leads, reconnecting until ctx is cancelled.
+func (w *Watcher) listen(ctx context.Context, name string, listen func(context.Context, func(string)) error, fn func(context.Context, string)) {
+	for {
+		err := listen(ctx, func(monitorID string) {
+			if w.elector.IsLeader() {
+				fn(ctx, monitorID)
 			}
+		})
+		if ctx.Err() != nil {
+			return
 		}
 
-		// Check existing alert state
-		alert, err := db.GetFiringAlert(ctx, om.ID)
-		if err != nil && err != pgx.ErrNoRows {
-			log.Printf("[watcher] error fetching alert for monitor %s: %v", om.ID, err)
-			continue
+		log.Printf("[watcher] %s listener stopped, reconnecting in 5s: %v", name, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
 		}
+	}
+}
 
-		downSince := time.Since(om.LastSeenAt)
+// refreshDeadline reloads a monitor's deadline from the store.
+func (w *Watcher) refreshDeadline(ctx context.Context, id string) {
+	next, err := w.store.GetMonitorDeadline(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		w.deadlines.Remove(id)
+		return
+	}
+	if err != nil {
+		log.Printf("[watcher] error fetching deadline of monitor %s: %v", id, err)
+		return
+	}
+	w.deadlines.Schedule(id, next)
+}
 
-		if alert == nil {
-			// First alert — create alert state and fire
-			if err := db.CreateAlertState(ctx, om.ID); err != nil {
-				log.Printf("[watcher] error creating alert state for %s: %v", om.ID, err)
-				continue
-			}
+func (w *Watcher) checkOverdue(ctx context.Context) {
+	monitors, err := w.store.GetOverdueMonitors(ctx)
+	if err != nil {
+		log.Printf("[watcher] error fetching overdue monitors: %v", err)
+		return
+	}
 
-			msg := fmt.Sprintf("🔴 *ALERT: %s (%s) is DOWN*\nLast seen: %s ago\nTimeout: %ds\nMessage: %s",
-				om.MonitorName, om.CheckType, db.FormatDuration(downSince), om.Timeout, om.Message)
-			success, errMsg := notifier.SendTelegram(om.TelegramChatID, msg)
-			db.CreateNotificationLog(ctx, om.ID, om.ChannelID, "alert", msg, success, errMsg)
+	for _, om := range monitors {
+		// Stop as soon as we lose leadership, the new leader picks up the rest
+		if ctx.Err() != nil || !w.elector.IsLeader() {
+			return
+		}
+		w.processOverdue(ctx, om.ID)
+	}
+}
 
-		} else {
-			// Re-alert if re_alert_interval has passed
-			sinceLast := time.Since(alert.LastAlertedAt)
-			if sinceLast >= time.Duration(om.ReAlertInterval)*time.Second {
-				if err := db.UpdateAlertLastAlerted(ctx, alert.ID); err != nil {
-					log.Printf("[watcher] error updating alert %s: %v", alert.ID, err)
-					continue
-				}
-
-				msg := fmt.Sprintf("🔴 *RE-ALERT: %s (%s) still DOWN*\nDown for: %s\nMessage: %s",
-					om.MonitorName, om.CheckType, db.FormatDuration(downSince), om.Message)
-				success, errMsg := notifier.SendTelegram(om.TelegramChatID, msg)
-				db.CreateNotificationLog(ctx, om.ID, om.ChannelID, "re_alert", msg, success, errMsg)
-			}
+func (w *Watcher) processOverdue(ctx context.Context, id string) {
+	err := w.transition(ctx, func(ctx context.Context) error {
+		locked, err := w.store.LockOverdueMonitor(ctx, id)
+		if err != nil {
+			return err
 		}
+		return w.handleOverdue(ctx, *locked)
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("[watcher] error processing overdue monitor %s: %v", id, err)
 	}
 }
 
-func checkRecovered() {
-	ctx := context.Background()
+// handleOverdue marks a locked, overdue monitor as down and fires, re-fires or
+// suppresses its alert.
+func (w *Watcher) handleOverdue(ctx context.Context, om store.OverdueMonitor) error {
+	// Mark monitor as down, dropping any heartbeats counted towards recovery
+	if om.Status != "down" || om.RecoveryHeartbeats > 0 {
+		if err := w.store.SetMonitorStatus(ctx, om.ID, "down"); err != nil {
+			return fmt.Errorf("setting status to down: %w", err)
+		}
+	}
+
+	// Check existing alert state
+	alert, err := w.store.GetFiringAlert(ctx, om.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("fetching alert: %w", err)
+	}
 
-	monitors, err := db.GetRecoveredMonitors(ctx)
+	parentDown, err := w.store.HasDownParent(ctx, om.ID)
+	if err != nil {
+		return fmt.Errorf("checking parents: %w", err)
+	}
+
+	downSince := w.clock.Now().Sub(om.LastSeenAt)
+
+	switch {
+	case alert == nil:
+		// First alert — create alert state and fire, unless a parent is down and
+		// this monitor is already covered by the parent's notification
+		created, err := w.store.CreateAlertState(ctx, om.ID, parentDown)
+		if err != nil {
+			return fmt.Errorf("creating alert state: %w", err)
+		}
+		if !created || w.detectFlapping(ctx, om) || parentDown {
+			return nil
+		}
+
+		msg := fmt.Sprintf("🔴 *ALERT: %s (%s) is DOWN*\nLast seen: %s ago\nTimeout: %ds\nMessage: %s%s",
+			om.MonitorName, om.CheckType, formatDuration(downSince), om.Timeout, om.Message, w.affectedChildren(ctx, om.ID))
+		w.notify(ctx, om, "alert", msg)
+
+	case alert.Suppressed:
+		// Parent is no longer down but this monitor still is — alert on its own now
+		if parentDown {
+			return nil
+		}
+		if err := w.store.UnsuppressAlert(ctx, alert.ID); err != nil {
+			return fmt.Errorf("unsuppressing alert %s: %w", alert.ID, err)
+		}
+		if om.IsFlapping {
+			return nil
+		}
+
+		msg := fmt.Sprintf("🔴 *ALERT: %s (%s) is DOWN*\nLast seen: %s ago\nParent monitor recovered, this one did not\nMessage: %s%s",
+			om.MonitorName, om.CheckType, formatDuration(downSince), om.Message, w.affectedChildren(ctx, om.ID))
+		w.notify(ctx, om, "alert", msg)
+
+	default:
+		// Re-alert if re_alert_interval has passed, unless someone acknowledged
+		// the alert, alerts are paused by flapping or a parent is down
+		sinceLast := w.clock.Now().Sub(alert.LastAlertedAt)
+		if alert.AcknowledgedAt != nil || om.IsFlapping || parentDown || sinceLast < time.Duration(om.ReAlertInterval)*time.Second {
+			return nil
+		}
+		if err := w.store.UpdateAlertLastAlerted(ctx, alert.ID); err != nil {
+			return fmt.Errorf("updating alert %s: %w", alert.ID, err)
+		}
+
+		msg := fmt.Sprintf("🔴 *RE-ALERT: %s (%s) still DOWN*\nDown for: %s\nMessage: %s%s",
+			om.MonitorName, om.CheckType, formatDuration(downSince), om.Message, w.affectedChildren(ctx, om.ID))
+		w.notify(ctx, om, "re_alert", msg)
+	}
+	return nil
+}
+
+func (w *Watcher) checkRecovered(ctx context.Context) {
+	monitors, err := w.store.GetRecoveredMonitors(ctx)
 	if err != nil {
 		log.Printf("[watcher] error fetching recovered monitors: %v", err)
 		return
 	}
 
 	for _, om := range monitors {
-		alert, err := db.GetFiringAlert(ctx, om.ID)
+		if ctx.Err() != nil || !w.elector.IsLeader() {
+			return
+		}
+		w.recoverMonitor(ctx, om.ID)
+	}
+}
+
+func (w *Watcher) recoverMonitor(ctx context.Context, id string) {
+	err := w.transition(ctx, func(ctx context.Context) error {
+		locked, err := w.store.LockRecoveredMonitor(ctx, id)
 		if err != nil {
-			log.Printf("[watcher] error fetching alert for recovered monitor %s: %v", om.ID, err)
-			continue
+			return err
 		}
+		return w.handleRecovered(ctx, *locked)
+	})
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("[watcher] error processing recovered monitor %s: %v", id, err)
+	}
+}
 
-		downtime := time.Since(alert.FiredAt)
+// handleRecovered resolves the firing alert of a locked monitor that is back up.
+func (w *Watcher) handleRecovered(ctx context.Context, om store.OverdueMonitor) error {
+	alert, err := w.store.GetFiringAlert(ctx, om.ID)
+	if err != nil {
+		return fmt.Errorf("fetching alert: %w", err)
+	}
 
-		if err := db.ResolveAlert(ctx, alert.ID); err != nil {
-			log.Printf("[watcher] error resolving alert %s: %v", alert.ID, err)
-			continue
+	downtime := w.clock.Now().Sub(alert.FiredAt)
+
+	if err := w.store.ResolveAlert(ctx, alert.ID); err != nil {
+		return fmt.Errorf("resolving alert %s: %w", alert.ID, err)
+	}
+
+	// A suppressed alert was never sent, so there is nothing to recover from
+	if !alert.Suppressed && !w.detectFlapping(ctx, om) {
+		msg := fmt.Sprintf("🟢 *RECOVERED: %s (%s) is back UP*\nWas down for: %s%s",
+			om.MonitorName, om.CheckType, formatDuration(downtime), acknowledgedBy(alert))
+		w.notify(ctx, om, "recovered", msg)
+	}
+
+	// Children of a recovered parent either came back with it or now alert on their own
+	return w.reevaluateChildren(ctx, om.ID)
+}
+
+// reevaluateChildren resolves or un-suppresses the alerts of children that
+// were suppressed while the parent was down.
+func (w *Watcher) reevaluateChildren(ctx context.Context, parentID string) error {
+	children, err := w.store.GetSuppressedChildren(ctx, parentID)
+	if err != nil {
+		return fmt.Errorf("fetching children: %w", err)
+	}
+
+	for _, child := range children {
+		if child.Status == "down" {
+			err = w.handleOverdue(ctx, child)
+		} else {
+			err = w.handleRecovered(ctx, child)
+		}
+		if err != nil {
+			return fmt.Errorf("child %s: %w", child.ID, err)
 		}
+	}
+	return nil
+}
+
+// affectedChildren lists the down children rolled into a parent's notification.
+func (w *Watcher) affectedChildren(ctx context.Context, parentID string) string {
+	children, err := w.store.GetDownChildren(ctx, parentID)
+	if err != nil {
+		log.Printf("[watcher] error fetching children of monitor %s: %v", parentID, err)
+		return ""
+	}
+	if len(children) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nAlso affected (%d):", len(children))
+	for _, child := range children {
+		fmt.Fprintf(&b, "\n• %s (%s)", child.MonitorName, child.CheckType)
+	}
+	return b.String()
+}
+
+type outboxKey struct{}
+
+type notification struct {
+	om        store.OverdueMonitor
+	alertType string
+	msg       string
+}
+
+// transition runs a state change in a single transaction. Notifications queued
+// by fn are only sent once the transaction committed, so a rolled back change
+// never pages anyone.
+func (w *Watcher) transition(ctx context.Context, fn func(ctx context.Context) error) error {
+	var outbox []notification
+	if err := w.store.InTx(context.WithValue(ctx, outboxKey{}, &outbox), fn); err != nil {
+		return err
+	}
+
+	// The change is committed, deliver its notifications even if we are shutting down
+	ctx = context.WithoutCancel(ctx)
+	for _, n := range outbox {
+		w.notify(ctx, n.om, n.alertType, n.msg)
+	}
+	return nil
+}
+
+// notify sends a message to the monitor's Telegram chat and records the outcome
+// in the notification log. With grouping enabled the message is queued and
+// sent as part of its group's digest instead. Inside a transition it is held
+// back until the transaction commits.
+func (w *Watcher) notify(ctx context.Context, om store.OverdueMonitor, alertType, msg string) {
+	if om.Silenced(w.clock.Now()) {
+		log.Printf("[watcher] %s notification for %s (%s) not sent, silenced until %s",
+			alertType, om.MonitorName, om.CheckType, om.SilencedUntil.Format(time.RFC3339))
+		return
+	}
+
+	if outbox, ok := ctx.Value(outboxKey{}).(*[]notification); ok {
+		*outbox = append(*outbox, notification{om: om, alertType: alertType, msg: msg})
+		return
+	}
 
-		msg := fmt.Sprintf("🟢 *RECOVERED: %s (%s) is back UP*\nWas down for: %s",
-			om.MonitorName, om.CheckType, db.FormatDuration(downtime))
-		success, errMsg := notifier.SendTelegram(om.TelegramChatID, msg)
-		db.CreateNotificationLog(ctx, om.ID, om.ChannelID, "recovered", msg, success, errMsg)
+	if len(w.groupBy) > 0 {
+		w.enqueue(om, alertType, msg)
+		return
+	}
+
+	success, errMsg := w.Send(om.TelegramChatID, msg)
+	w.store.CreateNotificationLog(ctx, om.ID, om.ChannelID, alertType, msg, success, errMsg)
+}
+
+// acknowledgedBy names who acknowledged an alert, for recovery messages.
+func acknowledgedBy(a *model.AlertState) string {
+	if a.AcknowledgedBy == nil {
+		return ""
+	}
+	return "\nAcknowledged by: " + markdownEscaper.Replace(*a.AcknowledgedBy)
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm %ds", int(d.Minutes()), int(d.Seconds())%60)
 	}
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
 }
@@ -0,0 +1,301 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mohsen/alertinGo/clock"
+	"github.com/mohsen/alertinGo/model"
+	"github.com/mohsen/alertinGo/store"
+	"github.com/mohsen/alertinGo/store/memory"
+)
+
+// fixture is a watcher on a memory store, driven by a fake clock. Tests call
+// reconcile themselves unless they start the loops.
+type fixture struct {
+	t     *testing.T
+	ctx   context.Context
+	clock *clock.Fake
+	store *memory.Store
+	w     *Watcher
+	sent  chan string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	for _, key := range []string{"FLAP_WINDOW", "FLAP_THRESHOLD", "ALERT_GROUP_BY"} {
+		t.Setenv(key, "")
+	}
+
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	st := memory.New(clk)
+	f := &fixture{t: t, ctx: store.Unscoped(context.Background()), clock: clk, store: st, sent: make(chan string, 100)}
+	f.w = New(st, clk)
+	f.w.elector = store.SingleInstance{}
+	f.w.Send = func(chatID, msg string) (bool, string) {
+		f.sent <- msg
+		return true, ""
+	}
+	return f
+}
+
+// monitor creates an active monitor with a channel that misses its deadline
+// after timeout seconds.
+func (f *fixture) monitor(timeout, reAlert int) *model.Monitor {
+	f.t.Helper()
+	return f.named("web-1", timeout, reAlert)
+}
+
+// named is monitor with a name.
+func (f *fixture) named(name string, timeout, reAlert int) *model.Monitor {
+	f.t.Helper()
+	ch, err := f.store.CreateChannel(f.ctx, "ops", "chat")
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	m := f.heartbeat(&model.Monitor{MonitorName: name, CheckType: "http", Metadata: "{}",
+		Timeout: timeout, ReAlertInterval: reAlert, AlertAfterMisses: 1, RecoverAfterHeartbeats: 1})
+	if m, err = f.store.UpdateMonitor(f.ctx, m.ID, true, &ch.ID); err != nil {
+		f.t.Fatal(err)
+	}
+	return m
+}
+
+func (f *fixture) heartbeat(m *model.Monitor) *model.Monitor {
+	f.t.Helper()
+	m, err := f.store.UpsertMonitor(f.ctx, m)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return m
+}
+
+// reconcileAfter advances the clock by d and runs a polling pass.
+func (f *fixture) reconcileAfter(d time.Duration) {
+	f.clock.Advance(d)
+	f.w.reconcile(f.ctx)
+}
+
+// expect checks the notifications sent since the last call, by a substring
+// of each.
+func (f *fixture) expect(want ...string) {
+	f.t.Helper()
+	var got []string
+	for len(f.sent) > 0 {
+		got = append(got, <-f.sent)
+	}
+	if len(got) != len(want) {
+		f.t.Fatalf("sent %d notifications, want %d: %q", len(got), len(want), got)
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			f.t.Fatalf("notification %d is %q, want it to contain %q", i, got[i], want[i])
+		}
+	}
+}
+
+func (f *fixture) status(id string) string {
+	f.t.Helper()
+	m, err := f.store.GetMonitorByID(f.ctx, id)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return m.Status
+}
+
+func TestMissedDeadline(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+	m.AlertAfterMisses = 2
+	m = f.heartbeat(m)
+
+	f.reconcileAfter(119 * time.Second)
+	f.expect()
+
+	f.reconcileAfter(2 * time.Second)
+	f.expect("is DOWN")
+	if s := f.status(m.ID); s != "down" {
+		t.Fatalf("status is %s, want down", s)
+	}
+}
+
+func TestDeadlineSchedulerAlerts(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+
+	f.w.Start(f.ctx)
+	defer f.w.Stop()
+	f.w.Touch(m)
+
+	// The scheduler sleeps on its first empty queue and then on the deadline,
+	// the reconcile loop on its tick
+	deadline := time.Now().Add(5 * time.Second)
+	for f.clock.Waiters() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("loops did not go to sleep, %d waiters", f.clock.Waiters())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	f.clock.Advance(61 * time.Second)
+
+	select {
+	case msg := <-f.sent:
+		if !strings.Contains(msg, "is DOWN") {
+			t.Fatalf("sent %q, want an alert", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert sent")
+	}
+}
+
+func TestReAlert(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+
+	f.reconcileAfter(61 * time.Second)
+	f.expect("is DOWN")
+
+	f.reconcileAfter(298 * time.Second)
+	f.expect()
+
+	f.reconcileAfter(3 * time.Second)
+	f.expect("RE-ALERT")
+
+	if _, err := f.store.AcknowledgeAlerts(f.ctx, m.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	f.reconcileAfter(301 * time.Second)
+	f.expect()
+}
+
+func TestRecovery(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+	m.RecoverAfterHeartbeats = 2
+
+	f.reconcileAfter(61 * time.Second)
+	f.expect("is DOWN")
+
+	f.clock.Advance(30 * time.Second)
+	f.heartbeat(m)
+	f.reconcileAfter(0)
+	f.expect()
+	if s := f.status(m.ID); s != "down" {
+		t.Fatalf("status is %s after one heartbeat, want down", s)
+	}
+
+	f.heartbeat(m)
+	f.reconcileAfter(0)
+	f.expect("back UP*\nWas down for: 30s")
+	if _, err := f.store.GetFiringAlert(f.ctx, m.ID); err != store.ErrNotFound {
+		t.Fatalf("alert still firing: %v", err)
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor(60, 300)
+
+	// Every outage and recovery is a state change, the sixth within the
+	// window marks the monitor as flapping
+	for range 2 {
+		f.reconcileAfter(61 * time.Second)
+		f.expect("is DOWN")
+		f.heartbeat(m)
+		f.reconcileAfter(0)
+		f.expect("back UP")
+	}
+	f.reconcileAfter(61 * time.Second)
+	f.expect("is DOWN")
+	f.heartbeat(m)
+	f.reconcileAfter(0)
+	f.expect("FLAPPING")
+
+	// Alerts are paused while it flaps
+	f.reconcileAfter(61 * time.Second)
+	f.expect()
+
+	// Once the window holds no more changes it is stable again
+	f.reconcileAfter(10 * time.Minute)
+	f.expect("stopped flapping*\n🔴 currently DOWN")
+}
+
+// dependents creates a parent monitor, its child and their grandchild.
+func (f *fixture) dependents() (parent, child, grandchild *model.Monitor) {
+	f.t.Helper()
+	parent, child, grandchild = f.named("router", 60, 300), f.named("app", 60, 300), f.named("worker", 60, 300)
+	for _, dep := range [][2]*model.Monitor{{child, parent}, {grandchild, child}} {
+		if err := f.store.AddMonitorParent(f.ctx, dep[0].ID, dep[1].ID); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	return parent, child, grandchild
+}
+
+func (f *fixture) alert(id string) *model.AlertState {
+	f.t.Helper()
+	a, err := f.store.GetFiringAlert(f.ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return a
+}
+
+func TestChildrenSuppressedWhileParentDown(t *testing.T) {
+	f := newFixture(t)
+	parent, child, grandchild := f.dependents()
+
+	f.reconcileAfter(61 * time.Second)
+	f.expect("router (http) is DOWN*\nLast seen: 1m 1s ago\nTimeout: 60s\nMessage: \nAlso affected (1):\n• app (http)")
+	for _, m := range []*model.Monitor{child, grandchild} {
+		if a := f.alert(m.ID); a == nil || !a.Suppressed {
+			t.Fatalf("alert of %s is %+v, want a suppressed one", m.MonitorName, a)
+		}
+	}
+
+	// Only the parent re-alerts
+	f.reconcileAfter(300 * time.Second)
+	f.expect("RE-ALERT: router (http)")
+
+	// The child alerts on its own once the parent is back, the grandchild
+	// stays suppressed while the child is down
+	f.heartbeat(parent)
+	f.reconcileAfter(0)
+	f.expect("app (http) is DOWN*\nLast seen: 6m 1s ago\nParent monitor recovered, this one did not", "router (http) is back UP")
+	if a := f.alert(child.ID); a == nil || a.Suppressed {
+		t.Fatalf("alert of app is %+v, want an unsuppressed one", a)
+	}
+	if a := f.alert(grandchild.ID); a == nil || !a.Suppressed {
+		t.Fatalf("alert of worker is %+v, want a suppressed one", a)
+	}
+
+	f.heartbeat(child)
+	f.reconcileAfter(0)
+	f.expect("worker (http) is DOWN*\nLast seen: 6m 1s ago\nParent monitor recovered", "app (http) is back UP")
+}
+
+func TestChildrenRecoverWithParent(t *testing.T) {
+	f := newFixture(t)
+	parent, child, grandchild := f.dependents()
+
+	f.reconcileAfter(61 * time.Second)
+	f.expect("router (http) is DOWN")
+
+	// Alerts that were never sent are resolved without a recovery message
+	for _, m := range []*model.Monitor{parent, child, grandchild} {
+		f.heartbeat(m)
+	}
+	f.reconcileAfter(0)
+	f.expect("router (http) is back UP*\nWas down for: 0s")
+	for _, m := range []*model.Monitor{child, grandchild} {
+		if a := f.alert(m.ID); a != nil {
+			t.Fatalf("alert of %s still firing: %+v", m.MonitorName, a)
+		}
+	}
+}